@@ -2,18 +2,33 @@ package main
 
 import (
 	"bytes"
-	"compress/gzip"
+	"context"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"github.com/hashicorp/go-cleanhttp"
 	"github.com/hashicorp/go-retryablehttp"
+	"github.com/labstack/echo/v4"
+	"github.com/lionslon/go-yapmetrics/internal/codec"
 	"github.com/lionslon/go-yapmetrics/internal/config"
+	"github.com/lionslon/go-yapmetrics/internal/encryption"
 	"github.com/lionslon/go-yapmetrics/internal/middlewares"
 	"github.com/lionslon/go-yapmetrics/internal/models"
+	"github.com/lionslon/go-yapmetrics/internal/tlsutil"
 	"github.com/shirou/gopsutil/v4/cpu"
 	"github.com/shirou/gopsutil/v4/mem"
 	"io"
+	"log"
 	"math/rand"
+	"net"
+	"net/http"
+	"os"
 	"runtime"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
@@ -28,6 +43,14 @@ func main() {
 
 	config.PrintBuildInfo()
 	cfg := config.NewClient()
+	enc, err := newEncoder(cfg)
+	if err != nil {
+		panic(err)
+	}
+	client, err := newClient(cfg)
+	if err != nil {
+		panic(err)
+	}
 	var wg sync.WaitGroup
 
 	pollTicker := time.NewTicker(time.Duration(cfg.PollInterval) * time.Second)
@@ -60,7 +83,7 @@ func main() {
 		for range reportTicker.C {
 			limitChan <- struct{}{}
 			go func() {
-				postQueries(cfg)
+				postQueries(cfg, client, enc)
 				<-limitChan
 			}()
 		}
@@ -122,116 +145,254 @@ func getExtraMetrics() {
 
 }
 
-func postQueries(cfg *config.ClientConfig) {
-	mu.Lock()
-	defer mu.Unlock()
-
-	url := fmt.Sprintf("http://%s/update/", cfg.Addr)
-	urlBatch := fmt.Sprintf("http://%s/updates/", cfg.Addr)
+// newClient создает клиента для отправки метрик, при включенном TLS с нужными сертификатами
+func newClient(cfg *config.ClientConfig) (*retryablehttp.Client, error) {
 	client := retryablehttp.NewClient()
 	client.RetryMax = 3
 	client.RetryWaitMin = time.Second * 1
 	client.RetryWaitMax = time.Second * 5
+	client.CheckRetry = checkResponseSign(cfg.SignPass)
+	sign := signRequest(cfg.SignPass, cfg.KeyID)
+	tokens := &tokenSource{token: cfg.Token, path: cfg.TokenFile}
+	client.RequestLogHook = func(l retryablehttp.Logger, req *http.Request, attempt int) {
+		setRealIP(req)
+		if token := tokens.Token(); token != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		}
+		sign(l, req, attempt)
+	}
+	if cfg.TLSEnabled() {
+		tlsCfg, err := tlsutil.NewClientConfig(cfg.TLSOptions())
+		if err != nil {
+			return nil, err
+		}
+		transport := cleanhttp.DefaultPooledTransport()
+		transport.TLSClientConfig = tlsCfg
+		client.HTTPClient.Transport = transport
+	}
+	return client, nil
+}
+
+// tokenSource токен доступа агента. Токен из файла перечитывается при изменении файла,
+// чтобы внешний процесс мог обновлять JWT без перезапуска агента
+type tokenSource struct {
+	mu      sync.Mutex
+	token   string
+	path    string
+	modTime time.Time
+}
+
+// Token возвращает актуальный токен, при ошибке чтения файла остается прежний
+func (s *tokenSource) Token() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.path == "" {
+		return s.token
+	}
+	info, err := os.Stat(s.path)
+	if err != nil {
+		log.Printf("failed to stat token file: %v", err)
+		return s.token
+	}
+	if info.ModTime().Equal(s.modTime) {
+		return s.token
+	}
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		log.Printf("failed to read token file: %v", err)
+		return s.token
+	}
+	s.token = strings.TrimSpace(string(data))
+	s.modTime = info.ModTime()
+	return s.token
+}
+
+// baseURL адрес сервера со схемой
+func baseURL(cfg *config.ClientConfig) string {
+	if cfg.TLSEnabled() {
+		return "https://" + cfg.Addr
+	}
+	return "http://" + cfg.Addr
+}
+
+func postQueries(cfg *config.ClientConfig, client *retryablehttp.Client, enc *encoder) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	url := baseURL(cfg) + "/update/"
+	urlBatch := baseURL(cfg) + "/updates/"
 
 	var payload []models.Metrics
 
 	for k, v := range valuesGauge {
+		v := v
 		payload = append(payload, models.Metrics{ID: k, MType: "gauge", Value: &v})
 	}
-	postJSONBatch(client, urlBatch, payload, cfg.SignPass)
+	if err := post(client, urlBatch, payload, enc); err != nil {
+		log.Printf("failed to deliver gauges: %v", err)
+	}
 	pc := int64(pollCount)
-	err := postJSON(client, url, models.Metrics{ID: "PollCount", MType: "counter", Delta: &pc}, cfg.SignPass)
+	err := post(client, url, models.Metrics{ID: "PollCount", MType: "counter", Delta: &pc}, enc)
 	if err != nil {
+		log.Printf("failed to deliver PollCount: %v", err)
+	}
+	// ответ без верной подписи означает, что сервер запрос уже принял
+	if err == nil || errors.Is(err, errUnverifiedResponse) {
 		pollCount = 0
 	}
 	r := rand.Float64()
-	postJSON(client, url, models.Metrics{ID: "RandomValue", MType: "gauge", Value: &r}, cfg.SignPass)
+	if err := post(client, url, models.Metrics{ID: "RandomValue", MType: "gauge", Value: &r}, enc); err != nil {
+		log.Printf("failed to deliver RandomValue: %v", err)
+	}
 }
 
-func postJSON(c *retryablehttp.Client, url string, m models.Metrics, password string) error {
-	js, err := json.Marshal(m)
-	if err != nil {
-		return err
-	}
+// errUnverifiedResponse ответ сервера не прошел проверку подписи. Запрос уже применен сервером,
+// поэтому он не повторяется: иначе приращения counter-метрик учлись бы дважды
+var errUnverifiedResponse = errors.New("response signature is not valid")
+
+// checkResponseSign проверяет подпись ответа в заголовке HashSHA256, неверная подпись
+// не повторяет запрос, а возвращает errUnverifiedResponse
+func checkResponseSign(password string) retryablehttp.CheckRetry {
+	return func(ctx context.Context, resp *http.Response, err error) (bool, error) {
+		retry, checkErr := retryablehttp.DefaultRetryPolicy(ctx, resp, err)
+		if retry || checkErr != nil || resp == nil || password == "" {
+			return retry, checkErr
+		}
 
-	gz, err := compress(js)
-	if err != nil {
-		return err
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			log.Printf("failed to read response from %s: %v", resp.Request.URL, err)
+			return false, fmt.Errorf("%w: %v", errUnverifiedResponse, err)
+		}
+		resp.Body = io.NopCloser(bytes.NewReader(body))
+
+		if !middlewares.VerifySign(body, []byte(password), resp.Header.Get("HashSHA256")) {
+			log.Printf("response signature from %s is not valid", resp.Request.URL)
+			return false, errUnverifiedResponse
+		}
+		return false, nil
 	}
+}
+
+// signedBodyKey ключ контекста запроса с несжатым телом для подписи
+type signedBodyKey struct{}
+
+// encoder готовит тело запроса к отправке: сжимает и, если задан открытый ключ сервера, шифрует
+type encoder struct {
+	codec codec.Codec
+	level int
+	key   *rsa.PublicKey
+}
 
-	req, err := retryablehttp.NewRequest("POST", url, gz)
+func newEncoder(cfg *config.ClientConfig) (*encoder, error) {
+	cdc, err := codec.Get(cfg.Codec)
 	if err != nil {
-		return err
+		return nil, err
 	}
-
-	singPassword := []byte(password)
-	if singPassword != nil {
-		req.Header.Add("HashSHA256", middlewares.GetSign(js, singPassword))
+	if err := codec.CheckLevel(cdc, cfg.CodecLevel); err != nil {
+		return nil, err
+	}
+	enc := &encoder{codec: cdc, level: cfg.CodecLevel}
+	if cfg.CryptoKey != "" {
+		if enc.key, err = encryption.LoadPublicKey(cfg.CryptoKey); err != nil {
+			return nil, err
+		}
 	}
+	return enc, nil
+}
 
-	req.Header.Add("content-type", "application/json")
-	req.Header.Add("content-encoding", "gzip")
-	resp, err := c.Do(req)
+// encode сжимает тело до шифрования, потому что зашифрованные данные не сжимаются
+func (e *encoder) encode(req *http.Request, b []byte) ([]byte, error) {
+	out, err := codec.Compress(e.codec, b, e.level)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return err
+	req.Header.Set(echo.HeaderContentEncoding, e.codec.Name())
+	if e.key == nil {
+		return out, nil
 	}
-	req.Body = io.NopCloser(bytes.NewReader(body))
-
-	defer resp.Body.Close()
-	return nil
+	req.Header.Set(encryption.HeaderEncryption, encryption.Scheme)
+	return encryption.Encrypt(e.key, out)
 }
 
-func postJSONBatch(c *retryablehttp.Client, url string, m []models.Metrics, password string) error {
-	js, err := json.Marshal(m)
+// post сжимает и отправляет payload, подпись выставляет signRequest перед каждой попыткой
+func post(c *retryablehttp.Client, url string, payload any, enc *encoder) error {
+	js, err := json.Marshal(payload)
 	if err != nil {
 		return err
 	}
 
-	gz, err := compress(js)
+	ctx := context.WithValue(context.Background(), signedBodyKey{}, js)
+	req, err := retryablehttp.NewRequestWithContext(ctx, "POST", url, nil)
 	if err != nil {
 		return err
 	}
-
-	req, err := retryablehttp.NewRequest("POST", url, gz)
+	body, err := enc.encode(req.Request, js)
 	if err != nil {
 		return err
 	}
-
-	singPassword := []byte(password)
-	if singPassword != nil {
-		req.Header.Add("HashSHA256", middlewares.GetSign(js, singPassword))
+	if err = req.SetBody(body); err != nil {
+		return err
 	}
 
 	req.Header.Add("content-type", "application/json")
-	req.Header.Add("content-encoding", "gzip")
 	resp, err := c.Do(req)
 	if err != nil {
 		return err
 	}
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
+	defer resp.Body.Close()
+	if _, err = io.Copy(io.Discard, resp.Body); err != nil {
 		return err
 	}
-	req.Body = io.NopCloser(bytes.NewReader(body))
-
-	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("%s responded with %s", url, resp.Status)
+	}
 	return nil
 }
 
-func compress(b []byte) ([]byte, error) {
-	var bf bytes.Buffer
-	gz, err := gzip.NewWriterLevel(&bf, gzip.BestSpeed)
-	if err != nil {
-		return nil, err
+// setRealIP сообщает серверу в X-Real-IP адрес интерфейса, через который агент ходит к серверу
+func setRealIP(req *http.Request) {
+	// UDP-сокет не отправляет пакетов, а только выбирает маршрут и локальный адрес
+	host := req.URL.Host
+	if req.URL.Port() == "" {
+		port := "80"
+		if req.URL.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(req.URL.Hostname(), port)
 	}
-	_, err = gz.Write(b)
+	conn, err := net.Dial("udp", host)
 	if err != nil {
-		return nil, err
+		log.Printf("failed to detect outbound IP: %v", err)
+		return
+	}
+	defer conn.Close()
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		req.Header.Set(echo.HeaderXRealIP, addr.IP.String())
+	}
+}
+
+// signRequest подписывает каждую попытку отправки свежими меткой времени и nonce,
+// чтобы повторная отправка не отклонялась сервером как повтор запроса
+func signRequest(password string, keyID string) retryablehttp.RequestLogHook {
+	return func(_ retryablehttp.Logger, req *http.Request, _ int) {
+		body, ok := req.Context().Value(signedBodyKey{}).([]byte)
+		if password == "" || !ok {
+			return
+		}
+		nonce := make([]byte, 16)
+		if _, err := cryptorand.Read(nonce); err != nil {
+			log.Printf("failed to generate nonce: %v", err)
+			return
+		}
+		ts := strconv.FormatInt(time.Now().Unix(), 10)
+		n := hex.EncodeToString(nonce)
+		if keyID != "" {
+			req.Header.Set(middlewares.HeaderKeyID, keyID)
+		}
+		req.Header.Set(middlewares.HeaderSignTimestamp, ts)
+		req.Header.Set(middlewares.HeaderSignNonce, n)
+		req.Header.Set("HashSHA256", middlewares.GetSign(middlewares.SignMaterial(req.Method, req.URL.RequestURI(), body, ts, n), []byte(password)))
 	}
-	gz.Close()
-	return bf.Bytes(), nil
 }
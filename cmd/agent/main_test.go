@@ -1,8 +1,192 @@
 package main
 
 import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/labstack/echo/v4"
+	"github.com/lionslon/go-yapmetrics/internal/codec"
+	"github.com/lionslon/go-yapmetrics/internal/config"
+	"github.com/lionslon/go-yapmetrics/internal/encryption"
+	"github.com/lionslon/go-yapmetrics/internal/middlewares"
+	"github.com/lionslon/go-yapmetrics/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestWebhook(t *testing.T) {
 }
+
+func TestCheckResponseSign(t *testing.T) {
+	const key = "secret"
+	body := []byte(`{"status":"success"}`)
+
+	testCases := []struct {
+		name   string
+		sign   string
+		status int
+		retry  bool
+		err    error
+	}{
+		{name: "valid", sign: middlewares.GetSign(body, []byte(key)), status: http.StatusOK, retry: false},
+		{name: "wrong key", sign: middlewares.GetSign(body, []byte("other")), status: http.StatusOK, err: errUnverifiedResponse},
+		{name: "missing", sign: "", status: http.StatusOK, err: errUnverifiedResponse},
+		{name: "server error", sign: "", status: http.StatusInternalServerError, retry: true},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if test.sign != "" {
+					w.Header().Set("HashSHA256", test.sign)
+				}
+				w.WriteHeader(test.status)
+				w.Write(body)
+			}))
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL)
+			require.NoError(t, err)
+			defer resp.Body.Close()
+
+			retry, err := checkResponseSign(key)(context.Background(), resp, nil)
+			assert.ErrorIs(t, err, test.err)
+			assert.Equal(t, test.retry, retry)
+		})
+	}
+}
+
+func TestPostUnverifiedResponse(t *testing.T) {
+	attempts := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.Header().Set("HashSHA256", middlewares.GetSign([]byte("{}"), []byte("other")))
+		w.Write([]byte("{}"))
+	}))
+	defer srv.Close()
+
+	enc, err := newEncoder(&config.ClientConfig{Codec: "gzip"})
+	require.NoError(t, err)
+	client := retryablehttp.NewClient()
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	client.CheckRetry = checkResponseSign("secret")
+
+	pc := int64(1)
+	err = post(client, srv.URL+"/update/", models.Metrics{ID: "PollCount", MType: "counter", Delta: &pc}, enc)
+	assert.ErrorIs(t, err, errUnverifiedResponse)
+	assert.Equal(t, 1, attempts, "applied request is not retried")
+}
+
+func TestSignRequestRetry(t *testing.T) {
+	const key = "secret"
+	attempts := 0
+	e := echo.New()
+	e.Use(middlewares.Decompress(middlewares.DecompressConfig{}))
+	e.Use(middlewares.CheckSignReq(middlewares.SignConfig{
+		Key:          key,
+		Mode:         middlewares.SignStrict,
+		ReplayWindow: time.Minute,
+		Nonces:       middlewares.NewNonceCache(10, 2*time.Minute),
+	}))
+	e.POST("/update/", func(ctx echo.Context) error {
+		attempts++
+		if attempts == 1 {
+			return ctx.NoContent(http.StatusInternalServerError)
+		}
+		return ctx.NoContent(http.StatusOK)
+	})
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	enc, err := newEncoder(&config.ClientConfig{Codec: "gzip"})
+	require.NoError(t, err)
+	client := retryablehttp.NewClient()
+	client.RetryMax = 2
+	client.RetryWaitMin = time.Millisecond
+	client.RetryWaitMax = time.Millisecond
+	client.RequestLogHook = signRequest(key, "")
+
+	pc := int64(1)
+	err = post(client, srv.URL+"/update/", models.Metrics{ID: "PollCount", MType: "counter", Delta: &pc}, enc)
+	require.NoError(t, err)
+	assert.Equal(t, 2, attempts, "retry is signed with a fresh nonce and accepted")
+}
+
+func TestPostEncrypted(t *testing.T) {
+	privPEM, pubPEM, err := encryption.GenerateKeys(2048)
+	require.NoError(t, err)
+	priv, err := encryption.ParsePrivateKey(privPEM)
+	require.NoError(t, err)
+	pubPath := filepath.Join(t.TempDir(), "public.pem")
+	require.NoError(t, os.WriteFile(pubPath, pubPEM, 0o644))
+
+	// обработчик работает в горутине сервера, тело проверяется в горутине теста
+	bodies := make(chan []byte, 1)
+	e := echo.New()
+	e.Use(middlewares.Decrypt(priv))
+	e.Use(middlewares.Decompress(middlewares.DecompressConfig{}))
+	e.POST("/update/", func(ctx echo.Context) error {
+		body, err := io.ReadAll(ctx.Request().Body)
+		if err != nil {
+			return err
+		}
+		bodies <- body
+		return ctx.NoContent(http.StatusOK)
+	})
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	enc, err := newEncoder(&config.ClientConfig{Codec: "zstd", CryptoKey: pubPath})
+	require.NoError(t, err)
+	pc := int64(1)
+	require.NoError(t, post(retryablehttp.NewClient(), srv.URL+"/update/", models.Metrics{ID: "PollCount", MType: "counter", Delta: &pc}, enc))
+	require.Len(t, bodies, 1)
+	assert.JSONEq(t, `{"id":"PollCount","type":"counter","delta":1}`, string(<-bodies))
+}
+
+func TestNewEncoderLevel(t *testing.T) {
+	_, err := newEncoder(&config.ClientConfig{Codec: "gzip", CodecLevel: 10})
+	assert.ErrorIs(t, err, codec.ErrInvalidLevel)
+	_, err = newEncoder(&config.ClientConfig{Codec: "zstd", CodecLevel: 19})
+	assert.NoError(t, err)
+}
+
+func TestRealIP(t *testing.T) {
+	subnets, err := middlewares.ParseSubnets("127.0.0.0/8")
+	require.NoError(t, err)
+	e := echo.New()
+	e.POST("/update/", func(ctx echo.Context) error {
+		assert.Equal(t, "127.0.0.1", ctx.Request().Header.Get(echo.HeaderXRealIP))
+		return ctx.NoContent(http.StatusOK)
+	}, middlewares.TrustedSubnet(middlewares.SubnetConfig{Subnets: subnets, Source: middlewares.RealIPHeader, Proxies: subnets}))
+	srv := httptest.NewServer(e)
+	defer srv.Close()
+
+	enc, err := newEncoder(&config.ClientConfig{Codec: "gzip"})
+	require.NoError(t, err)
+	client, err := newClient(&config.ClientConfig{})
+	require.NoError(t, err)
+	pc := int64(1)
+	require.NoError(t, post(client, srv.URL+"/update/", models.Metrics{ID: "PollCount", MType: "counter", Delta: &pc}, enc))
+}
+
+func TestTokenSource(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "token")
+	require.NoError(t, os.WriteFile(path, []byte("first\n"), 0o600))
+	src := &tokenSource{token: "static", path: path}
+	assert.Equal(t, "first", src.Token())
+
+	require.NoError(t, os.WriteFile(path, []byte("second"), 0o600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Minute)))
+	assert.Equal(t, "second", src.Token())
+
+	require.NoError(t, os.Remove(path))
+	assert.Equal(t, "second", src.Token(), "last token is kept when the file is gone")
+}
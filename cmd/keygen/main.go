@@ -0,0 +1,40 @@
+// Команда keygen создает пару ключей RSA для шифрования тела запросов агента:
+// закрытый ключ передается серверу, открытый — агентам через флаг -crypto-key
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/lionslon/go-yapmetrics/internal/encryption"
+)
+
+func main() {
+	bits := flag.Int("bits", encryption.DefaultBits, "RSA key size in bits")
+	dir := flag.String("out", ".", "directory for private.pem and public.pem")
+	flag.Parse()
+
+	priv, pub, err := encryption.GenerateKeys(*bits)
+	if err != nil {
+		log.Fatal(err)
+	}
+	privPath := filepath.Join(*dir, "private.pem")
+	pubPath := filepath.Join(*dir, "public.pem")
+	// закрытый ключ доступен только владельцу и не перезаписывается
+	f, err := os.OpenFile(privPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err = f.Write(priv); err != nil {
+		log.Fatal(err)
+	}
+	if err = f.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if err = os.WriteFile(pubPath, pub, 0o644); err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("private key written to %s, public key to %s", privPath, pubPath)
+}
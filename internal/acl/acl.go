@@ -0,0 +1,157 @@
+// Package acl ограничивает запись метрик: правила связывают идентичность клиента
+// с разрешенными префиксами имен и значениями меток
+package acl
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lionslon/go-yapmetrics/internal/models"
+)
+
+// Виды идентичности клиента в правилах: key:<id ключа подписи>, token:<id токена>, cert:<агент из mTLS>
+const (
+	KindKey   = "key"
+	KindToken = "token"
+	KindCert  = "cert"
+	// Anyone правило для всех клиентов
+	Anyone = "*"
+	// DefaultKeyID идентификатор ключа подписи по умолчанию, запрос без заголовка Key-Id
+	DefaultKeyID = "default"
+)
+
+// ContextSubject ключ echo.Context с субъектом проверки записи
+const ContextSubject = "aclSubject"
+
+// Identity идентичность вида kind:id
+func Identity(kind string, id string) string {
+	return kind + ":" + id
+}
+
+// Rule правило доступа. Метрика разрешена, если имя начинается с одного из Prefixes
+// и каждая метка из Labels имеет одно из перечисленных значений как в записи, так и у уже
+// сохраненной метрики. Значение такой метки у сохраненной метрики менять нельзя. Пустое условие не ограничивает
+type Rule struct {
+	Identity string              `json:"identity"`
+	Prefixes []string            `json:"prefixes,omitempty"`
+	Labels   map[string][]string `json:"labels,omitempty"`
+}
+
+// allows проверяет метрику по правилу, stored метки уже сохраненной метрики.
+// Запись без меток оставляет сохраненные метки, поэтому проверяются они
+func (r *Rule) allows(m models.Metrics, stored map[string]string) bool {
+	if len(r.Prefixes) > 0 {
+		matched := false
+		for _, p := range r.Prefixes {
+			if strings.HasPrefix(m.ID, p) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	effective := m.Labels
+	if effective == nil {
+		effective = stored
+	}
+	for key, values := range r.Labels {
+		if old, ok := stored[key]; ok && (!contains(values, old) || effective[key] != old) {
+			return false
+		}
+		got, ok := effective[key]
+		if !ok || !contains(values, got) {
+			return false
+		}
+	}
+	return true
+}
+
+func contains(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// ACL набор правил
+type ACL struct {
+	rules map[string][]Rule
+}
+
+// New собирает ACL из правил
+func New(rules []Rule) (*ACL, error) {
+	a := &ACL{rules: map[string][]Rule{}}
+	for i, r := range rules {
+		if r.Identity != Anyone {
+			kind, id, ok := strings.Cut(r.Identity, ":")
+			if !ok || id == "" || (kind != KindKey && kind != KindToken && kind != KindCert) {
+				return nil, fmt.Errorf("rule #%d: identity %q must be *, key:<id>, token:<id> or cert:<id>", i, r.Identity)
+			}
+		}
+		a.rules[r.Identity] = append(a.rules[r.Identity], r)
+	}
+	return a, nil
+}
+
+// Load читает правила из JSON-файла, пустой путь отключает ACL
+func Load(path string) (*ACL, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse ACL %s: %w", path, err)
+	}
+	return New(rules)
+}
+
+// Allowed сообщает, что хотя бы одно правило любой из идентичностей клиента разрешает запись метрики,
+// stored метки уже сохраненной метрики, nil для новой
+func (a *ACL) Allowed(identities []string, m models.Metrics, stored map[string]string) bool {
+	if a.allowedFor(Anyone, m, stored) {
+		return true
+	}
+	for _, id := range identities {
+		if a.allowedFor(id, m, stored) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *ACL) allowedFor(identity string, m models.Metrics, stored map[string]string) bool {
+	for i := range a.rules[identity] {
+		if a.rules[identity][i].allows(m, stored) {
+			return true
+		}
+	}
+	return false
+}
+
+// Subject клиент запроса и правила, по которым проверяется его запись
+type Subject struct {
+	ACL        *ACL
+	Identities []string
+}
+
+// Check возвращает ошибку, если запись метрики с сохраненными метками stored запрещена
+func (s Subject) Check(m models.Metrics, stored map[string]string) error {
+	if s.ACL.Allowed(s.Identities, m, stored) {
+		return nil
+	}
+	who := "anonymous client"
+	if len(s.Identities) > 0 {
+		who = strings.Join(s.Identities, ", ")
+	}
+	return fmt.Errorf("%s may not write %s metric %q", who, m.MType, m.ID)
+}
@@ -0,0 +1,55 @@
+package acl
+
+import (
+	"testing"
+
+	"github.com/lionslon/go-yapmetrics/internal/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestACL(t *testing.T) {
+	a, err := New([]Rule{
+		{Identity: "key:team-a", Prefixes: []string{"teamA."}},
+		{Identity: "token:grafana-agent", Labels: map[string][]string{"team": {"b", "c"}}},
+		{Identity: "cert:host-1", Prefixes: []string{"host1."}, Labels: map[string][]string{"env": {"prod"}}},
+		{Identity: Anyone, Prefixes: []string{"public."}},
+	})
+	require.NoError(t, err)
+	_, err = New([]Rule{{Identity: "team-a"}})
+	assert.Error(t, err)
+	_, err = New([]Rule{{Identity: "user:bob"}})
+	assert.Error(t, err)
+
+	testCases := []struct {
+		name   string
+		ids    []string
+		metric models.Metrics
+		stored map[string]string
+		ok     bool
+	}{
+		{name: "prefix", ids: []string{"key:team-a"}, metric: models.Metrics{ID: "teamA.HeapAlloc"}, ok: true},
+		{name: "other prefix", ids: []string{"key:team-a"}, metric: models.Metrics{ID: "HeapAlloc"}},
+		{name: "label", ids: []string{"token:grafana-agent"}, metric: models.Metrics{ID: "HeapAlloc", Labels: map[string]string{"team": "c"}}, ok: true},
+		{name: "other label value", ids: []string{"token:grafana-agent"}, metric: models.Metrics{ID: "HeapAlloc", Labels: map[string]string{"team": "a"}}},
+		{name: "missing label", ids: []string{"token:grafana-agent"}, metric: models.Metrics{ID: "HeapAlloc"}},
+		{name: "prefix and label", ids: []string{"cert:host-1"}, metric: models.Metrics{ID: "host1.cpu", Labels: map[string]string{"env": "prod"}}, ok: true},
+		{name: "prefix without label", ids: []string{"cert:host-1"}, metric: models.Metrics{ID: "host1.cpu"}},
+		{name: "any identity", ids: []string{"key:team-a", "cert:host-1"}, metric: models.Metrics{ID: "teamA.cpu"}, ok: true},
+		{name: "public for everyone", metric: models.Metrics{ID: "public.hits"}, ok: true},
+		{name: "unknown identity", ids: []string{"key:team-z"}, metric: models.Metrics{ID: "teamA.cpu"}},
+		{name: "stored label kept", ids: []string{"token:grafana-agent"}, metric: models.Metrics{ID: "HeapAlloc"}, stored: map[string]string{"team": "b"}, ok: true},
+		{name: "other team overwrites", ids: []string{"token:grafana-agent"}, metric: models.Metrics{ID: "HeapAlloc", Labels: map[string]string{"team": "b"}}, stored: map[string]string{"team": "a"}},
+		{name: "other team without labels", ids: []string{"token:grafana-agent"}, metric: models.Metrics{ID: "HeapAlloc"}, stored: map[string]string{"team": "a"}},
+		{name: "keyed label changed", ids: []string{"token:grafana-agent"}, metric: models.Metrics{ID: "HeapAlloc", Labels: map[string]string{"team": "c"}}, stored: map[string]string{"team": "b"}},
+		{name: "keyed label removed", ids: []string{"token:grafana-agent"}, metric: models.Metrics{ID: "HeapAlloc", Labels: map[string]string{}}, stored: map[string]string{"team": "b"}},
+		{name: "other label changed", ids: []string{"token:grafana-agent"}, metric: models.Metrics{ID: "HeapAlloc", Labels: map[string]string{"team": "b", "host": "y"}}, stored: map[string]string{"team": "b", "host": "x"}, ok: true},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			assert.Equal(t, test.ok, a.Allowed(test.ids, test.metric, test.stored))
+			err := Subject{ACL: a, Identities: test.ids}.Check(test.metric, test.stored)
+			assert.Equal(t, test.ok, err == nil)
+		})
+	}
+}
@@ -0,0 +1,351 @@
+// Package alerting вычисляет алерты по метрикам хранилища: пороговые, по выражениям языка запросов и правила отсутствия.
+// Алерт проходит состояния pending -> firing -> resolved, состояние переживает перезапуск сервера
+package alerting
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lionslon/go-yapmetrics/internal/query"
+	"github.com/lionslon/go-yapmetrics/internal/storage"
+	"go.uber.org/zap"
+)
+
+// Состояния алерта
+const (
+	StatePending  = "pending"
+	StateFiring   = "firing"
+	StateResolved = "resolved"
+)
+
+// stateName имя состояния алертов в хранилище
+const stateName = "alerts"
+
+// Source значения метрик, их история и время обновления для вычисления правил
+type Source interface {
+	query.Source
+	Freshness() []storage.Freshness
+}
+
+// Alert текущее состояние алерта правила. Правило по выражению или метрике с метками
+// дает отдельный алерт на каждую серию результата, серия записывается в Series
+type Alert struct {
+	Rule     string            `json:"rule"`
+	Series   string            `json:"series,omitempty"`
+	Metric   string            `json:"metric"`
+	Type     string            `json:"type"`
+	State    string            `json:"state"`
+	Value    float64           `json:"value"`
+	Severity string            `json:"severity,omitempty"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	Summary  string            `json:"summary,omitempty"`
+	// ActiveAt начало непрерывного выполнения условия
+	ActiveAt   time.Time  `json:"active_at"`
+	FiredAt    *time.Time `json:"fired_at,omitempty"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+}
+
+// Key ключ алерта: имя правила и серия
+func (a Alert) Key() string {
+	return a.Rule + a.Series
+}
+
+// Notifier получает алерты, которые перешли в состояние firing или resolved
+type Notifier interface {
+	Notify(Alert)
+}
+
+// Engine периодически вычисляет правила
+type Engine struct {
+	mu        sync.RWMutex
+	rules     []Rule
+	source    Source
+	store     storage.StateStore
+	exprs     map[string]query.Expr
+	alerts    map[string]*Alert
+	notifiers []Notifier
+	// started время первого вычисления, до него агенты не могли присылать метрики
+	started time.Time
+}
+
+// New создает движок алертов, store может быть nil, тогда состояние не сохраняется
+func New(rules []Rule, source Source, store storage.StateStore) *Engine {
+	e := &Engine{rules: rules, source: source, store: store, exprs: map[string]query.Expr{}, alerts: map[string]*Alert{}}
+	for i := range rules {
+		if rules[i].Absent > 0 {
+			continue
+		}
+		expr, err := rules[i].query()
+		if err != nil {
+			zap.S().Errorf("alert rule %s is skipped: %v", rules[i].Name, err)
+			continue
+		}
+		e.exprs[rules[i].Name] = expr
+	}
+	return e
+}
+
+// Subscribe добавляет получателя уведомлений, вызывать до Run
+func (e *Engine) Subscribe(n Notifier) {
+	e.notifiers = append(e.notifiers, n)
+}
+
+// Restore загружает сохраненное состояние, алерты удаленных правил отбрасываются
+func (e *Engine) Restore() error {
+	if e.store == nil {
+		return nil
+	}
+	data, err := e.store.LoadState(stateName)
+	if err != nil || data == nil {
+		return err
+	}
+	var alerts map[string]*Alert
+	if err := json.Unmarshal(data, &alerts); err != nil {
+		return err
+	}
+	known := map[string]bool{}
+	for _, r := range e.rules {
+		known[r.Name] = true
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for key, a := range alerts {
+		if known[a.Rule] {
+			e.alerts[key] = a
+		}
+	}
+	return nil
+}
+
+// sample значение правила для одной серии и выполнение условия
+type sample struct {
+	series string
+	metric string
+	mtype  string
+	labels map[string]string
+	value  float64
+	active bool
+}
+
+// seriesKey ключ серии: имя метрики и отсортированные метки
+func seriesKey(s query.Series) string {
+	keys := make([]string, 0, len(s.Labels))
+	for k := range s.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteString(s.Name)
+	b.WriteByte('{')
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%q", k, s.Labels[k])
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// values вычисляет выражение правила: число или значение каждой серии результата
+func (e *Engine) values(r *Rule, now time.Time) []sample {
+	expr, ok := e.exprs[r.Name]
+	if !ok {
+		return nil
+	}
+	res, err := query.Eval(expr, e.source, now)
+	if err != nil {
+		zap.S().Warnf("alert rule %s: %v", r.Name, err)
+		return nil
+	}
+	if res.Type == query.TypeScalar {
+		active := res.Scalar != 0
+		if r.Op != "" {
+			active = r.matches(res.Scalar)
+		}
+		return []sample{{metric: r.Metric, mtype: r.Type, value: res.Scalar, active: active}}
+	}
+	samples := make([]sample, 0, len(res.Series))
+	for _, ser := range res.Series {
+		metric, mtype := ser.Name, ser.Type
+		if metric == "" {
+			metric, mtype = r.Metric, r.Type
+		}
+		samples = append(samples, sample{
+			series: seriesKey(ser),
+			metric: metric,
+			mtype:  mtype,
+			labels: ser.Labels,
+			value:  ser.Value,
+			active: r.Op == "" || r.matches(ser.Value),
+		})
+	}
+	return samples
+}
+
+// staleness давность последнего обновления метрик правила отсутствия в секундах.
+// Отсчет ведется не раньше запуска движка, чтобы простой сервера не считался молчанием агентов
+func (e *Engine) staleness(r *Rule, now time.Time) float64 {
+	last := e.started
+	for _, f := range e.source.Freshness() {
+		if r.selects(f) && f.UpdatedAt.After(last) {
+			last = f.UpdatedAt
+		}
+	}
+	return now.Sub(last).Seconds()
+}
+
+// check значения правила и выполнение его условия
+func (e *Engine) check(r *Rule, now time.Time) []sample {
+	if r.Absent > 0 {
+		v := e.staleness(r, now)
+		return []sample{{metric: r.Metric, mtype: r.Type, value: v, active: v >= time.Duration(r.Absent).Seconds()}}
+	}
+	return e.values(r, now)
+}
+
+// Eval вычисляет все правила на момент now и сохраняет состояние, если оно изменилось
+func (e *Engine) Eval(now time.Time) {
+	e.mu.Lock()
+	if e.started.IsZero() {
+		e.started = now
+	}
+	changed := false
+	var notify []Alert
+	for i := range e.rules {
+		ruleChanged, ruleNotify := e.evalRule(&e.rules[i], now)
+		changed = changed || ruleChanged
+		notify = append(notify, ruleNotify...)
+	}
+	var data []byte
+	var err error
+	if changed && e.store != nil {
+		data, err = json.Marshal(e.alerts)
+	}
+	e.mu.Unlock()
+
+	for _, a := range notify {
+		for _, n := range e.notifiers {
+			n.Notify(a)
+		}
+	}
+	if err != nil {
+		zap.S().Error(err)
+		return
+	}
+	if data != nil {
+		if err := e.store.SaveState(stateName, data); err != nil {
+			zap.S().Errorf("failed to save alerts state: %v", err)
+		}
+	}
+}
+
+// alertLabels метки правила и серии, метки правила важнее
+func alertLabels(r *Rule, series map[string]string) map[string]string {
+	if len(series) == 0 {
+		return r.Labels
+	}
+	labels := make(map[string]string, len(series)+len(r.Labels))
+	for k, v := range series {
+		labels[k] = v
+	}
+	for k, v := range r.Labels {
+		labels[k] = v
+	}
+	return labels
+}
+
+// evalRule переводит алерты правила в следующее состояние, сообщает, изменилось ли оно,
+// и возвращает алерты, перешедшие в firing или resolved.
+// Одно только новое значение метрики изменением не считается
+func (e *Engine) evalRule(r *Rule, now time.Time) (bool, []Alert) {
+	changed := false
+	var notify []Alert
+	samples := map[string]sample{}
+	for _, smp := range e.check(r, now) {
+		key := r.Name + smp.series
+		samples[key] = smp
+		if !smp.active {
+			continue
+		}
+		a := e.alerts[key]
+		if a == nil || a.State == StateResolved {
+			changed = true
+			a = &Alert{
+				Rule:     r.Name,
+				Series:   smp.series,
+				Metric:   smp.metric,
+				Type:     smp.mtype,
+				State:    StatePending,
+				Severity: r.Severity,
+				Labels:   alertLabels(r, smp.labels),
+				Summary:  r.Summary,
+				ActiveAt: now,
+			}
+			e.alerts[key] = a
+		}
+		a.Value = smp.value
+		if a.State == StatePending && now.Sub(a.ActiveAt) >= time.Duration(r.For) {
+			changed = true
+			a.State = StateFiring
+			a.FiredAt = &now
+			notify = append(notify, *a)
+		}
+	}
+
+	// алерты серий, которые пропали из результата или перестали выполнять условие
+	for key, a := range e.alerts {
+		if a.Rule != r.Name {
+			continue
+		}
+		smp, ok := samples[key]
+		if ok && smp.active {
+			continue
+		}
+		switch a.State {
+		case StatePending:
+			changed = true
+			delete(e.alerts, key)
+		case StateFiring:
+			changed = true
+			a.State = StateResolved
+			a.ResolvedAt = &now
+			if ok {
+				a.Value = smp.value
+			}
+			notify = append(notify, *a)
+		}
+	}
+	sort.Slice(notify, func(i, j int) bool { return notify[i].Series < notify[j].Series })
+	return changed, notify
+}
+
+// Run вычисляет правила с интервалом interval
+func (e *Engine) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		e.Eval(now)
+	}
+}
+
+// Alerts возвращает алерты, отсортированные по имени правила и серии
+func (e *Engine) Alerts() []Alert {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	list := make([]Alert, 0, len(e.alerts))
+	for _, a := range e.alerts {
+		list = append(list, *a)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Rule != list[j].Rule {
+			return list[i].Rule < list[j].Rule
+		}
+		return list[i].Series < list[j].Series
+	})
+	return list
+}
@@ -0,0 +1,267 @@
+package alerting
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lionslon/go-yapmetrics/internal/models"
+	"github.com/lionslon/go-yapmetrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// memState хранилище состояния в памяти
+type memState map[string][]byte
+
+func (m memState) SaveState(name string, data []byte) error {
+	m[name] = data
+	return nil
+}
+
+func (m memState) LoadState(name string) ([]byte, error) {
+	return m[name], nil
+}
+
+// recorder запоминает уведомления
+type recorder []string
+
+func (r *recorder) Notify(a Alert) {
+	*r = append(*r, a.Rule+" "+a.State)
+}
+
+func TestEngine(t *testing.T) {
+	st := storage.NewMemoryStorage()
+	rules := []Rule{
+		{Name: "HighHeap", Metric: "HeapAlloc", Type: "gauge", Op: ">", Threshold: 100, For: Duration(time.Minute), Severity: "warning"},
+		{Name: "TooManyPolls", Metric: "PollCount", Type: "counter", Op: ">=", Threshold: 10},
+	}
+	state := memState{}
+	e := New(rules, st, state)
+	var notified recorder
+	e.Subscribe(&notified)
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+	states := func() map[string]string {
+		got := map[string]string{}
+		for _, a := range e.Alerts() {
+			got[a.Rule] = a.State
+		}
+		return got
+	}
+
+	e.Eval(at(0))
+	assert.Empty(t, e.Alerts(), "missing metrics do not fire")
+
+	st.UpdateGauge("HeapAlloc", 150)
+	st.UpdateCounter("PollCount", 10)
+	e.Eval(at(10 * time.Second))
+	assert.Equal(t, map[string]string{"HighHeap": StatePending, "TooManyPolls": StateFiring}, states())
+
+	st.UpdateGauge("HeapAlloc", 50)
+	e.Eval(at(20 * time.Second))
+	assert.Equal(t, map[string]string{"TooManyPolls": StateFiring}, states(), "pending alert is dropped when the condition clears")
+
+	st.UpdateGauge("HeapAlloc", 200)
+	e.Eval(at(30 * time.Second))
+	e.Eval(at(80 * time.Second))
+	assert.Equal(t, StatePending, states()["HighHeap"], "for is counted from the last activation")
+	e.Eval(at(90 * time.Second))
+	assert.Equal(t, StateFiring, states()["HighHeap"])
+
+	st.ResetCounter("PollCount")
+	e.Eval(at(100 * time.Second))
+	assert.Equal(t, StateResolved, states()["TooManyPolls"])
+
+	assert.Equal(t, recorder{"TooManyPolls firing", "HighHeap firing", "TooManyPolls resolved"}, notified, "pending is not notified")
+
+	// состояние переживает перезапуск
+	restored := New(rules, st, state)
+	require.NoError(t, restored.Restore())
+	assert.Equal(t, e.Alerts(), restored.Alerts())
+
+	// алерты удаленных правил отбрасываются
+	restored = New(rules[:1], st, state)
+	require.NoError(t, restored.Restore())
+	assert.Len(t, restored.Alerts(), 1)
+}
+
+// freshSource источник только со временем обновления метрик
+type freshSource []storage.Freshness
+
+func (freshSource) List() []models.Metrics           { return nil }
+func (f freshSource) Freshness() []storage.Freshness { return f }
+func (freshSource) CounterRate(string, time.Duration, time.Time) (storage.Rate, bool) {
+	return storage.Rate{}, false
+}
+
+// rateSource источник с заданными метриками и приростом counter-метрик
+type rateSource struct {
+	freshSource
+	metrics []models.Metrics
+	rates   map[string]storage.Rate
+}
+
+func (r rateSource) List() []models.Metrics {
+	return r.metrics
+}
+
+func (r rateSource) CounterRate(n string, _ time.Duration, _ time.Time) (storage.Rate, bool) {
+	rate, ok := r.rates[n]
+	return rate, ok
+}
+
+func TestEngineAbsent(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(d time.Duration) time.Time { return start.Add(d) }
+	seen := func(id, agent string, labels map[string]string, d time.Duration) storage.Freshness {
+		return storage.Freshness{ID: id, MType: "counter", Labels: labels, Seen: storage.Seen{UpdatedAt: at(d), Agent: agent}}
+	}
+	source := freshSource{
+		seen("PollCount", "agent-1", nil, 3*time.Minute),
+		seen("Alloc", "agent-2", map[string]string{"host": "b"}, -time.Hour),
+		seen("Frees", "agent-2", map[string]string{"host": "b"}, time.Minute),
+	}
+	rules := []Rule{
+		{Name: "PollCountFrozen", Metric: "PollCount", Type: "counter", Absent: Duration(5 * time.Minute)},
+		{Name: "HostBSilent", MatchLabels: map[string]string{"host": "b"}, Absent: Duration(5 * time.Minute)},
+		{Name: "Agent3Silent", Agent: "agent-3", Absent: Duration(5 * time.Minute)},
+	}
+	e := New(rules, source, nil)
+	states := func() map[string]string {
+		got := map[string]string{}
+		for _, a := range e.Alerts() {
+			got[a.Rule] = a.State
+		}
+		return got
+	}
+
+	e.Eval(at(0))
+	e.Eval(at(4 * time.Minute))
+	assert.Empty(t, states(), "staleness is counted from the engine start")
+
+	e.Eval(at(5 * time.Minute))
+	assert.Equal(t, map[string]string{"Agent3Silent": StateFiring}, states(), "never seen agent fires after absent since start")
+
+	e.Eval(at(6 * time.Minute))
+	assert.Equal(t, map[string]string{"Agent3Silent": StateFiring, "HostBSilent": StateFiring}, states(), "the freshest matching metric counts")
+
+	e.Eval(at(8 * time.Minute))
+	assert.Equal(t, StateFiring, states()["PollCountFrozen"])
+	for _, a := range e.Alerts() {
+		if a.Rule == "PollCountFrozen" {
+			assert.Equal(t, 300.0, a.Value, "value is seconds since the last update")
+		}
+	}
+}
+
+func TestEngineRate(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	polls, heap := int64(40), 90.0
+	source := rateSource{
+		metrics: []models.Metrics{
+			{ID: "PollCount", MType: "counter", Delta: &polls},
+			{ID: "HeapAlloc", MType: "gauge", Value: &heap, Labels: map[string]string{"host": "a"}},
+		},
+		rates: map[string]storage.Rate{"PollCount": {ID: "PollCount", Increase: 3, Rate: 0.05, Samples: 4}},
+	}
+	rules := []Rule{
+		{Name: "PollingStalled", Metric: "PollCount", Type: "counter", Func: FuncRate, Window: Duration(5 * time.Minute), Op: "<", Threshold: 0.1, For: Duration(5 * time.Minute)},
+		{Name: "FewPolls", Metric: "PollCount", Type: "counter", Func: FuncIncrease, Window: Duration(time.Minute), Op: "<=", Threshold: 3},
+		{Name: "NoSamples", Metric: "Missing", Type: "counter", Func: FuncRate, Window: Duration(time.Minute), Op: "<", Threshold: 1},
+		{Name: "HeapPerPoll", Expr: `HeapAlloc{host="a"} / 2`, Op: ">", Threshold: 40},
+		{Name: "HeapOnB", Expr: `HeapAlloc{host="b"} > 0`},
+	}
+	e := New(rules, source, nil)
+	e.Eval(start)
+	e.Eval(start.Add(5 * time.Minute))
+	got := map[string]Alert{}
+	for _, a := range e.Alerts() {
+		got[a.Rule] = a
+	}
+	assert.Len(t, got, 3, "rate without samples and empty expressions do not fire")
+	assert.Equal(t, StateFiring, got["PollingStalled"].State)
+	assert.Equal(t, 0.05, got["PollingStalled"].Value)
+	assert.Equal(t, 3.0, got["FewPolls"].Value)
+	assert.Equal(t, 45.0, got["HeapPerPoll"].Value)
+}
+
+func TestEngineSeries(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	gauge := func(host string, v float64) models.Metrics {
+		return models.Metrics{ID: "HeapAlloc", MType: "gauge", Value: &v, Labels: map[string]string{"host": host}}
+	}
+	source := &rateSource{metrics: []models.Metrics{gauge("a", 150), gauge("b", 200), gauge("c", 50)}}
+	rules := []Rule{
+		{Name: "HighHeap", Metric: "HeapAlloc", Type: "gauge", Op: ">", Threshold: 100, Labels: map[string]string{"team": "infra", "host": "rule"}},
+	}
+	state := memState{}
+	e := New(rules, source, state)
+	var notified recorder
+	e.Subscribe(&notified)
+	hosts := func() map[string]string {
+		got := map[string]string{}
+		for _, a := range e.Alerts() {
+			assert.Equal(t, "HeapAlloc", a.Metric)
+			assert.Equal(t, "infra", a.Labels["team"])
+			got[a.Series] = a.State
+		}
+		return got
+	}
+
+	e.Eval(start)
+	assert.Equal(t, map[string]string{`HeapAlloc{host="a"}`: StateFiring, `HeapAlloc{host="b"}`: StateFiring}, hosts(), "one alert per series")
+	assert.Equal(t, recorder{"HighHeap firing", "HighHeap firing"}, notified)
+	for _, a := range e.Alerts() {
+		assert.Equal(t, "rule", a.Labels["host"], "rule labels take precedence over series labels")
+	}
+
+	source.metrics = []models.Metrics{gauge("a", 50), gauge("b", 300)}
+	e.Eval(start.Add(time.Minute))
+	assert.Equal(t, map[string]string{`HeapAlloc{host="a"}`: StateResolved, `HeapAlloc{host="b"}`: StateFiring}, hosts(), "series resolve independently")
+	assert.Len(t, notified, 3)
+
+	restored := New(rules, source, state)
+	require.NoError(t, restored.Restore())
+	assert.Equal(t, e.Alerts(), restored.Alerts())
+}
+
+func TestLoadRules(t *testing.T) {
+	dir := t.TempDir()
+	write := func(body string) string {
+		path := filepath.Join(dir, "rules.json")
+		require.NoError(t, os.WriteFile(path, []byte(body), 0o644))
+		return path
+	}
+
+	rules, err := LoadRules(write(`[{"name":"HighHeap","metric":"HeapAlloc","type":"gauge","op":">","threshold":100,"for":"5m","labels":{"team":"core"}}]`))
+	require.NoError(t, err)
+	assert.Equal(t, Duration(5*time.Minute), rules[0].For)
+
+	rules, err = LoadRules(write(`[{"name":"HeapRatio","expr":"HeapAlloc / HeapSys > 0.9","for":"1m"}]`))
+	require.NoError(t, err)
+	assert.Equal(t, "HeapAlloc / HeapSys > 0.9", rules[0].Expr)
+
+	rules, err = LoadRules(write(`[{"name":"AgentSilent","agent":"agent-1","absent":"10m"}]`))
+	require.NoError(t, err)
+	assert.Equal(t, Duration(10*time.Minute), rules[0].Absent)
+
+	for _, body := range []string{
+		`[{"name":"A","metric":"m","type":"histogram","op":">","threshold":1}]`,
+		`[{"name":"A","metric":"m","type":"gauge","op":"=>","threshold":1}]`,
+		`[{"name":"A","metric":"m","type":"gauge","op":">","threshold":1,"for":"soon"}]`,
+		`[{"metric":"m","type":"gauge","op":">","threshold":1}]`,
+		`[{"name":"A","metric":"m","type":"gauge","op":">"},{"name":"A","metric":"n","type":"gauge","op":">"}]`,
+		`[{"name":"A","absent":"5m"}]`,
+		`[{"name":"A","expr":"sum(HeapAlloc"}]`,
+		`[{"name":"A","expr":"HeapAlloc","op":"=>"}]`,
+		`[{"name":"A","metric":"m","type":"gauge","func":"rate","window":"5m","op":"<","threshold":1}]`,
+		`[{"name":"A","metric":"m","type":"counter","func":"rate","op":"<","threshold":1}]`,
+		`[{"name":"A","metric":"m","type":"counter","func":"avg","window":"5m","op":"<","threshold":1}]`,
+		`[{"name":"A","agent":"a","absent":"-5m"}]`,
+	} {
+		_, err := LoadRules(write(body))
+		assert.Error(t, err, body)
+	}
+}
@@ -0,0 +1,279 @@
+package alerting
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Group уведомление о группе алертов с одинаковыми значениями меток группировки
+type Group struct {
+	Key    string            `json:"key"`
+	Labels map[string]string `json:"labels"`
+	Alerts []Alert           `json:"alerts"`
+}
+
+// GroupNotifier получает сгруппированные уведомления от Router
+type GroupNotifier interface {
+	NotifyGroup(Group)
+}
+
+// Inhibition подавляет алерты Target, пока горит алерт Source с теми же значениями меток Equal
+type Inhibition struct {
+	Source []Matcher `json:"source"`
+	Target []Matcher `json:"target"`
+	Equal  []string  `json:"equal,omitempty"`
+}
+
+// RouteConfig настройки маршрутизации уведомлений
+type RouteConfig struct {
+	// GroupBy метки группировки, по умолчанию alertname, то есть каждое правило отдельно
+	GroupBy []string `json:"group_by,omitempty"`
+	// GroupWait сколько ждать остальные алерты группы перед отправкой
+	GroupWait Duration `json:"group_wait,omitempty"`
+	// RepeatInterval не отправлять уведомления об алерте чаще этого интервала, даже если состояние
+	// меняется. Последнее состояние, отличное от отправленного, уходит по окончании интервала
+	RepeatInterval Duration     `json:"repeat_interval,omitempty"`
+	Inhibit        []Inhibition `json:"inhibit,omitempty"`
+}
+
+// LoadRouteConfig читает настройки маршрутизации из JSON-файла
+func LoadRouteConfig(path string) (RouteConfig, error) {
+	var cfg RouteConfig
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return cfg, err
+	}
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse alert routing %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// AlertLabels метки алерта для группировки, тишин и подавления:
+// метки правила и серии и служебные alertname, severity и metric
+func AlertLabels(a Alert) map[string]string {
+	labels := make(map[string]string, len(a.Labels)+3)
+	for k, v := range a.Labels {
+		labels[k] = v
+	}
+	labels["alertname"] = a.Rule
+	labels["severity"] = a.Severity
+	labels["metric"] = a.Metric
+	return labels
+}
+
+// pendingGroup группа, ожидающая отправки
+type pendingGroup struct {
+	labels map[string]string
+	alerts map[string]Alert
+}
+
+// sentState последнее отправленное уведомление об алерте
+type sentState struct {
+	state string
+	at    time.Time
+}
+
+// Router реализует Notifier: отбрасывает алерты под тишиной, подавленные и повторные,
+// а остальные группирует и передает дальше. Горящие алерты под тишиной отправляются,
+// когда тишина закончится, если правило к этому времени не погасло. Алерты, пришедшие
+// раньше RepeatInterval, откладываются до конца интервала
+type Router struct {
+	mu       sync.Mutex
+	cfg      RouteConfig
+	silences *Silences
+	out      GroupNotifier
+	now      func() time.Time
+	firing   map[string]Alert
+	silenced map[string]Alert
+	sent     map[string]sentState
+	deferred map[string]Alert
+	groups   map[string]*pendingGroup
+}
+
+// NewRouter создает маршрутизатор уведомлений, silences может быть nil
+func NewRouter(cfg RouteConfig, silences *Silences, out GroupNotifier) (*Router, error) {
+	if len(cfg.GroupBy) == 0 {
+		cfg.GroupBy = []string{"alertname"}
+	}
+	for i := range cfg.Inhibit {
+		for _, ms := range [][]Matcher{cfg.Inhibit[i].Source, cfg.Inhibit[i].Target} {
+			if len(ms) == 0 {
+				return nil, fmt.Errorf("inhibit rule #%d: source and target matchers are required", i)
+			}
+			for j := range ms {
+				if err := ms[j].compile(); err != nil {
+					return nil, fmt.Errorf("inhibit rule #%d: %w", i, err)
+				}
+			}
+		}
+	}
+	r := &Router{
+		cfg:      cfg,
+		silences: silences,
+		out:      out,
+		now:      time.Now,
+		firing:   map[string]Alert{},
+		silenced: map[string]Alert{},
+		sent:     map[string]sentState{},
+		deferred: map[string]Alert{},
+		groups:   map[string]*pendingGroup{},
+	}
+	if silences != nil {
+		silences.OnExpire(r.Recheck)
+	}
+	return r, nil
+}
+
+// Notify принимает уведомление от Engine
+func (r *Router) Notify(a Alert) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := a.Key()
+	delete(r.silenced, key)
+	if a.State == StateFiring {
+		r.firing[key] = a
+	} else {
+		delete(r.firing, key)
+	}
+	r.routeLocked(a, r.now())
+}
+
+// Recheck отправляет горящие алерты, тишина которых закончилась,
+// и отложенные алерты, интервал повтора которых истек
+func (r *Router) Recheck() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := r.now()
+	for key, a := range r.silenced {
+		delete(r.silenced, key)
+		r.routeLocked(a, now)
+	}
+	for key, a := range r.deferred {
+		last := r.sent[key]
+		if now.Sub(last.at) < time.Duration(r.cfg.RepeatInterval) {
+			continue
+		}
+		delete(r.deferred, key)
+		// правило вернулось в отправленное состояние, сообщать не о чем
+		if a.State != last.state {
+			r.routeLocked(a, now)
+		}
+	}
+}
+
+// routeLocked проверяет тишины, подавление и повторы и кладет алерт в группу
+func (r *Router) routeLocked(a Alert, now time.Time) {
+	key := a.Key()
+	labels := AlertLabels(a)
+	if r.silences != nil {
+		if sl, ok := r.silences.Silenced(labels, now); ok {
+			zap.S().Infof("alert %s %s is silenced by %s", a.Rule, a.State, sl.ID)
+			if a.State == StateFiring {
+				r.silenced[key] = a
+				time.AfterFunc(sl.EndsAt.Sub(now), r.Recheck)
+			}
+			return
+		}
+	}
+	if a.State == StateFiring && r.inhibitedLocked(key, labels) {
+		zap.S().Infof("alert %s is inhibited", a.Rule)
+		return
+	}
+	// уведомления об алерте не отправляются чаще RepeatInterval, чтобы мигающее правило
+	// не засыпало получателей. Последнее состояние откладывается до конца интервала
+	if last, ok := r.sent[key]; ok && r.cfg.RepeatInterval > 0 {
+		if wait := time.Duration(r.cfg.RepeatInterval) - now.Sub(last.at); wait > 0 {
+			if _, ok := r.deferred[key]; !ok {
+				time.AfterFunc(wait, r.Recheck)
+			}
+			r.deferred[key] = a
+			return
+		}
+	}
+	delete(r.deferred, key)
+	r.sent[key] = sentState{state: a.State, at: now}
+
+	groupKey, groupLabels := r.groupKey(labels)
+	g, ok := r.groups[groupKey]
+	if !ok {
+		g = &pendingGroup{labels: groupLabels, alerts: map[string]Alert{}}
+		r.groups[groupKey] = g
+		if r.cfg.GroupWait > 0 {
+			time.AfterFunc(time.Duration(r.cfg.GroupWait), func() { r.flush(groupKey) })
+		}
+	}
+	// в группе остается последнее состояние каждого алерта
+	g.alerts[key] = a
+	if r.cfg.GroupWait <= 0 {
+		r.flushLocked(groupKey)
+	}
+}
+
+// inhibitedLocked сообщает, что алерт с ключом key подавлен другим горящим алертом
+func (r *Router) inhibitedLocked(key string, labels map[string]string) bool {
+	for _, in := range r.cfg.Inhibit {
+		if !matchAll(in.Target, labels) {
+			continue
+		}
+		for srcKey, src := range r.firing {
+			if srcKey == key {
+				continue
+			}
+			srcLabels := AlertLabels(src)
+			if !matchAll(in.Source, srcLabels) {
+				continue
+			}
+			equal := true
+			for _, l := range in.Equal {
+				if srcLabels[l] != labels[l] {
+					equal = false
+					break
+				}
+			}
+			if equal {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// groupKey ключ и метки группы алерта
+func (r *Router) groupKey(labels map[string]string) (string, map[string]string) {
+	parts := make([]string, 0, len(r.cfg.GroupBy))
+	groupLabels := make(map[string]string, len(r.cfg.GroupBy))
+	for _, l := range r.cfg.GroupBy {
+		parts = append(parts, l+"="+labels[l])
+		groupLabels[l] = labels[l]
+	}
+	return strings.Join(parts, ","), groupLabels
+}
+
+func (r *Router) flush(key string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.flushLocked(key)
+}
+
+// flushLocked отправляет накопленную группу
+func (r *Router) flushLocked(key string) {
+	g, ok := r.groups[key]
+	if !ok {
+		return
+	}
+	delete(r.groups, key)
+	group := Group{Key: key, Labels: g.labels}
+	for _, a := range g.alerts {
+		group.Alerts = append(group.Alerts, a)
+	}
+	sort.Slice(group.Alerts, func(i, j int) bool { return group.Alerts[i].Key() < group.Alerts[j].Key() })
+	r.out.NotifyGroup(group)
+}
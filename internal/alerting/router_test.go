@@ -0,0 +1,235 @@
+package alerting
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// groupRecorder запоминает сгруппированные уведомления
+type groupRecorder struct {
+	mu     sync.Mutex
+	groups []Group
+}
+
+func (g *groupRecorder) NotifyGroup(group Group) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.groups = append(g.groups, group)
+}
+
+// rules возвращает правила и состояния каждой отправленной группы
+func (g *groupRecorder) rules() [][]string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var out [][]string
+	for _, group := range g.groups {
+		var rules []string
+		for _, a := range group.Alerts {
+			rules = append(rules, a.Rule+" "+a.State)
+		}
+		out = append(out, rules)
+	}
+	return out
+}
+
+func TestRouter(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	firing := func(rule, severity string, labels map[string]string) Alert {
+		return Alert{Rule: rule, State: StateFiring, Severity: severity, Labels: labels}
+	}
+	resolved := func(a Alert) Alert {
+		a.State = StateResolved
+		return a
+	}
+	host := func(h string) map[string]string { return map[string]string{"host": h} }
+	inhibit := []Inhibition{{
+		Source: []Matcher{{Name: "severity", Value: "critical"}},
+		Target: []Matcher{{Name: "severity", Value: "warning"}},
+		Equal:  []string{"host"},
+	}}
+
+	tests := []struct {
+		name   string
+		cfg    RouteConfig
+		alerts []Alert
+		want   [][]string
+	}{
+		{
+			name:   "each alert is sent without group wait",
+			alerts: []Alert{firing("HighHeap", "warning", nil), firing("HighGC", "warning", nil)},
+			want:   [][]string{{"HighHeap firing"}, {"HighGC firing"}},
+		},
+		{
+			name: "repeat within interval is throttled",
+			cfg:  RouteConfig{RepeatInterval: Duration(time.Hour)},
+			alerts: []Alert{
+				firing("HighHeap", "warning", nil), firing("HighHeap", "warning", nil),
+				firing("HighGC", "warning", nil),
+			},
+			want: [][]string{{"HighHeap firing"}, {"HighGC firing"}},
+		},
+		{
+			name: "series of one rule are routed separately",
+			cfg:  RouteConfig{RepeatInterval: Duration(time.Hour)},
+			alerts: []Alert{
+				{Rule: "HighHeap", Series: `HeapAlloc{host="a"}`, State: StateFiring, Labels: host("a")},
+				{Rule: "HighHeap", Series: `HeapAlloc{host="b"}`, State: StateFiring, Labels: host("b")},
+			},
+			want: [][]string{{"HighHeap firing"}, {"HighHeap firing"}},
+		},
+		{
+			name: "critical inhibits warning on the same host",
+			cfg:  RouteConfig{Inhibit: inhibit},
+			alerts: []Alert{
+				firing("HostDown", "critical", host("a")),
+				firing("HighHeap", "warning", host("a")),
+				firing("HighGC", "warning", host("b")),
+				resolved(firing("HostDown", "critical", host("a"))),
+				firing("LowDisk", "warning", host("a")),
+			},
+			want: [][]string{{"HostDown firing"}, {"HighGC firing"}, {"HostDown resolved"}, {"LowDisk firing"}},
+		},
+		{
+			name: "silenced alert is dropped",
+			alerts: []Alert{
+				firing("Flapping", "info", host("a")),
+				firing("HighHeap", "warning", host("a")),
+			},
+			want: [][]string{{"HighHeap firing"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			silences := NewSilences(nil)
+			_, err := silences.Add(Silence{
+				Matchers: []Matcher{{Name: "alertname", Value: "Flap.*", Regex: true}},
+				EndsAt:   start.Add(time.Hour),
+				Author:   "ops",
+			}, start)
+			require.NoError(t, err)
+
+			var out groupRecorder
+			r, err := NewRouter(tt.cfg, silences, &out)
+			require.NoError(t, err)
+			r.now = func() time.Time { return start }
+			for _, a := range tt.alerts {
+				r.Notify(a)
+			}
+			assert.Equal(t, tt.want, out.rules())
+		})
+	}
+}
+
+func TestRouterSilenceExpired(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	silences := NewSilences(nil)
+	early, err := silences.Add(Silence{Matchers: []Matcher{{Name: "alertname", Value: "HighHeap"}}, EndsAt: start.Add(time.Hour), Author: "ops"}, start)
+	require.NoError(t, err)
+	_, err = silences.Add(Silence{Matchers: []Matcher{{Name: "alertname", Value: "HighGC|LowDisk", Regex: true}}, EndsAt: start.Add(time.Minute), Author: "ops"}, start)
+	require.NoError(t, err)
+
+	var out groupRecorder
+	r, err := NewRouter(RouteConfig{}, silences, &out)
+	require.NoError(t, err)
+	r.now = func() time.Time { return now }
+	r.Notify(Alert{Rule: "HighHeap", State: StateFiring})
+	r.Notify(Alert{Rule: "HighGC", State: StateFiring})
+	r.Notify(Alert{Rule: "LowDisk", State: StateFiring})
+	r.Notify(Alert{Rule: "LowDisk", State: StateResolved})
+	assert.Empty(t, out.rules())
+
+	now = start.Add(2 * time.Minute)
+	r.Recheck()
+	assert.Equal(t, [][]string{{"HighGC firing"}}, out.rules(), "resolved alert is not sent after the silence ends")
+
+	_, err = silences.Expire(early.ID, now)
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"HighGC firing"}, {"HighHeap firing"}}, out.rules())
+
+	r.Recheck()
+	assert.Len(t, out.rules(), 2, "alert is sent once")
+}
+
+func TestRouterRepeatInterval(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	var out groupRecorder
+	r, err := NewRouter(RouteConfig{RepeatInterval: Duration(time.Hour)}, nil, &out)
+	require.NoError(t, err)
+	r.now = func() time.Time { return now }
+	flap := func(times int, last string) {
+		for i := 0; i < times; i++ {
+			r.Notify(Alert{Rule: "Flapping", State: StateFiring})
+			r.Notify(Alert{Rule: "Flapping", State: StateResolved})
+			now = now.Add(time.Minute)
+		}
+		r.Notify(Alert{Rule: "Flapping", State: last})
+	}
+
+	flap(10, StateResolved)
+	assert.Equal(t, [][]string{{"Flapping firing"}}, out.rules(), "one notification per interval")
+	r.Recheck()
+	assert.Len(t, out.rules(), 1, "interval has not passed yet")
+
+	now = start.Add(time.Hour)
+	r.Recheck()
+	assert.Equal(t, [][]string{{"Flapping firing"}, {"Flapping resolved"}}, out.rules(), "last state is sent when the interval ends")
+
+	flap(10, StateResolved)
+	now = start.Add(2 * time.Hour)
+	r.Recheck()
+	assert.Len(t, out.rules(), 2, "rule is back in the sent state, nothing to send")
+
+	r.Notify(Alert{Rule: "Flapping", State: StateFiring})
+	assert.Equal(t, [][]string{{"Flapping firing"}, {"Flapping resolved"}, {"Flapping firing"}}, out.rules())
+}
+
+func TestRouterGroupWait(t *testing.T) {
+	var out groupRecorder
+	r, err := NewRouter(RouteConfig{GroupBy: []string{"severity"}, GroupWait: Duration(50 * time.Millisecond)}, nil, &out)
+	require.NoError(t, err)
+	r.Notify(Alert{Rule: "HighHeap", State: StateFiring, Severity: "warning"})
+	r.Notify(Alert{Rule: "HighGC", State: StateFiring, Severity: "warning"})
+	r.Notify(Alert{Rule: "HostDown", State: StateFiring, Severity: "critical"})
+	assert.Empty(t, out.rules())
+
+	require.Eventually(t, func() bool { return len(out.rules()) == 2 }, time.Second, 10*time.Millisecond)
+	assert.ElementsMatch(t, [][]string{{"HighGC firing", "HighHeap firing"}, {"HostDown firing"}}, out.rules())
+}
+
+func TestSilencesPersistence(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	state := memState{}
+	s := NewSilences(state)
+
+	_, err := s.Add(Silence{Matchers: []Matcher{{Name: "host", Value: "a"}}, EndsAt: now.Add(time.Hour)}, now)
+	assert.Error(t, err, "author is required")
+	_, err = s.Add(Silence{Matchers: []Matcher{{Name: "host", Value: "(", Regex: true}}, EndsAt: now.Add(time.Hour), Author: "ops"}, now)
+	assert.Error(t, err)
+
+	sl, err := s.Add(Silence{Matchers: []Matcher{{Name: "host", Value: "a|b", Regex: true}}, EndsAt: now.Add(time.Hour), Author: "ops"}, now)
+	require.NoError(t, err)
+	assert.NotEmpty(t, sl.ID)
+
+	restored := NewSilences(state)
+	require.NoError(t, restored.Restore())
+	got, ok := restored.Silenced(map[string]string{"host": "b"}, now.Add(time.Minute))
+	assert.True(t, ok)
+	assert.Equal(t, sl.ID, got.ID)
+	_, ok = restored.Silenced(map[string]string{"host": "bc"}, now.Add(time.Minute))
+	assert.False(t, ok, "regex matches the whole value")
+
+	expired, err := restored.Expire(sl.ID, now.Add(2*time.Minute))
+	require.NoError(t, err)
+	assert.True(t, expired)
+	assert.Empty(t, restored.List(now.Add(2*time.Minute)))
+
+	again := NewSilences(state)
+	require.NoError(t, again.Restore())
+	assert.Empty(t, again.List(now.Add(2*time.Minute)))
+}
@@ -0,0 +1,208 @@
+package alerting
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"time"
+
+	"github.com/lionslon/go-yapmetrics/internal/query"
+	"github.com/lionslon/go-yapmetrics/internal/storage"
+)
+
+// Duration длительность, в JSON записывается строкой вида "5m"
+type Duration time.Duration
+
+// UnmarshalJSON принимает строку time.ParseDuration или число секунд
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var v any
+	if err := json.Unmarshal(b, &v); err != nil {
+		return err
+	}
+	switch v := v.(type) {
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	case float64:
+		*d = Duration(time.Duration(v * float64(time.Second)))
+	default:
+		return fmt.Errorf("invalid duration %s", b)
+	}
+	return nil
+}
+
+// MarshalJSON записывает длительность строкой
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Функции над counter-метриками в правилах
+const (
+	FuncRate     = "rate"
+	FuncIncrease = "increase"
+)
+
+// Операции сравнения значения метрики с порогом
+var comparisons = map[string]func(v, threshold float64) bool{
+	">":  func(v, t float64) bool { return v > t },
+	">=": func(v, t float64) bool { return v >= t },
+	"<":  func(v, t float64) bool { return v < t },
+	"<=": func(v, t float64) bool { return v <= t },
+	"==": func(v, t float64) bool { return v == t },
+	"!=": func(v, t float64) bool { return v != t },
+}
+
+// Rule правило алерта: условие должно выполняться непрерывно не меньше For, чтобы алерт сработал
+type Rule struct {
+	Name string `json:"name"`
+	// Expr выражение языка запросов вместо Metric, Type и Func. Правило активно, если выражение
+	// вернуло хотя бы одну серию (после сравнения с порогом, если задан Op) или ненулевое число
+	Expr      string   `json:"expr,omitempty"`
+	Metric    string   `json:"metric"`
+	Type      string   `json:"type"`
+	Op        string   `json:"op"`
+	Threshold float64  `json:"threshold"`
+	For       Duration `json:"for"`
+	// Func сравнивает с порогом не значение counter-метрики, а rate (прирост в секунду)
+	// или increase (прирост) за окно Window
+	Func   string   `json:"func,omitempty"`
+	Window Duration `json:"window,omitempty"`
+	// Absent делает правило правилом отсутствия: оно срабатывает, когда ни одна из выбранных
+	// метрик не обновлялась дольше Absent. Метрики выбираются по Metric и Type, MatchLabels
+	// и Agent, Op и Threshold не используются
+	Absent      Duration          `json:"absent,omitempty"`
+	MatchLabels map[string]string `json:"match_labels,omitempty"`
+	Agent       string            `json:"agent,omitempty"`
+	Severity    string            `json:"severity,omitempty"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Summary     string            `json:"summary,omitempty"`
+}
+
+// Validate проверяет правило
+func (r *Rule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("rule name is required")
+	}
+	if r.For < 0 || r.Absent < 0 {
+		return fmt.Errorf("rule %q: for and absent must not be negative", r.Name)
+	}
+	if r.Absent > 0 {
+		return r.validateAbsent()
+	}
+	if r.Expr != "" {
+		return r.validateExpr()
+	}
+	if r.Metric == "" {
+		return fmt.Errorf("rule %q: metric is required", r.Name)
+	}
+	if r.Type != "gauge" && r.Type != "counter" {
+		return fmt.Errorf("rule %q: type must be gauge or counter", r.Name)
+	}
+	if _, ok := comparisons[r.Op]; !ok {
+		return fmt.Errorf("rule %q: unknown comparison %q", r.Name, r.Op)
+	}
+	switch r.Func {
+	case "":
+	case FuncRate, FuncIncrease:
+		if r.Type != "counter" {
+			return fmt.Errorf("rule %q: %s needs a counter metric", r.Name, r.Func)
+		}
+		if r.Window <= 0 {
+			return fmt.Errorf("rule %q: %s needs a positive window", r.Name, r.Func)
+		}
+	default:
+		return fmt.Errorf("rule %q: func must be rate or increase", r.Name)
+	}
+	if math.IsNaN(r.Threshold) || math.IsInf(r.Threshold, 0) {
+		return fmt.Errorf("rule %q: threshold must be finite", r.Name)
+	}
+	return nil
+}
+
+// validateExpr проверяет правило с выражением, сравнение с порогом необязательно
+func (r *Rule) validateExpr() error {
+	if _, err := query.Parse(r.Expr); err != nil {
+		return fmt.Errorf("rule %q: invalid expr: %w", r.Name, err)
+	}
+	if _, ok := comparisons[r.Op]; r.Op != "" && !ok {
+		return fmt.Errorf("rule %q: unknown comparison %q", r.Name, r.Op)
+	}
+	if math.IsNaN(r.Threshold) || math.IsInf(r.Threshold, 0) {
+		return fmt.Errorf("rule %q: threshold must be finite", r.Name)
+	}
+	return nil
+}
+
+// query выражение правила, для правил по метрике оно строится из Metric, Type и Func
+func (r *Rule) query() (query.Expr, error) {
+	if r.Expr != "" {
+		return query.Parse(r.Expr)
+	}
+	expr := fmt.Sprintf("{%s=%q, %s=%q}", query.LabelName, r.Metric, query.LabelType, r.Type)
+	if r.Func != "" {
+		expr = fmt.Sprintf("%s(%s[%s])", r.Func, expr, time.Duration(r.Window))
+	}
+	return query.Parse(expr)
+}
+
+// validateAbsent проверяет правило отсутствия
+func (r *Rule) validateAbsent() error {
+	if r.Metric == "" && len(r.MatchLabels) == 0 && r.Agent == "" {
+		return fmt.Errorf("rule %q: absent rule needs metric, match_labels or agent", r.Name)
+	}
+	if r.Type != "" && r.Type != "gauge" && r.Type != "counter" {
+		return fmt.Errorf("rule %q: type must be gauge or counter", r.Name)
+	}
+	return nil
+}
+
+// selects сообщает, что правило отсутствия следит за метрикой
+func (r *Rule) selects(f storage.Freshness) bool {
+	if r.Metric != "" && f.ID != r.Metric {
+		return false
+	}
+	if r.Type != "" && f.MType != r.Type {
+		return false
+	}
+	if r.Agent != "" && f.Agent != r.Agent {
+		return false
+	}
+	for k, v := range r.MatchLabels {
+		if f.Labels[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// matches проверяет условие правила для значения
+func (r *Rule) matches(v float64) bool {
+	return comparisons[r.Op](v, r.Threshold)
+}
+
+// LoadRules читает правила из JSON-файла
+func LoadRules(path string) ([]Rule, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse alert rules %s: %w", path, err)
+	}
+	seen := map[string]bool{}
+	for i := range rules {
+		if err := rules[i].Validate(); err != nil {
+			return nil, err
+		}
+		if seen[rules[i].Name] {
+			return nil, fmt.Errorf("duplicate rule %q", rules[i].Name)
+		}
+		seen[rules[i].Name] = true
+	}
+	return rules, nil
+}
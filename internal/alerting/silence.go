@@ -0,0 +1,240 @@
+package alerting
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/lionslon/go-yapmetrics/internal/storage"
+)
+
+// silencesState имя состояния тишин в хранилище
+const silencesState = "silences"
+
+// ErrInvalidSilence некорректные условия или сроки тишины
+var ErrInvalidSilence = errors.New("invalid silence")
+
+// Matcher условие на метку алерта: точное значение или регулярное выражение
+type Matcher struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	Regex bool   `json:"regex,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// compile готовит регулярное выражение, оно должно совпадать со всем значением
+func (m *Matcher) compile() error {
+	if m.Name == "" {
+		return errors.New("matcher name is required")
+	}
+	if !m.Regex {
+		return nil
+	}
+	re, err := regexp.Compile("^(?:" + m.Value + ")$")
+	if err != nil {
+		return fmt.Errorf("matcher %q: %w", m.Name, err)
+	}
+	m.re = re
+	return nil
+}
+
+func (m *Matcher) matches(labels map[string]string) bool {
+	v := labels[m.Name]
+	if m.re != nil {
+		return m.re.MatchString(v)
+	}
+	return v == m.Value
+}
+
+// matchAll проверяет, что метки удовлетворяют всем условиям
+func matchAll(matchers []Matcher, labels map[string]string) bool {
+	for i := range matchers {
+		if !matchers[i].matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// Silence подавляет уведомления об алертах, метки которых подходят под все условия, до EndsAt
+type Silence struct {
+	ID        string    `json:"id"`
+	Matchers  []Matcher `json:"matchers"`
+	StartsAt  time.Time `json:"starts_at"`
+	EndsAt    time.Time `json:"ends_at"`
+	Author    string    `json:"author"`
+	Comment   string    `json:"comment,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// active сообщает, что тишина действует в момент now
+func (s *Silence) active(now time.Time) bool {
+	return !now.Before(s.StartsAt) && now.Before(s.EndsAt)
+}
+
+// Silences набор тишин с сохранением через хранилище сервера
+type Silences struct {
+	mu       sync.RWMutex
+	store    storage.StateStore
+	silences map[string]*Silence
+	onExpire []func()
+}
+
+// NewSilences создает набор тишин, store может быть nil
+func NewSilences(store storage.StateStore) *Silences {
+	return &Silences{store: store, silences: map[string]*Silence{}}
+}
+
+// Restore загружает сохраненные тишины
+func (s *Silences) Restore() error {
+	if s.store == nil {
+		return nil
+	}
+	data, err := s.store.LoadState(silencesState)
+	if err != nil || data == nil {
+		return err
+	}
+	var list []*Silence
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, sl := range list {
+		for i := range sl.Matchers {
+			if err := sl.Matchers[i].compile(); err != nil {
+				return err
+			}
+		}
+		s.silences[sl.ID] = sl
+	}
+	return nil
+}
+
+// Add проверяет и сохраняет тишину, идентификатор и время создания назначаются здесь
+func (s *Silences) Add(sl Silence, now time.Time) (Silence, error) {
+	if len(sl.Matchers) == 0 {
+		return Silence{}, fmt.Errorf("%w: at least one matcher is required", ErrInvalidSilence)
+	}
+	for i := range sl.Matchers {
+		if err := sl.Matchers[i].compile(); err != nil {
+			return Silence{}, fmt.Errorf("%w: %v", ErrInvalidSilence, err)
+		}
+	}
+	if sl.Author == "" {
+		return Silence{}, fmt.Errorf("%w: author is required", ErrInvalidSilence)
+	}
+	if sl.StartsAt.IsZero() {
+		sl.StartsAt = now
+	}
+	if !sl.EndsAt.After(sl.StartsAt) || !sl.EndsAt.After(now) {
+		return Silence{}, fmt.Errorf("%w: ends_at must be in the future and after starts_at", ErrInvalidSilence)
+	}
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return Silence{}, err
+	}
+	sl.ID = hex.EncodeToString(id)
+	sl.CreatedAt = now
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.silences[sl.ID] = &sl
+	if err := s.saveLocked(now); err != nil {
+		delete(s.silences, sl.ID)
+		return Silence{}, err
+	}
+	return sl, nil
+}
+
+// OnExpire регистрирует функцию, вызываемую после досрочного завершения тишины
+func (s *Silences) OnExpire(fn func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onExpire = append(s.onExpire, fn)
+}
+
+// Expire завершает тишину досрочно
+func (s *Silences) Expire(id string, now time.Time) (bool, error) {
+	expired, err := s.expire(id, now)
+	if expired {
+		s.mu.RLock()
+		hooks := s.onExpire
+		s.mu.RUnlock()
+		for _, fn := range hooks {
+			fn()
+		}
+	}
+	return expired, err
+}
+
+func (s *Silences) expire(id string, now time.Time) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sl, ok := s.silences[id]
+	if !ok || !now.Before(sl.EndsAt) {
+		return false, nil
+	}
+	prev := sl.EndsAt
+	sl.EndsAt = now
+	if err := s.saveLocked(now); err != nil {
+		sl.EndsAt = prev
+		s.silences[id] = sl
+		return false, err
+	}
+	return true, nil
+}
+
+// List возвращает тишины, которые действуют или еще не начались
+func (s *Silences) List(now time.Time) []Silence {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]Silence, 0, len(s.silences))
+	for _, sl := range s.silences {
+		if now.Before(sl.EndsAt) {
+			list = append(list, *sl)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].EndsAt.Before(list[j].EndsAt) })
+	return list
+}
+
+// Silenced возвращает действующую тишину для меток алерта
+func (s *Silences) Silenced(labels map[string]string, now time.Time) (Silence, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, sl := range s.silences {
+		if sl.active(now) && matchAll(sl.Matchers, labels) {
+			return *sl, true
+		}
+	}
+	return Silence{}, false
+}
+
+// saveLocked сохраняет действующие тишины, закончившиеся отбрасываются
+func (s *Silences) saveLocked(now time.Time) error {
+	list := make([]*Silence, 0, len(s.silences))
+	for id, sl := range s.silences {
+		if !now.Before(sl.EndsAt) {
+			delete(s.silences, id)
+			continue
+		}
+		list = append(list, sl)
+	}
+	if s.store == nil {
+		return nil
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	data, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	return s.store.SaveState(silencesState, data)
+}
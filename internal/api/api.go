@@ -1,21 +1,36 @@
 package api
 
 import (
+	"crypto/tls"
 	"github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
+	"github.com/lionslon/go-yapmetrics/internal/acl"
+	"github.com/lionslon/go-yapmetrics/internal/alerting"
+	"github.com/lionslon/go-yapmetrics/internal/auth"
 	"github.com/lionslon/go-yapmetrics/internal/config"
+	"github.com/lionslon/go-yapmetrics/internal/encryption"
 	"github.com/lionslon/go-yapmetrics/internal/handlers"
+	"github.com/lionslon/go-yapmetrics/internal/keyring"
 	"github.com/lionslon/go-yapmetrics/internal/middlewares"
+	"github.com/lionslon/go-yapmetrics/internal/notify"
+	"github.com/lionslon/go-yapmetrics/internal/problem"
+	"github.com/lionslon/go-yapmetrics/internal/recording"
 	"github.com/lionslon/go-yapmetrics/internal/storage"
+	"github.com/lionslon/go-yapmetrics/internal/tlsutil"
+	"github.com/lionslon/go-yapmetrics/internal/validation"
 	"github.com/lionslon/go-yapmetrics/pkg/utils/profile"
 	"go.uber.org/zap"
 	"log"
+	"net/http"
 )
 
+// signRejectedMetric счетчик запросов, отклоненных из-за подписи
+const signRejectedMetric = "SignRejectedRequests"
+
 type APIServer struct {
 	cfg  *config.ServerConfig
 	echo *echo.Echo
 	st   *storage.MemStorage
+	tls  *tls.Config
 }
 
 func New() *APIServer {
@@ -23,19 +38,42 @@ func New() *APIServer {
 	cfg := config.NewServer()
 	apiS.cfg = cfg
 	apiS.echo = echo.New()
+	apiS.echo.HTTPErrorHandler = problem.HTTPErrorHandler
 	apiS.st = storage.NewMemoryStorage()
+	apiS.st.SetHistoryRetention(cfg.CounterHistory)
 
 	if cfg.EnableProfiling {
 		profile.StartProfilingServer()
 	}
 
-	handler := handlers.New(apiS.st)
 	logger, _ := zap.NewDevelopment()
 	zap.ReplaceGlobals(logger)
 	defer logger.Sync()
 
+	validator, err := validation.New(cfg.ValidationRules())
+	if err != nil {
+		zap.S().Fatal(err)
+	}
+	handler := handlers.New(apiS.st, validator)
+
+	keys, err := keyring.New(cfg.SignPass, cfg.Keyring, cfg.KeyringFile)
+	if err != nil {
+		zap.S().Fatal(err)
+	}
+	go keys.Watch(cfg.KeyringReload)
+
+	if cfg.TLSEnabled() {
+		apiS.tls, err = tlsutil.NewServerConfig(cfg.TLSOptions())
+		if err != nil {
+			zap.S().Fatal(err)
+		}
+	}
+	identities, err := tlsutil.LoadIdentities(cfg.TLSIdentities)
+	if err != nil {
+		zap.S().Fatal(err)
+	}
+
 	var storageProvider storage.StorageWorker
-	var err error
 	switch cfg.GetProvider() {
 	case storage.FileProvider:
 		storageProvider = storage.NewFileProvider(cfg.FilePath, cfg.StoreInterval, apiS.st)
@@ -56,28 +94,185 @@ func New() *APIServer {
 		go storageProvider.IntervalDump()
 	}
 
+	var recorder *recording.Recorder
+	if cfg.RecordingRules != "" {
+		recorder, err = recording.New(cfg.RecordingRules, apiS.st, validator)
+		if err != nil {
+			zap.S().Fatal(err)
+		}
+		go recorder.Watch(cfg.RecordingReload)
+		go recorder.Run(cfg.RecordingInterval)
+	}
+
+	var alertRules []alerting.Rule
+	if cfg.AlertRules != "" {
+		alertRules, err = alerting.LoadRules(cfg.AlertRules)
+		if err != nil {
+			zap.S().Fatal(err)
+		}
+	}
+	stateStore, _ := storageProvider.(storage.StateStore)
+	alerts := alerting.New(alertRules, apiS.st, stateStore)
+	var hooks []*notify.Webhook
+	if cfg.AlertWebhooks != "" {
+		hooks, err = notify.LoadWebhooks(cfg.AlertWebhooks)
+		if err != nil {
+			zap.S().Fatal(err)
+		}
+	}
+	var routing alerting.RouteConfig
+	if cfg.AlertRouting != "" {
+		routing, err = alerting.LoadRouteConfig(cfg.AlertRouting)
+		if err != nil {
+			zap.S().Fatal(err)
+		}
+	}
+	silences := alerting.NewSilences(stateStore)
+	if err := silences.Restore(); err != nil {
+		zap.S().Error(err)
+	}
+	router, err := alerting.NewRouter(routing, silences, notify.NewDispatcher(hooks, notify.Config{
+		Retries:    cfg.AlertRetries,
+		RetryMin:   cfg.AlertRetryMin,
+		RetryMax:   cfg.AlertRetryMax,
+		Timeout:    cfg.AlertTimeout,
+		DeadLetter: cfg.AlertDeadLetter,
+		QueueSize:  cfg.AlertQueueSize,
+	}))
+	if err != nil {
+		zap.S().Fatal(err)
+	}
+	alerts.Subscribe(router)
+	if len(alertRules) > 0 {
+		if err := alerts.Restore(); err != nil {
+			zap.S().Error(err)
+		}
+		go alerts.Run(cfg.AlertInterval)
+	}
+
+	subnets, err := middlewares.ParseSubnets(cfg.TrustedSubnet)
+	if err != nil {
+		zap.S().Fatal(err)
+	}
+	proxies, err := middlewares.ParseSubnets(cfg.TrustedProxies)
+	if err != nil {
+		zap.S().Fatal(err)
+	}
+	if cfg.RealIPSource == middlewares.RealIPHeader && len(proxies) == 0 {
+		zap.S().Warn("real-ip-source=header has no effect without trusted proxies, the peer address is used")
+	}
+	subnetCfg := middlewares.SubnetConfig{Subnets: subnets, Source: cfg.RealIPSource, Proxies: proxies}
+	// RealIP доверяет X-Real-IP только от доверенных прокси, от него зависят учет агентов и аудит
+	apiS.echo.IPExtractor = middlewares.IPExtractor(subnetCfg)
+
 	apiS.echo.Use(middlewares.WithLogging())
-	//apiS.echo.Use(middlewares.GzipUnpacking())
-	apiS.echo.Use(middleware.GzipWithConfig(middleware.GzipConfig{
-		Level: 5,
+	apiS.echo.Use(middlewares.ClientCert(identities))
+	compressCfg := middlewares.CompressConfig{
+		Level:     cfg.CompressLevel,
+		MinLength: cfg.CompressMinLength,
+	}
+	if err := compressCfg.Validate(); err != nil {
+		zap.S().Fatal(err)
+	}
+	apiS.echo.Use(middlewares.Compress(compressCfg))
+	signCfg := signConfig(cfg, keys, func(reason string) {
+		zap.S().Warnf("request rejected: %s", reason)
+		apiS.st.UpdateCounter(signRejectedMetric, 1)
+	})
+	if cfg.SigningEnabled() {
+		apiS.echo.Use(middlewares.SignResponse(signCfg))
+	}
+	apiS.echo.Use(middlewares.LimitBody(cfg.MaxBodySize))
+	if cfg.CryptoKey != "" {
+		key, err := encryption.LoadPrivateKey(cfg.CryptoKey)
+		if err != nil {
+			zap.S().Fatal(err)
+		}
+		apiS.echo.Use(middlewares.Decrypt(key))
+	}
+	apiS.echo.Use(middlewares.Decompress(middlewares.DecompressConfig{
+		MaxSize:  cfg.MaxDecodedBodySize,
+		MaxRatio: cfg.MaxDecompressRatio,
 	}))
-	if cfg.SignPass != "" {
-		apiS.echo.Use(middlewares.CheckSignReq(cfg.SignPass))
+
+	// ingest ограничивает все методы приема метрик
+	ingest := middlewares.TrustedSubnet(subnetCfg)
+	encrypted := middlewares.RequireEncryption(cfg.CryptoKey != "" && !cfg.AllowPlaintext)
+
+	tokens, err := auth.NewStore(cfg.TokensFile, cfg.AdminToken)
+	if err != nil {
+		zap.S().Fatal(err)
+	}
+	authn := auth.Chain{tokens}
+	if cfg.JWKSFile != "" {
+		verifier, err := auth.NewJWTVerifier(cfg.JWTConfig())
+		if err != nil {
+			zap.S().Fatal(err)
+		}
+		authn = append(authn, verifier)
+	}
+	read := middlewares.RequireRole(authn, auth.RoleRead)
+	write := middlewares.RequireRole(authn, auth.RoleIngest)
+	admin := middlewares.RequireRole(authn, auth.RoleAdmin)
+	rules, err := acl.Load(cfg.ACLFile)
+	if err != nil {
+		zap.S().Fatal(err)
 	}
+	writeACL := middlewares.WriteACL(rules)
+	// updates цепочка маршрутов приема метрик, подпись проверяется только здесь
+	updates := append(signCheck(cfg, signCfg), ingest, encrypted, write, writeACL)
 
-	apiS.echo.GET("/", handler.AllMetricsValues())
-	apiS.echo.POST("/value/", handler.GetValueJSON())
-	apiS.echo.GET("/value/:typeM/:nameM", handler.MetricsValue())
-	apiS.echo.POST("/update/", handler.UpdateJSON())
-	apiS.echo.POST("/update/:typeM/:nameM/:valueM", handler.UpdateMetrics())
-	apiS.echo.POST("/updates/", handler.UpdatesJSON())
+	apiS.echo.GET("/", handler.AllMetricsValues(), read)
+	apiS.echo.POST("/value/", handler.GetValueJSON(), read)
+	apiS.echo.GET("/value/:typeM/:nameM", handler.MetricsValue(), read)
+	apiS.echo.GET("/values", handler.ListValues(), read)
+	apiS.echo.GET("/rate/:nameM", handler.CounterRate(), read)
+	apiS.echo.GET("/query", handler.Query(), read)
+	apiS.echo.GET("/recording-rules", handler.RecordingRules(recorder), read)
+	apiS.echo.POST("/values/", handler.GetValuesJSON(), read)
+	apiS.echo.POST("/update/", handler.UpdateJSON(), updates...)
+	apiS.echo.POST("/update/:typeM/:nameM/:valueM", handler.UpdateMetrics(), updates...)
+	apiS.echo.POST("/updates/", handler.UpdatesJSON(), updates...)
 	apiS.echo.GET("/ping", handler.PingDB(storageProvider))
+	apiS.echo.GET("/alerts", handler.Alerts(alerts), read)
+	apiS.echo.GET("/silences", handler.ListSilences(silences), read)
+	apiS.echo.GET("/agents", handler.Agents(), read)
+
+	apiS.echo.DELETE("/value/:typeM/:nameM", handler.DeleteMetric(storageProvider), admin)
+	apiS.echo.DELETE("/values/", handler.DeleteMetrics(storageProvider), admin)
+	apiS.echo.POST("/reset/counter/:nameM", handler.ResetCounter(storageProvider), admin)
+	apiS.echo.GET("/admin/tokens", handler.ListTokens(tokens), admin)
+	apiS.echo.POST("/admin/tokens", handler.CreateToken(tokens), admin)
+	apiS.echo.DELETE("/admin/tokens/:id", handler.RevokeToken(tokens), admin)
+	apiS.echo.POST("/silences", handler.CreateSilence(silences), admin)
+	apiS.echo.DELETE("/silences/:id", handler.ExpireSilence(silences), admin)
 
 	return apiS
 }
 
+// signConfig настройки проверки подписи запросов из конфига сервера
+func signConfig(cfg *config.ServerConfig, keys middlewares.KeyResolver, onReject func(reason string)) middlewares.SignConfig {
+	return middlewares.SignConfig{
+		Keys:         keys,
+		Mode:         cfg.SignMode,
+		ReplayWindow: cfg.ReplayWindow,
+		// nonce хранится два окна: метка времени из будущего допустима еще целое окно после получения
+		Nonces:   middlewares.NewNonceCache(cfg.NonceCacheSize, 2*cfg.ReplayWindow),
+		OnReject: onReject,
+	}
+}
+
+// signCheck проверка подписи для маршрутов приема метрик, пустой список если ключей нет.
+// Чтение и администрирование подпись не требуют, их защищают роли
+func signCheck(cfg *config.ServerConfig, signCfg middlewares.SignConfig) []echo.MiddlewareFunc {
+	if !cfg.SigningEnabled() {
+		return nil
+	}
+	return []echo.MiddlewareFunc{middlewares.CheckSignReq(signCfg)}
+}
+
 func (a *APIServer) Start() error {
-	err := a.echo.Start(a.cfg.Addr)
+	err := a.echo.StartServer(&http.Server{Addr: a.cfg.Addr, TLSConfig: a.tls})
 	if err != nil {
 		log.Fatal(err)
 	}
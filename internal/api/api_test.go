@@ -1,8 +1,87 @@
 package api
 
 import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
 	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lionslon/go-yapmetrics/internal/config"
+	"github.com/lionslon/go-yapmetrics/internal/keyring"
+	"github.com/lionslon/go-yapmetrics/internal/middlewares"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestWebhook(t *testing.T) {
 }
+
+func TestSignConfigDefaults(t *testing.T) {
+	keys, err := keyring.New("secret", "", "")
+	require.NoError(t, err)
+	cfg := signConfig(config.DefaultServer(), keys, nil)
+
+	e := echo.New()
+	e.POST("/update/", func(ctx echo.Context) error {
+		return ctx.NoContent(http.StatusOK)
+	}, middlewares.CheckSignReq(cfg))
+
+	tests := []struct {
+		name string
+		sign string
+		want int
+	}{
+		{name: "body only signature of old agents", sign: middlewares.GetSign([]byte(`{"id":"a","type":"gauge","value":1}`), []byte("secret")), want: http.StatusOK},
+		{name: "unsigned", want: http.StatusUnauthorized},
+		{name: "wrong key", sign: middlewares.GetSign([]byte(`{"id":"a","type":"gauge","value":1}`), []byte("other")), want: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/update/", strings.NewReader(`{"id":"a","type":"gauge","value":1}`))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			if tt.sign != "" {
+				req.Header.Set("HashSHA256", tt.sign)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			assert.Equal(t, tt.want, rec.Code)
+		})
+	}
+}
+
+func TestSignCheckRoutes(t *testing.T) {
+	cfg := config.DefaultServer()
+	cfg.SignPass = "secret"
+	keys, err := keyring.New(cfg.SignPass, "", "")
+	require.NoError(t, err)
+
+	e := echo.New()
+	ok := func(ctx echo.Context) error {
+		return ctx.NoContent(http.StatusOK)
+	}
+	e.POST("/update/", ok, signCheck(cfg, signConfig(cfg, keys, nil))...)
+	e.POST("/reset/counter/:nameM", ok)
+	e.POST("/values/", ok)
+
+	tests := []struct {
+		name string
+		path string
+		want int
+	}{
+		{name: "unsigned update", path: "/update/", want: http.StatusUnauthorized},
+		{name: "unsigned admin request", path: "/reset/counter/c", want: http.StatusOK},
+		{name: "unsigned read request", path: "/values/", want: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, tt.path, strings.NewReader(`{}`))
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			assert.Equal(t, tt.want, rec.Code)
+		})
+	}
+
+	cfg.SignPass = ""
+	assert.Empty(t, signCheck(cfg, signConfig(cfg, keys, nil)))
+}
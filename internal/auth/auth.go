@@ -0,0 +1,267 @@
+// Package auth хранит токены доступа к API и их роли.
+// Токены хранятся в файле только в виде хешей SHA-256, открытый токен выдается один раз при создании
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Роли доступа
+const (
+	// RoleIngest отправка метрик агентами
+	RoleIngest = "ingest"
+	// RoleRead чтение метрик, например дашбордами
+	RoleRead = "read"
+	// RoleAdmin все методы, включая удаление метрик и управление токенами
+	RoleAdmin = "admin"
+)
+
+// BootstrapID идентификатор административного токена из конфигурации, он не хранится в файле
+const BootstrapID = "bootstrap"
+
+var (
+	// ErrExists токен с таким идентификатором уже есть
+	ErrExists = errors.New("token already exists")
+	// ErrInvalidRole неизвестная роль
+	ErrInvalidRole = errors.New("unknown role")
+	// ErrNotPersistent файл токенов не задан, выпущенные и отозванные токены потерялись бы при перезапуске,
+	// а без файла роли ingest и read не проверяются
+	ErrNotPersistent = errors.New("tokens file is not configured")
+)
+
+// ContextToken ключ echo.Context с проверенным токеном
+const ContextToken = "authToken"
+
+// Token описание токена без открытого значения
+type Token struct {
+	ID    string   `json:"id"`
+	Hash  string   `json:"hash,omitempty"`
+	Roles []string `json:"roles"`
+	// Prefixes разрешенные для записи префиксы имен метрик, пустой список разрешает все
+	Prefixes []string  `json:"prefixes,omitempty"`
+	Created  time.Time `json:"created"`
+}
+
+// Allows сообщает, что токену разрешена запись метрики name
+func (t *Token) Allows(name string) bool {
+	if len(t.Prefixes) == 0 {
+		return true
+	}
+	for _, p := range t.Prefixes {
+		if strings.HasPrefix(name, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticator проверяет токены доступа
+type Authenticator interface {
+	// Enabled сообщает, что роли ingest и read проверяются
+	Enabled() bool
+	Authenticate(token string) (*Token, bool)
+}
+
+// Chain проверяет токен по очереди всеми источниками
+type Chain []Authenticator
+
+// Enabled роли проверяются, если этого требует хотя бы один источник
+func (c Chain) Enabled() bool {
+	for _, a := range c {
+		if a.Enabled() {
+			return true
+		}
+	}
+	return false
+}
+
+// Authenticate возвращает первый подошедший токен
+func (c Chain) Authenticate(token string) (*Token, bool) {
+	for _, a := range c {
+		if t, ok := a.Authenticate(token); ok {
+			return t, true
+		}
+	}
+	return nil, false
+}
+
+// Has сообщает, что токену разрешена роль role, администратору разрешено все
+func (t *Token) Has(role string) bool {
+	for _, r := range t.Roles {
+		if r == role || r == RoleAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidRole проверяет название роли
+func ValidRole(role string) bool {
+	return role == RoleIngest || role == RoleRead || role == RoleAdmin
+}
+
+// Hash хеш токена для хранения
+func Hash(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// Store токены в памяти с сохранением в файл
+type Store struct {
+	mu     sync.RWMutex
+	path   string
+	tokens map[string]*Token // по хешу
+}
+
+// NewStore загружает токены из файла path. Непустой bootstrap добавляется как административный токен.
+// Если путь не задан, токены не сохраняются, а проверка ролей ingest и read отключена
+func NewStore(path string, bootstrap string) (*Store, error) {
+	s := &Store{path: path, tokens: map[string]*Token{}}
+	if path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		if len(data) > 0 {
+			var tokens []*Token
+			if err := json.Unmarshal(data, &tokens); err != nil {
+				return nil, fmt.Errorf("failed to parse tokens %s: %w", path, err)
+			}
+			for _, t := range tokens {
+				s.tokens[t.Hash] = t
+			}
+		}
+	}
+	if bootstrap != "" {
+		s.tokens[Hash(bootstrap)] = &Token{ID: BootstrapID, Roles: []string{RoleAdmin}}
+	}
+	return s, nil
+}
+
+// Enabled сообщает, что роли ingest и read проверяются
+func (s *Store) Enabled() bool {
+	return s.path != ""
+}
+
+// Authenticate находит токен по открытому значению
+func (s *Store) Authenticate(token string) (*Token, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	t, ok := s.tokens[Hash(token)]
+	return t, ok
+}
+
+// List возвращает токены без хешей, отсортированные по идентификатору
+func (s *Store) List() []Token {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	list := make([]Token, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		c := *t
+		c.Hash = ""
+		list = append(list, c)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].ID < list[j].ID })
+	return list
+}
+
+// Create выпускает новый токен и возвращает его открытое значение
+func (s *Store) Create(id string, roles []string) (string, Token, error) {
+	if !s.Enabled() {
+		return "", Token{}, ErrNotPersistent
+	}
+	for _, r := range roles {
+		if !ValidRole(r) {
+			return "", Token{}, fmt.Errorf("%w %q", ErrInvalidRole, r)
+		}
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return "", Token{}, err
+	}
+	plain := base64.RawURLEncoding.EncodeToString(raw)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, t := range s.tokens {
+		if t.ID == id {
+			return "", Token{}, ErrExists
+		}
+	}
+	t := &Token{ID: id, Hash: Hash(plain), Roles: roles, Created: time.Now().UTC()}
+	s.tokens[t.Hash] = t
+	if err := s.saveLocked(); err != nil {
+		delete(s.tokens, t.Hash)
+		return "", Token{}, err
+	}
+	c := *t
+	c.Hash = ""
+	return plain, c, nil
+}
+
+// Revoke удаляет токен по идентификатору, токен из конфигурации удалить нельзя
+func (s *Store) Revoke(id string) (bool, error) {
+	if !s.Enabled() {
+		return false, ErrNotPersistent
+	}
+	if id == BootstrapID {
+		return false, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for hash, t := range s.tokens {
+		if t.ID != id {
+			continue
+		}
+		delete(s.tokens, hash)
+		if err := s.saveLocked(); err != nil {
+			s.tokens[hash] = t
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
+
+// saveLocked атомарно перезаписывает файл токенов
+func (s *Store) saveLocked() error {
+	if s.path == "" {
+		return nil
+	}
+	tokens := make([]*Token, 0, len(s.tokens))
+	for _, t := range s.tokens {
+		if t.ID != BootstrapID {
+			tokens = append(tokens, t)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].ID < tokens[j].ID })
+	data, err := json.MarshalIndent(tokens, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".tokens-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
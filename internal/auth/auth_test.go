@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.json")
+	s, err := NewStore(path, "root")
+	require.NoError(t, err)
+	assert.True(t, s.Enabled())
+
+	root, ok := s.Authenticate("root")
+	require.True(t, ok)
+	assert.True(t, root.Has(RoleRead))
+
+	plain, tok, err := s.Create("agent-1", []string{RoleIngest})
+	require.NoError(t, err)
+	assert.Empty(t, tok.Hash)
+	_, _, err = s.Create("agent-1", []string{RoleIngest})
+	assert.ErrorIs(t, err, ErrExists)
+	_, _, err = s.Create("agent-2", []string{"owner"})
+	assert.ErrorIs(t, err, ErrInvalidRole)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), plain, "only hashes are stored")
+	assert.NotContains(t, string(data), BootstrapID)
+
+	// токены переживают перезапуск
+	s, err = NewStore(path, "")
+	require.NoError(t, err)
+	got, ok := s.Authenticate(plain)
+	require.True(t, ok)
+	assert.True(t, got.Has(RoleIngest))
+	assert.False(t, got.Has(RoleRead))
+	_, ok = s.Authenticate("root")
+	assert.False(t, ok)
+	assert.Len(t, s.List(), 1)
+
+	revoked, err := s.Revoke("agent-1")
+	require.NoError(t, err)
+	assert.True(t, revoked)
+	_, ok = s.Authenticate(plain)
+	assert.False(t, ok)
+	revoked, err = s.Revoke("agent-1")
+	require.NoError(t, err)
+	assert.False(t, revoked)
+}
+
+func TestStoreWithoutFile(t *testing.T) {
+	s, err := NewStore("", "root")
+	require.NoError(t, err)
+	assert.False(t, s.Enabled())
+	_, _, err = s.Create("agent-1", []string{RoleIngest})
+	assert.ErrorIs(t, err, ErrNotPersistent)
+	_, err = s.Revoke("agent-1")
+	assert.ErrorIs(t, err, ErrNotPersistent)
+}
@@ -0,0 +1,200 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// jwk ключ из JWKS (RFC 7517), поддерживаются RSA, EC P-256 и симметричные ключи
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	K   string `json:"k"`
+}
+
+// jwtKey ключ проверки подписи и алгоритм, для которого он допустим
+type jwtKey struct {
+	alg string
+	key any
+}
+
+func decodeB64(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// parse преобразует JWK в ключ проверки подписи
+func (k jwk) parse() (jwtKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeB64(k.N)
+		if err != nil {
+			return jwtKey{}, err
+		}
+		e, err := decodeB64(k.E)
+		if err != nil {
+			return jwtKey{}, err
+		}
+		pub := &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		return jwtKey{alg: "RS256", key: pub}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return jwtKey{}, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeB64(k.X)
+		if err != nil {
+			return jwtKey{}, err
+		}
+		y, err := decodeB64(k.Y)
+		if err != nil {
+			return jwtKey{}, err
+		}
+		pub := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !pub.Curve.IsOnCurve(pub.X, pub.Y) {
+			return jwtKey{}, errors.New("point is not on the curve")
+		}
+		return jwtKey{alg: "ES256", key: pub}, nil
+	case "oct":
+		secret, err := decodeB64(k.K)
+		if err != nil {
+			return jwtKey{}, err
+		}
+		if len(secret) < 32 {
+			return jwtKey{}, errors.New("HS256 key must be at least 32 bytes")
+		}
+		return jwtKey{alg: "HS256", key: secret}, nil
+	}
+	return jwtKey{}, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// JWTConfig настройки проверки JWT
+type JWTConfig struct {
+	// JWKSFile локальный файл с ключами
+	JWKSFile string
+	Issuer   string
+	Audience string
+	// RolesClaim утверждение со списком ролей
+	RolesClaim string
+	// PrefixesClaim утверждение с разрешенными для записи префиксами имен метрик
+	PrefixesClaim string
+}
+
+// JWTVerifier проверяет JWT по ключам из локального JWKS
+type JWTVerifier struct {
+	cfg  JWTConfig
+	keys map[string]jwtKey
+}
+
+// NewJWTVerifier загружает JWKS
+func NewJWTVerifier(cfg JWTConfig) (*JWTVerifier, error) {
+	data, err := os.ReadFile(cfg.JWKSFile)
+	if err != nil {
+		return nil, err
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("failed to parse JWKS %s: %w", cfg.JWKSFile, err)
+	}
+	if cfg.RolesClaim == "" {
+		cfg.RolesClaim = "roles"
+	}
+	if cfg.PrefixesClaim == "" {
+		cfg.PrefixesClaim = "prefixes"
+	}
+	v := &JWTVerifier{cfg: cfg, keys: map[string]jwtKey{}}
+	for _, k := range set.Keys {
+		key, err := k.parse()
+		if err != nil {
+			return nil, fmt.Errorf("JWKS key %q: %w", k.Kid, err)
+		}
+		if k.Alg != "" && k.Alg != key.alg {
+			return nil, fmt.Errorf("JWKS key %q: unsupported alg %q", k.Kid, k.Alg)
+		}
+		v.keys[k.Kid] = key
+	}
+	if len(v.keys) == 0 {
+		return nil, fmt.Errorf("no keys in JWKS %s", cfg.JWKSFile)
+	}
+	return v, nil
+}
+
+// Enabled JWT всегда требует проверки ролей
+func (v *JWTVerifier) Enabled() bool {
+	return true
+}
+
+// Authenticate проверяет подпись, срок действия, издателя и аудиторию JWT и переводит утверждения в роли
+func (v *JWTVerifier) Authenticate(token string) (*Token, bool) {
+	opts := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{"RS256", "ES256", "HS256"}),
+		jwt.WithExpirationRequired(),
+	}
+	if v.cfg.Issuer != "" {
+		opts = append(opts, jwt.WithIssuer(v.cfg.Issuer))
+	}
+	if v.cfg.Audience != "" {
+		opts = append(opts, jwt.WithAudience(v.cfg.Audience))
+	}
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims, func(t *jwt.Token) (any, error) {
+		kid, _ := t.Header["kid"].(string)
+		key, ok := v.keys[kid]
+		if !ok {
+			return nil, fmt.Errorf("unknown key %q", kid)
+		}
+		// алгоритм берется из ключа, а не из заголовка токена
+		if t.Method.Alg() != key.alg {
+			return nil, fmt.Errorf("key %q does not allow %s", kid, t.Method.Alg())
+		}
+		return key.key, nil
+	}, opts...)
+	if err != nil {
+		return nil, false
+	}
+	sub, err := claims.GetSubject()
+	if err != nil || sub == "" {
+		return nil, false
+	}
+	t := &Token{ID: sub}
+	for _, r := range stringList(claims[v.cfg.RolesClaim]) {
+		if ValidRole(r) {
+			t.Roles = append(t.Roles, r)
+		}
+	}
+	t.Prefixes = stringList(claims[v.cfg.PrefixesClaim])
+	return t, true
+}
+
+// stringList читает утверждение-список: массив строк или строку через пробел, как scope в OAuth 2.0
+func stringList(v any) []string {
+	switch v := v.(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		var out []string
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				out = append(out, s)
+			}
+		}
+		return out
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// writeJWKS генерирует ключи RSA, EC и HMAC и сохраняет открытые части в JWKS
+func writeJWKS(t *testing.T) (string, *rsa.PrivateKey, *ecdsa.PrivateKey, []byte) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	require.NoError(t, err)
+
+	set := map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "alg": "RS256", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X.FillBytes(make([]byte, 32))), "y": b64(ecKey.Y.FillBytes(make([]byte, 32)))},
+		{"kty": "oct", "kid": "hmac", "k": b64(secret)},
+	}}
+	data, err := json.Marshal(set)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, data, 0o644))
+	return path, rsaKey, ecKey, secret
+}
+
+func TestJWTVerifier(t *testing.T) {
+	path, rsaKey, ecKey, secret := writeJWKS(t)
+	v, err := NewJWTVerifier(JWTConfig{JWKSFile: path, Issuer: "metrics-idp", Audience: "metrics"})
+	require.NoError(t, err)
+
+	claims := func(mod func(jwt.MapClaims)) jwt.MapClaims {
+		c := jwt.MapClaims{
+			"sub":      "agent-1",
+			"iss":      "metrics-idp",
+			"aud":      "metrics",
+			"exp":      time.Now().Add(time.Hour).Unix(),
+			"roles":    []string{"ingest", "owner"},
+			"prefixes": "host1. Runtime",
+		}
+		if mod != nil {
+			mod(c)
+		}
+		return c
+	}
+	sign := func(method jwt.SigningMethod, kid string, key any, c jwt.MapClaims) string {
+		tok := jwt.NewWithClaims(method, c)
+		tok.Header["kid"] = kid
+		s, err := tok.SignedString(key)
+		require.NoError(t, err)
+		return s
+	}
+
+	testCases := []struct {
+		name  string
+		token string
+		ok    bool
+	}{
+		{name: "RS256", token: sign(jwt.SigningMethodRS256, "rsa", rsaKey, claims(nil)), ok: true},
+		{name: "ES256", token: sign(jwt.SigningMethodES256, "ec", ecKey, claims(nil)), ok: true},
+		{name: "HS256", token: sign(jwt.SigningMethodHS256, "hmac", secret, claims(nil)), ok: true},
+		{name: "expired", token: sign(jwt.SigningMethodRS256, "rsa", rsaKey, claims(func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Minute).Unix() }))},
+		{name: "no expiration", token: sign(jwt.SigningMethodRS256, "rsa", rsaKey, claims(func(c jwt.MapClaims) { delete(c, "exp") }))},
+		{name: "wrong issuer", token: sign(jwt.SigningMethodRS256, "rsa", rsaKey, claims(func(c jwt.MapClaims) { c["iss"] = "other" }))},
+		{name: "wrong audience", token: sign(jwt.SigningMethodRS256, "rsa", rsaKey, claims(func(c jwt.MapClaims) { c["aud"] = "other" }))},
+		{name: "no subject", token: sign(jwt.SigningMethodRS256, "rsa", rsaKey, claims(func(c jwt.MapClaims) { delete(c, "sub") }))},
+		{name: "unknown kid", token: sign(jwt.SigningMethodRS256, "old", rsaKey, claims(nil))},
+		{name: "algorithm of another key", token: sign(jwt.SigningMethodHS256, "rsa", secret, claims(nil))},
+		{name: "not a JWT", token: "static-token"},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := v.Authenticate(test.token)
+			require.Equal(t, test.ok, ok)
+			if !ok {
+				return
+			}
+			assert.Equal(t, "agent-1", got.ID)
+			assert.Equal(t, []string{RoleIngest}, got.Roles, "unknown roles are dropped")
+			assert.True(t, got.Allows("host1.cpu"))
+			assert.True(t, got.Allows("RuntimeAlloc"))
+			assert.False(t, got.Allows("host2.cpu"))
+		})
+	}
+}
@@ -0,0 +1,191 @@
+// Package codec содержит реестр алгоритмов сжатия тел запросов и ответов
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+var (
+	// ErrUnsupported алгоритм сжатия не зарегистрирован
+	ErrUnsupported = errors.New("unsupported encoding")
+	// ErrInvalidLevel уровень сжатия вне шкалы алгоритма
+	ErrInvalidLevel = errors.New("invalid compression level")
+)
+
+// Codec алгоритм сжатия, имя совпадает со значением заголовка Content-Encoding
+type Codec interface {
+	// Name имя алгоритма в заголовках Content-Encoding и Accept-Encoding
+	Name() string
+	// NewReader создает распаковщик. maxSize ограничивает память распаковщика
+	// для алгоритмов с настраиваемым окном, 0 — без ограничения
+	NewReader(r io.Reader, maxSize int64) (io.ReadCloser, error)
+	// NewWriter создает упаковщик, level 0 означает уровень по умолчанию
+	NewWriter(w io.Writer, level int) (io.WriteCloser, error)
+}
+
+// Leveler алгоритм с ограниченной шкалой уровней сжатия
+type Leveler interface {
+	// Levels возвращает допустимые уровни сжатия, кроме 0
+	Levels() (min int, max int)
+}
+
+// CheckLevel проверяет уровень сжатия по шкале алгоритма, 0 допустим всегда
+func CheckLevel(c Codec, level int) error {
+	l, ok := c.(Leveler)
+	if !ok || level == 0 {
+		return nil
+	}
+	if min, max := l.Levels(); level < min || level > max {
+		return fmt.Errorf("%w %d for %s, allowed %d to %d", ErrInvalidLevel, level, c.Name(), min, max)
+	}
+	return nil
+}
+
+var (
+	mu       sync.RWMutex
+	registry = map[string]Codec{}
+	aliases  = map[string]string{}
+	order    []string
+)
+
+// Register добавляет алгоритм в реестр. Порядок регистрации задает предпочтение
+// при выборе алгоритма сжатия ответа
+func Register(c Codec, alias ...string) {
+	mu.Lock()
+	defer mu.Unlock()
+	name := c.Name()
+	if _, ok := registry[name]; !ok {
+		order = append(order, name)
+	}
+	registry[name] = c
+	for _, a := range alias {
+		aliases[a] = name
+	}
+}
+
+// Get возвращает алгоритм по имени или псевдониму без учета регистра
+func Get(name string) (Codec, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	name = strings.ToLower(strings.TrimSpace(name))
+	if a, ok := aliases[name]; ok {
+		name = a
+	}
+	c, ok := registry[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnsupported, name)
+	}
+	return c, nil
+}
+
+// Names возвращает имена зарегистрированных алгоритмов в порядке предпочтения
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	res := make([]string, len(order))
+	copy(res, order)
+	return res
+}
+
+// Compress сжимает данные целиком
+func Compress(c Codec, b []byte, level int) ([]byte, error) {
+	var bf bytes.Buffer
+	w, err := c.NewWriter(&bf, level)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = w.Write(b); err != nil {
+		return nil, err
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+	return bf.Bytes(), nil
+}
+
+func init() {
+	Register(zstdCodec{})
+	Register(gzipCodec{}, "x-gzip")
+	Register(deflateCodec{})
+}
+
+// gzipCodec сжатие gzip
+type gzipCodec struct{}
+
+func (gzipCodec) Name() string { return "gzip" }
+
+func (gzipCodec) Levels() (int, int) { return gzip.HuffmanOnly, gzip.BestCompression }
+
+func (gzipCodec) NewReader(r io.Reader, _ int64) (io.ReadCloser, error) {
+	return gzip.NewReader(r)
+}
+
+func (gzipCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+	return gzip.NewWriterLevel(w, level)
+}
+
+// deflateCodec сжатие deflate, в HTTP это поток в формате zlib (RFC 9110)
+type deflateCodec struct{}
+
+func (deflateCodec) Name() string { return "deflate" }
+
+func (deflateCodec) Levels() (int, int) { return zlib.HuffmanOnly, zlib.BestCompression }
+
+func (deflateCodec) NewReader(r io.Reader, _ int64) (io.ReadCloser, error) {
+	return zlib.NewReader(r)
+}
+
+func (deflateCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	if level == 0 {
+		level = zlib.DefaultCompression
+	}
+	return zlib.NewWriterLevel(w, level)
+}
+
+// zstdCodec сжатие zstd, уровень задается в шкале zstd от 1 до 22
+type zstdCodec struct{}
+
+func (zstdCodec) Name() string { return "zstd" }
+
+func (zstdCodec) Levels() (int, int) { return 1, 22 }
+
+// NewReader ограничивает окно и память распаковщика размером maxSize: иначе кадр,
+// объявивший большое окно, заставит выделить память до начала проверки размера тела
+func (zstdCodec) NewReader(r io.Reader, maxSize int64) (io.ReadCloser, error) {
+	opts := []zstd.DOption{zstd.WithDecoderConcurrency(1)}
+	if maxSize > 0 {
+		window := uint64(maxSize)
+		if window < zstd.MinWindowSize {
+			window = zstd.MinWindowSize
+		}
+		if window > zstd.MaxWindowSize {
+			window = zstd.MaxWindowSize
+		}
+		opts = append(opts, zstd.WithDecoderMaxWindow(window), zstd.WithDecoderMaxMemory(uint64(maxSize)))
+	}
+	d, err := zstd.NewReader(r, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
+
+func (zstdCodec) NewWriter(w io.Writer, level int) (io.WriteCloser, error) {
+	opts := []zstd.EOption{zstd.WithEncoderConcurrency(1)}
+	if level > 0 {
+		opts = append(opts, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	}
+	return zstd.NewWriter(w, opts...)
+}
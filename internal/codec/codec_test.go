@@ -0,0 +1,100 @@
+package codec
+
+import (
+	"bytes"
+	"io"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoundTrip(t *testing.T) {
+	payload := bytes.Repeat([]byte(`{"id":"CPUutilization1","type":"gauge","value":12.5},`), 200)
+	testCases := []struct {
+		name  string
+		level int
+	}{
+		{name: "gzip", level: 0},
+		{name: "gzip", level: 9},
+		{name: "x-gzip", level: 1},
+		{name: "deflate", level: 0},
+		{name: "zstd", level: 0},
+		{name: "zstd", level: 19},
+		{name: "ZSTD", level: 3},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := Get(test.name)
+			require.NoError(t, err)
+			packed, err := Compress(c, payload, test.level)
+			require.NoError(t, err)
+			assert.Less(t, len(packed), len(payload))
+
+			r, err := c.NewReader(bytes.NewReader(packed), 0)
+			require.NoError(t, err)
+			defer r.Close()
+			got, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.Equal(t, payload, got)
+		})
+	}
+}
+
+func TestZstdMaxWindow(t *testing.T) {
+	// кадр zstd с окном 64 МиБ и одним несжатым блоком
+	payload := []byte(`{"id":"PollCount","type":"counter","delta":1}`)
+	frame := []byte{0x28, 0xb5, 0x2f, 0xfd, 0x00, 16 << 3}
+	block := uint32(len(payload))<<3 | 1
+	frame = append(frame, byte(block), byte(block>>8), byte(block>>16))
+	frame = append(frame, payload...)
+
+	c, err := Get("zstd")
+	require.NoError(t, err)
+	r, err := c.NewReader(bytes.NewReader(frame), 1<<20)
+	require.NoError(t, err)
+	defer r.Close()
+	_, err = io.ReadAll(r)
+	assert.ErrorIs(t, err, zstd.ErrWindowSizeExceeded)
+
+	r, err = c.NewReader(bytes.NewReader(frame), 0)
+	require.NoError(t, err)
+	defer r.Close()
+	got, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, payload, got)
+}
+
+func TestGet(t *testing.T) {
+	_, err := Get("br")
+	assert.ErrorIs(t, err, ErrUnsupported)
+	assert.Equal(t, []string{"zstd", "gzip", "deflate"}, Names())
+}
+
+func TestCheckLevel(t *testing.T) {
+	testCases := []struct {
+		name  string
+		level int
+		valid bool
+	}{
+		{name: "gzip", level: 0, valid: true},
+		{name: "gzip", level: 9, valid: true},
+		{name: "gzip", level: 10},
+		{name: "deflate", level: -1, valid: true},
+		{name: "deflate", level: 12},
+		{name: "zstd", level: 22, valid: true},
+		{name: "zstd", level: 23},
+		{name: "zstd", level: -1},
+	}
+	for _, test := range testCases {
+		c, err := Get(test.name)
+		require.NoError(t, err)
+		err = CheckLevel(c, test.level)
+		if test.valid {
+			assert.NoError(t, err, "%s level %d", test.name, test.level)
+			continue
+		}
+		assert.ErrorIs(t, err, ErrInvalidLevel, "%s level %d", test.name, test.level)
+	}
+}
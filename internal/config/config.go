@@ -3,8 +3,12 @@ package config
 import (
 	"flag"
 	"github.com/caarlos0/env"
+	"github.com/lionslon/go-yapmetrics/internal/auth"
 	"github.com/lionslon/go-yapmetrics/internal/storage"
+	"github.com/lionslon/go-yapmetrics/internal/tlsutil"
+	"github.com/lionslon/go-yapmetrics/internal/validation"
 	"go.uber.org/zap"
+	"time"
 )
 
 // ClientConfig конфиг агента
@@ -14,17 +18,82 @@ type ClientConfig struct {
 	RateLimit      int    `env:"RATE_LIMIT"`
 	Addr           string `env:"ADDRESS"`
 	SignPass       string `env:"KEY"`
+	KeyID          string `env:"KEY_ID"`
+	CryptoKey      string `env:"CRYPTO_KEY"`
+	Token          string `env:"TOKEN"`
+	TokenFile      string `env:"TOKEN_FILE"`
+	Codec          string `env:"COMPRESS_CODEC"`
+	CodecLevel     int    `env:"COMPRESS_LEVEL"`
+	// TLS
+	TLS           bool   `env:"TLS"`
+	TLSCA         string `env:"TLS_CA"`
+	TLSCert       string `env:"TLS_CERT"`
+	TLSKey        string `env:"TLS_KEY"`
+	TLSServerName string `env:"TLS_SERVER_NAME"`
 }
 
 // ServerConfig конфиг сервера
 type ServerConfig struct {
-	Addr            string `env:"ADDRESS"`
-	StoreInterval   int    `env:"STORE_INTERVAL"`
-	FilePath        string `env:"FILE_STORAGE_PATH"`
-	Restore         bool   `env:"RESTORE"`
-	DatabaseDSN     string `env:"DATABASE_DSN"`
-	SignPass        string `env:"KEY"`
-	EnableProfiling bool   `env:"ENABLE_PROFILING"`
+	Addr            string        `env:"ADDRESS"`
+	StoreInterval   int           `env:"STORE_INTERVAL"`
+	FilePath        string        `env:"FILE_STORAGE_PATH"`
+	Restore         bool          `env:"RESTORE"`
+	DatabaseDSN     string        `env:"DATABASE_DSN"`
+	SignPass        string        `env:"KEY"`
+	CryptoKey       string        `env:"CRYPTO_KEY"`
+	AllowPlaintext  bool          `env:"ALLOW_PLAINTEXT"`
+	SignMode        string        `env:"SIGN_MODE"`
+	Keyring         string        `env:"KEYRING"`
+	KeyringFile     string        `env:"KEYRING_FILE"`
+	KeyringReload   time.Duration `env:"KEYRING_RELOAD"`
+	ReplayWindow    time.Duration `env:"REPLAY_WINDOW"`
+	NonceCacheSize  int           `env:"NONCE_CACHE_SIZE"`
+	EnableProfiling bool          `env:"ENABLE_PROFILING"`
+	AdminToken      string        `env:"ADMIN_TOKEN"`
+	TokensFile      string        `env:"TOKENS_FILE"`
+	ACLFile         string        `env:"ACL_FILE"`
+	AlertRules      string        `env:"ALERT_RULES"`
+	AlertInterval   time.Duration `env:"ALERT_INTERVAL"`
+	AlertWebhooks   string        `env:"ALERT_WEBHOOKS"`
+	AlertRetries    int           `env:"ALERT_WEBHOOK_RETRIES"`
+	AlertRetryMin   time.Duration `env:"ALERT_WEBHOOK_RETRY_MIN"`
+	AlertRetryMax   time.Duration `env:"ALERT_WEBHOOK_RETRY_MAX"`
+	AlertTimeout    time.Duration `env:"ALERT_WEBHOOK_TIMEOUT"`
+	AlertQueueSize  int           `env:"ALERT_QUEUE_SIZE"`
+	AlertDeadLetter string        `env:"ALERT_DEAD_LETTER"`
+	AlertRouting    string        `env:"ALERT_ROUTING"`
+	CounterHistory  time.Duration `env:"COUNTER_HISTORY"`
+	// Recording rules
+	RecordingRules    string        `env:"RECORDING_RULES"`
+	RecordingInterval time.Duration `env:"RECORDING_INTERVAL"`
+	RecordingReload   time.Duration `env:"RECORDING_RELOAD"`
+	// JWT
+	JWKSFile         string `env:"JWKS_FILE"`
+	JWTIssuer        string `env:"JWT_ISSUER"`
+	JWTAudience      string `env:"JWT_AUDIENCE"`
+	JWTRolesClaim    string `env:"JWT_ROLES_CLAIM"`
+	JWTPrefixesClaim string `env:"JWT_PREFIXES_CLAIM"`
+	TrustedSubnet    string `env:"TRUSTED_SUBNET"`
+	RealIPSource     string `env:"REAL_IP_SOURCE"`
+	TrustedProxies   string `env:"TRUSTED_PROXIES"`
+	// ограничения входящих данных
+	MetricNamePattern  string `env:"METRIC_NAME_PATTERN"`
+	MaxNameLength      int    `env:"MAX_NAME_LENGTH"`
+	MaxBatchItems      int    `env:"MAX_BATCH_ITEMS"`
+	MaxBodySize        int64  `env:"MAX_BODY_SIZE"`
+	MaxDecodedBodySize int64  `env:"MAX_DECODED_BODY_SIZE"`
+	MaxDecompressRatio int64  `env:"MAX_DECOMPRESS_RATIO"`
+	// сжатие ответов
+	CompressLevel     int `env:"COMPRESS_LEVEL"`
+	CompressMinLength int `env:"COMPRESS_MIN_LENGTH"`
+	// TLS и mTLS
+	TLSCert       string `env:"TLS_CERT"`
+	TLSKey        string `env:"TLS_KEY"`
+	TLSMinVersion string `env:"TLS_MIN_VERSION"`
+	TLSCiphers    string `env:"TLS_CIPHERS"`
+	TLSClientCA   string `env:"TLS_CLIENT_CA"`
+	TLSClientAuth string `env:"TLS_CLIENT_AUTH"`
+	TLSIdentities string `env:"TLS_IDENTITIES"`
 }
 
 // NewClient парсит флаги и env + инициализирует конфиг агента
@@ -45,6 +114,17 @@ func parseClientFlags(c *ClientConfig) {
 	flag.IntVar(&c.RateLimit, "l", 10, "rate limit")
 	flag.IntVar(&c.PollInterval, "p", 2, "poll interval in seconds")
 	flag.StringVar(&c.SignPass, "k", "", "signature for HashSHA256")
+	flag.StringVar(&c.KeyID, "key-id", "", "id of the signing key sent in the Key-Id header")
+	flag.StringVar(&c.CryptoKey, "crypto-key", "", "path to the server public key in PEM, request bodies are encrypted when set")
+	flag.StringVar(&c.Token, "token", "", "bearer token with the ingest role")
+	flag.StringVar(&c.TokenFile, "token-file", "", "file with a bearer token or JWT, re-read when it changes")
+	flag.StringVar(&c.Codec, "codec", "gzip", "request body compression: zstd, gzip or deflate")
+	flag.IntVar(&c.CodecLevel, "codec-level", 0, "compression level: 1 to 9 for gzip and deflate, 1 to 22 for zstd, 0 for codec default")
+	flag.BoolVar(&c.TLS, "tls", false, "report over https")
+	flag.StringVar(&c.TLSCA, "tls-ca", "", "CA bundle in PEM to verify the server, system roots by default")
+	flag.StringVar(&c.TLSCert, "tls-cert", "", "client certificate in PEM for mutual TLS")
+	flag.StringVar(&c.TLSKey, "tls-key", "", "client certificate key in PEM for mutual TLS")
+	flag.StringVar(&c.TLSServerName, "tls-server-name", "", "server name to verify instead of the host from the address")
 	flag.Parse()
 }
 
@@ -60,22 +140,138 @@ func NewServer() *ServerConfig {
 	return cfg
 }
 
-func parseServerFlags(s *ServerConfig) {
-	flag.StringVar(&s.Addr, "a", "localhost:8080", "address and port to run server")
-	flag.IntVar(&s.StoreInterval, "i", 300, "interval for saving metrics on the server")
-	flag.StringVar(&s.FilePath, "f", "/tmp/metrics-db.json", "file storage path for saving data")
-	flag.BoolVar(&s.Restore, "r", true, "need to load data at startup")
-	flag.StringVar(&s.DatabaseDSN, "d", "", "Database Data Source Name")
-	flag.StringVar(&s.SignPass, "k", "", "signature for HashSHA256")
-	flag.BoolVar(&s.EnableProfiling, "p", false, "run pprof server")
+// serverFlags регистрирует флаги в fs
+func serverFlags(fs *flag.FlagSet, s *ServerConfig) {
+	fs.StringVar(&s.Addr, "a", "localhost:8080", "address and port to run server")
+	fs.IntVar(&s.StoreInterval, "i", 300, "interval for saving metrics on the server")
+	fs.StringVar(&s.FilePath, "f", "/tmp/metrics-db.json", "file storage path for saving data")
+	fs.BoolVar(&s.Restore, "r", true, "need to load data at startup")
+	fs.StringVar(&s.DatabaseDSN, "d", "", "Database Data Source Name")
+	fs.StringVar(&s.SignPass, "k", "", "signature for HashSHA256")
+	fs.StringVar(&s.CryptoKey, "crypto-key", "", "path to the private key in PEM for decrypting request bodies, unencrypted metric updates are rejected when set")
+	fs.BoolVar(&s.AllowPlaintext, "allow-plaintext", false, "accept unencrypted metric updates while -crypto-key is set, for rolling out encryption to agents")
+	fs.StringVar(&s.SignMode, "sign-mode", "strict", "signature check of metric updates: strict rejects unsigned updates, permissive lets them through")
+	fs.StringVar(&s.Keyring, "keyring", "", "signing keys by id: id1:secret1,id2:secret2")
+	fs.StringVar(&s.KeyringFile, "keyring-file", "", "JSON file with signing keys by id, reloaded on change and on SIGHUP")
+	fs.DurationVar(&s.KeyringReload, "keyring-reload", 30*time.Second, "how often to check the keyring file for changes")
+	fs.DurationVar(&s.ReplayWindow, "replay-window", 0, "allowed clock skew for signed requests, requires X-Sign-Timestamp and X-Sign-Nonce on every signed request, 0 disables replay protection")
+	fs.IntVar(&s.NonceCacheSize, "nonce-cache-size", 100000, "max number of remembered request nonces")
+	fs.BoolVar(&s.EnableProfiling, "p", false, "run pprof server")
+	fs.StringVar(&s.AdminToken, "admin-token", "", "bootstrap bearer token with the admin role, not stored in the tokens file")
+	fs.StringVar(&s.TokensFile, "tokens-file", "", "JSON file with hashed bearer tokens and roles, enables ingest and read role checks")
+	fs.StringVar(&s.AlertRules, "alert-rules", "", "JSON file with threshold alert rules")
+	fs.DurationVar(&s.AlertInterval, "alert-interval", 15*time.Second, "how often alert rules are evaluated")
+	fs.StringVar(&s.AlertWebhooks, "alert-webhooks", "", "JSON file with webhooks notified about firing and resolved alerts")
+	fs.IntVar(&s.AlertRetries, "alert-webhook-retries", 5, "delivery retries with exponential backoff")
+	fs.DurationVar(&s.AlertRetryMin, "alert-webhook-retry-min", time.Second, "first delay between delivery retries")
+	fs.DurationVar(&s.AlertRetryMax, "alert-webhook-retry-max", 30*time.Second, "max delay between delivery retries")
+	fs.DurationVar(&s.AlertTimeout, "alert-webhook-timeout", 10*time.Second, "timeout of one delivery attempt")
+	fs.IntVar(&s.AlertQueueSize, "alert-queue-size", 1000, "notifications waiting for delivery, overflow goes to the dead letter file")
+	fs.StringVar(&s.AlertDeadLetter, "alert-dead-letter", "", "JSON Lines file for notifications that could not be delivered")
+	fs.DurationVar(&s.CounterHistory, "counter-history", storage.DefaultHistoryRetention, "how long counter samples are kept for rate and increase, 0 disables")
+	fs.StringVar(&s.RecordingRules, "recording-rules", "", "JSON file with recording rules that write derived gauges, rule names must start with recorded:")
+	fs.DurationVar(&s.RecordingInterval, "recording-interval", 15*time.Second, "how often recording rules are evaluated")
+	fs.DurationVar(&s.RecordingReload, "recording-reload", 30*time.Second, "how often to check the recording rules file for changes")
+	fs.StringVar(&s.AlertRouting, "alert-routing", "", "JSON file with alert grouping, repeat interval and inhibition rules")
+	fs.StringVar(&s.ACLFile, "acl-file", "", "JSON file with write rules binding key:, token: and cert: identities to metric prefixes and labels")
+	fs.StringVar(&s.JWKSFile, "jwks-file", "", "local JWKS file for RS256, ES256 and HS256 bearer JWTs, enables role checks")
+	fs.StringVar(&s.JWTIssuer, "jwt-issuer", "", "required JWT iss claim")
+	fs.StringVar(&s.JWTAudience, "jwt-audience", "", "required JWT aud claim")
+	fs.StringVar(&s.JWTRolesClaim, "jwt-roles-claim", "roles", "JWT claim with roles")
+	fs.StringVar(&s.JWTPrefixesClaim, "jwt-prefixes-claim", "prefixes", "JWT claim with metric name prefixes the token may write")
+	fs.StringVar(&s.TrustedSubnet, "t", "", "CIDR of agents allowed to send metrics, comma separated, empty allows all")
+	fs.StringVar(&s.RealIPSource, "real-ip-source", "peer", "where to take the client IP for the trusted subnet check: peer or header (X-Real-IP, only from -trusted-proxies)")
+	fs.StringVar(&s.TrustedProxies, "trusted-proxies", "", "CIDR of proxies whose X-Real-IP header is trusted with -real-ip-source=header, comma separated")
+	fs.StringVar(&s.MetricNamePattern, "name-pattern", validation.DefaultNamePattern, "regular expression for metric names")
+	fs.IntVar(&s.MaxNameLength, "max-name-length", storage.MaxNameLength, "max metric name length, can not exceed the database column size")
+	fs.IntVar(&s.MaxBatchItems, "max-batch-items", validation.DefaultMaxBatchItems, "max number of metrics in one batch")
+	fs.Int64Var(&s.MaxBodySize, "max-body-size", 8<<20, "max request body size in bytes before decompression")
+	fs.Int64Var(&s.MaxDecodedBodySize, "max-decoded-body-size", 32<<20, "max request body size in bytes after decompression")
+	fs.Int64Var(&s.MaxDecompressRatio, "max-decompress-ratio", 100, "max ratio of decompressed to compressed request body size")
+	fs.IntVar(&s.CompressLevel, "compress-level", 5, "response compression level, must fit every codec: 1 to 9, 0 for codec default")
+	fs.IntVar(&s.CompressMinLength, "compress-min-length", 256, "min response size in bytes to compress")
+	fs.StringVar(&s.TLSCert, "tls-cert", "", "server certificate in PEM, enables https")
+	fs.StringVar(&s.TLSKey, "tls-key", "", "server certificate key in PEM")
+	fs.StringVar(&s.TLSMinVersion, "tls-min-version", "1.2", "minimal TLS version: 1.2 or 1.3")
+	fs.StringVar(&s.TLSCiphers, "tls-ciphers", tlsutil.CiphersModern, "TLS 1.2 cipher suites: modern, compatible or a comma separated list")
+	fs.StringVar(&s.TLSClientCA, "tls-client-ca", "", "CA bundle in PEM for client certificates, enables mutual TLS")
+	fs.StringVar(&s.TLSClientAuth, "tls-client-auth", tlsutil.ClientAuthRequire, "client certificate policy: require or request")
+	fs.StringVar(&s.TLSIdentities, "tls-identities", "", "JSON file mapping client certificate subjects to agent ids, CN is used by default")
+}
 
+func parseServerFlags(s *ServerConfig) {
+	serverFlags(flag.CommandLine, s)
 	flag.Parse()
 }
 
+// DefaultServer конфиг сервера со значениями флагов по умолчанию, без разбора аргументов и env
+func DefaultServer() *ServerConfig {
+	cfg := &ServerConfig{}
+	serverFlags(flag.NewFlagSet("server", flag.ContinueOnError), cfg)
+	return cfg
+}
+
 func (s *ServerConfig) StoreIntervalNotZero() bool {
 	return s.StoreInterval != 0
 }
 
+// SigningEnabled сообщает, что запросы и ответы нужно подписывать
+func (s *ServerConfig) SigningEnabled() bool {
+	return s.SignPass != "" || s.Keyring != "" || s.KeyringFile != ""
+}
+
+// TLSEnabled сообщает, что сервер должен работать по https
+func (s *ServerConfig) TLSEnabled() bool {
+	return s.TLSCert != "" || s.TLSKey != ""
+}
+
+// TLSOptions настройки TLS сервера
+func (s *ServerConfig) TLSOptions() tlsutil.ServerOptions {
+	return tlsutil.ServerOptions{
+		CertFile:     s.TLSCert,
+		KeyFile:      s.TLSKey,
+		MinVersion:   s.TLSMinVersion,
+		Ciphers:      s.TLSCiphers,
+		ClientCAFile: s.TLSClientCA,
+		ClientAuth:   s.TLSClientAuth,
+	}
+}
+
+// TLSEnabled сообщает, что агент должен отправлять метрики по https
+func (c *ClientConfig) TLSEnabled() bool {
+	return c.TLS || c.TLSCA != "" || c.TLSCert != ""
+}
+
+// TLSOptions настройки TLS агента
+func (c *ClientConfig) TLSOptions() tlsutil.ClientOptions {
+	return tlsutil.ClientOptions{
+		CAFile:     c.TLSCA,
+		CertFile:   c.TLSCert,
+		KeyFile:    c.TLSKey,
+		ServerName: c.TLSServerName,
+	}
+}
+
+// JWTConfig настройки проверки JWT
+func (s *ServerConfig) JWTConfig() auth.JWTConfig {
+	return auth.JWTConfig{
+		JWKSFile:      s.JWKSFile,
+		Issuer:        s.JWTIssuer,
+		Audience:      s.JWTAudience,
+		RolesClaim:    s.JWTRolesClaim,
+		PrefixesClaim: s.JWTPrefixesClaim,
+	}
+}
+
+// ValidationRules правила проверки входящих метрик
+func (s *ServerConfig) ValidationRules() validation.Rules {
+	return validation.Rules{
+		NamePattern:   s.MetricNamePattern,
+		MaxNameLength: s.MaxNameLength,
+		MaxBatchItems: s.MaxBatchItems,
+	}
+}
+
 func (s *ServerConfig) GetProvider() storage.StorageProvider {
 	if s.DatabaseDSN != "" {
 		return storage.DBProvider
@@ -0,0 +1,183 @@
+// Package encryption реализует гибридное шифрование тела запросов агента:
+// тело шифруется AES-256-GCM случайным ключом, а ключ — открытым ключом RSA сервера (RSA-OAEP, SHA-256)
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Scheme название схемы шифрования, передается агентом в заголовке HeaderEncryption
+const Scheme = "rsa-oaep-aes256gcm"
+
+// HeaderEncryption заголовок, отмечающий зашифрованное тело запроса
+const HeaderEncryption = "X-Encryption"
+
+// DefaultBits размер генерируемого ключа RSA
+const DefaultBits = 4096
+
+const symmetricKeySize = 32
+
+// ErrMalformed тело не соответствует формату зашифрованного сообщения
+var ErrMalformed = errors.New("encrypted message is malformed")
+
+// Encrypt шифрует данные. Формат сообщения:
+// длина обернутого ключа (2 байта, big endian), обернутый ключ, nonce GCM, шифротекст
+func Encrypt(pub *rsa.PublicKey, plaintext []byte) ([]byte, error) {
+	key := make([]byte, symmetricKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap key: %w", err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, 2, 2+len(wrapped)+len(nonce)+len(plaintext)+gcm.Overhead())
+	binary.BigEndian.PutUint16(out, uint16(len(wrapped)))
+	out = append(out, wrapped...)
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, plaintext, nil), nil
+}
+
+// Decrypt расшифровывает сообщение, сформированное Encrypt
+func Decrypt(priv *rsa.PrivateKey, msg []byte) ([]byte, error) {
+	if len(msg) < 2 {
+		return nil, ErrMalformed
+	}
+	n := int(binary.BigEndian.Uint16(msg))
+	msg = msg[2:]
+	if n != priv.Size() || len(msg) < n {
+		return nil, ErrMalformed
+	}
+	key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, priv, msg[:n], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap key: %w", err)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	msg = msg[n:]
+	if len(msg) < gcm.NonceSize()+gcm.Overhead() {
+		return nil, ErrMalformed
+	}
+	plaintext, err := gcm.Open(nil, msg[:gcm.NonceSize()], msg[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt message: %w", err)
+	}
+	return plaintext, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != symmetricKeySize {
+		return nil, ErrMalformed
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// GenerateKeys создает пару ключей RSA и возвращает их в PEM: закрытый в PKCS#8, открытый в PKIX
+func GenerateKeys(bits int) (privPEM []byte, pubPEM []byte, err error) {
+	priv, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		return nil, nil, err
+	}
+	privDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, nil, err
+	}
+	pubDER, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	privPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privDER})
+	pubPEM = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER})
+	return privPEM, pubPEM, nil
+}
+
+// ParsePublicKey разбирает открытый ключ RSA в PEM (PKIX или PKCS#1)
+func ParsePublicKey(data []byte) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if key, err := x509.ParsePKCS1PublicKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	pub, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported public key type %T", key)
+	}
+	return pub, nil
+}
+
+// ParsePrivateKey разбирает закрытый ключ RSA в PEM (PKCS#8 или PKCS#1)
+func ParsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	priv, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported private key type %T", key)
+	}
+	return priv, nil
+}
+
+// LoadPublicKey читает открытый ключ из файла
+func LoadPublicKey(path string) (*rsa.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParsePublicKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse public key %s: %w", path, err)
+	}
+	return key, nil
+}
+
+// LoadPrivateKey читает закрытый ключ из файла
+func LoadPrivateKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	key, err := ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key %s: %w", path, err)
+	}
+	return key, nil
+}
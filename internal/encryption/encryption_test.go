@@ -0,0 +1,37 @@
+package encryption
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	privPEM, pubPEM, err := GenerateKeys(2048)
+	require.NoError(t, err)
+	priv, err := ParsePrivateKey(privPEM)
+	require.NoError(t, err)
+	pub, err := ParsePublicKey(pubPEM)
+	require.NoError(t, err)
+
+	plaintext := []byte(`[{"id":"PollCount","type":"counter","delta":1}]`)
+	msg, err := Encrypt(pub, plaintext)
+	require.NoError(t, err)
+	assert.False(t, bytes.Contains(msg, plaintext))
+
+	got, err := Decrypt(priv, msg)
+	require.NoError(t, err)
+	assert.Equal(t, plaintext, got)
+
+	tampered := bytes.Clone(msg)
+	tampered[len(tampered)-1] ^= 1
+	_, err = Decrypt(priv, tampered)
+	assert.Error(t, err)
+
+	for _, bad := range [][]byte{nil, {0}, msg[:10], msg[:len(msg)-20]} {
+		_, err = Decrypt(priv, bad)
+		assert.Error(t, err)
+	}
+}
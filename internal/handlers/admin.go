@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lionslon/go-yapmetrics/internal/middlewares"
+	"github.com/lionslon/go-yapmetrics/internal/models"
+	"github.com/lionslon/go-yapmetrics/internal/problem"
+	"github.com/lionslon/go-yapmetrics/internal/storage"
+	"go.uber.org/zap"
+)
+
+// auditLog журнал административных действий: кто выполнил действие (идентификатор токена
+// или subject JWT) и с какого адреса. Адрес определяется echo.IPExtractor сервера
+func auditLog(ctx echo.Context) *zap.SugaredLogger {
+	principal, _ := ctx.Get(middlewares.ContextTokenID).(string)
+	return zap.S().Named("audit").With(
+		"principal", principal,
+		"remote", ctx.RealIP(),
+	)
+}
+
+// audit журнал действий с метриками
+func audit(ctx echo.Context, action string, metrics ...models.Metrics) {
+	log := auditLog(ctx)
+	for _, m := range metrics {
+		log.Infow(action,
+			"type", m.MType,
+			"name", m.ID,
+		)
+	}
+}
+
+// persist сразу сбрасывает изменения в активное хранилище, чтобы удаленные метрики
+// не вернулись после перезапуска
+func persist(sw storage.StorageWorker) error {
+	if sw == nil {
+		return nil
+	}
+	return sw.Dump()
+}
+
+// DeleteMetric удаляет одну метрику
+func (h *handler) DeleteMetric(sw storage.StorageWorker) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		typeM := ctx.Param("typeM")
+		nameM := ctx.Param("nameM")
+		if p := h.validator.Type(typeM); p != nil {
+			return problem.Send(ctx, p)
+		}
+		if !h.store.DeleteMetric(typeM, nameM) {
+			return problem.Send(ctx, errNotFound(typeM, nameM))
+		}
+		audit(ctx, "delete", models.Metrics{ID: nameM, MType: typeM})
+
+		if err := persist(sw); err != nil {
+			zap.S().Error(err)
+			return problem.Write(ctx, http.StatusInternalServerError, problem.CodeStorageUnavailable, "metric deleted but not persisted")
+		}
+		return ctx.JSON(http.StatusOK, map[string]string{"status": "deleted"})
+	}
+}
+
+// DeleteMetrics удаляет все метрики с префиксом имени prefix и, опционально, типом type
+func (h *handler) DeleteMetrics(sw storage.StorageWorker) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		prefix := ctx.QueryParam("prefix")
+		typeM := ctx.QueryParam("type")
+		if prefix == "" {
+			return problem.Write(ctx, http.StatusBadRequest, problem.CodeInvalidQuery, "prefix is required")
+		}
+		if typeM != "" {
+			if p := h.validator.Type(typeM); p != nil {
+				return problem.Send(ctx, p)
+			}
+		}
+
+		deleted := h.store.DeleteByPrefix(typeM, prefix)
+		audit(ctx, "delete", deleted...)
+
+		if len(deleted) > 0 {
+			if err := persist(sw); err != nil {
+				zap.S().Error(err)
+				return problem.Write(ctx, http.StatusInternalServerError, problem.CodeStorageUnavailable, "metrics deleted but not persisted")
+			}
+		}
+		if deleted == nil {
+			deleted = []models.Metrics{}
+		}
+		return ctx.JSON(http.StatusOK, map[string]any{"deleted": deleted})
+	}
+}
+
+// ResetCounter обнуляет counter-метрику
+func (h *handler) ResetCounter(sw storage.StorageWorker) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		nameM := ctx.Param("nameM")
+		if !h.store.ResetCounter(nameM) {
+			return problem.Send(ctx, errNotFound("counter", nameM))
+		}
+		audit(ctx, "reset", models.Metrics{ID: nameM, MType: "counter"})
+
+		if err := persist(sw); err != nil {
+			zap.S().Error(err)
+			return problem.Write(ctx, http.StatusInternalServerError, problem.CodeStorageUnavailable, "counter reset but not persisted")
+		}
+		return ctx.JSON(http.StatusOK, map[string]string{"status": "reset"})
+	}
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lionslon/go-yapmetrics/internal/problem"
+	"github.com/lionslon/go-yapmetrics/internal/storage"
+)
+
+// agentView агент с давностью последней метрики
+type agentView struct {
+	storage.Agent
+	Age string `json:"age"`
+}
+
+// Agents список агентов по давности последней метрики, параметр stale оставляет
+// только агентов, молчащих дольше указанной длительности
+func (h *handler) Agents() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		var stale time.Duration
+		if v := ctx.QueryParam("stale"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d < 0 {
+				return problem.Write(ctx, http.StatusBadRequest, problem.CodeInvalidQuery, "stale must be a non-negative duration like 5m")
+			}
+			stale = d
+		}
+		now := time.Now()
+		list := make([]agentView, 0)
+		for _, a := range h.store.Agents() {
+			age := now.Sub(a.LastSeen)
+			if age < stale {
+				continue
+			}
+			list = append(list, agentView{Agent: a, Age: age.Truncate(time.Second).String()})
+		}
+		return ctx.JSON(http.StatusOK, list)
+	}
+}
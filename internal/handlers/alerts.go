@@ -0,0 +1,33 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lionslon/go-yapmetrics/internal/alerting"
+	"github.com/lionslon/go-yapmetrics/internal/problem"
+)
+
+// alertLister источник текущих алертов
+type alertLister interface {
+	Alerts() []alerting.Alert
+}
+
+// Alerts список алертов, параметр state оставляет только алерты в этом состоянии
+func (h *handler) Alerts(alerts alertLister) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		state := ctx.QueryParam("state")
+		switch state {
+		case "", alerting.StatePending, alerting.StateFiring, alerting.StateResolved:
+		default:
+			return problem.Write(ctx, http.StatusBadRequest, problem.CodeInvalidQuery, "state must be pending, firing or resolved")
+		}
+		list := make([]alerting.Alert, 0)
+		for _, a := range alerts.Alerts() {
+			if state == "" || a.State == state {
+				list = append(list, a)
+			}
+		}
+		return ctx.JSON(http.StatusOK, list)
+	}
+}
@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"embed"
+	"html/template"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+//go:embed templates/*.html
+var templatesFS embed.FS
+
+var dashboardTmpl = template.Must(template.ParseFS(templatesFS, "templates/dashboard.html"))
+
+// defaultRefresh период автообновления страницы в секундах
+const defaultRefresh = 10
+
+// dashboardRow строка таблицы метрик
+type dashboardRow struct {
+	Name  string
+	Value string
+	num   float64
+}
+
+// dashboardTable таблица метрик одного типа
+type dashboardTable struct {
+	Title string
+	Rows  []dashboardRow
+}
+
+// dashboardColumn заголовок сортируемой колонки
+type dashboardColumn struct {
+	Title  string
+	Link   string
+	Active bool
+	Desc   bool
+}
+
+// dashboardPage данные для шаблона страницы
+type dashboardPage struct {
+	Tables      []dashboardTable
+	Columns     []dashboardColumn
+	Query       string
+	Sort        string
+	Order       string
+	Refresh     int
+	GeneratedAt string
+	LastUpdate  string
+}
+
+// dashboard формирует данные страницы по параметрам запроса q, sort, order и refresh
+func (h *handler) dashboard(ctx echo.Context) dashboardPage {
+	q := strings.TrimSpace(ctx.QueryParam("q"))
+	sortBy := ctx.QueryParam("sort")
+	if sortBy != "value" {
+		sortBy = "name"
+	}
+	order := ctx.QueryParam("order")
+	if order != "desc" {
+		order = "asc"
+	}
+	refresh := defaultRefresh
+	if r, err := strconv.Atoi(ctx.QueryParam("refresh")); err == nil && r >= 0 {
+		refresh = r
+	}
+
+	page := dashboardPage{
+		Query:       q,
+		Sort:        sortBy,
+		Order:       order,
+		Refresh:     refresh,
+		GeneratedAt: time.Now().Format(time.RFC3339),
+		LastUpdate:  "—",
+	}
+	if lu := h.store.LastUpdate(); !lu.IsZero() {
+		page.LastUpdate = lu.Format(time.RFC3339)
+	}
+
+	var gauges, counters []dashboardRow
+	for name, v := range h.store.Gauges() {
+		if matchQuery(name, q) {
+			gauges = append(gauges, dashboardRow{Name: name, Value: strconv.FormatFloat(v, 'f', -1, 64), num: v})
+		}
+	}
+	for name, v := range h.store.Counters() {
+		if matchQuery(name, q) {
+			counters = append(counters, dashboardRow{Name: name, Value: strconv.FormatInt(v, 10), num: float64(v)})
+		}
+	}
+	sortRows(gauges, sortBy, order == "desc")
+	sortRows(counters, sortBy, order == "desc")
+	page.Tables = []dashboardTable{{Title: "Gauges", Rows: gauges}, {Title: "Counters", Rows: counters}}
+
+	for _, col := range []struct{ key, title string }{{"name", "Name"}, {"value", "Value"}} {
+		next := "asc"
+		if col.key == sortBy && order == "asc" {
+			next = "desc"
+		}
+		params := url.Values{}
+		params.Set("sort", col.key)
+		params.Set("order", next)
+		params.Set("refresh", strconv.Itoa(refresh))
+		if q != "" {
+			params.Set("q", q)
+		}
+		page.Columns = append(page.Columns, dashboardColumn{
+			Title:  col.title,
+			Link:   "/?" + params.Encode(),
+			Active: col.key == sortBy,
+			Desc:   order == "desc",
+		})
+	}
+
+	return page
+}
+
+// matchQuery проверяет вхождение строки поиска в имя метрики без учета регистра
+func matchQuery(name, q string) bool {
+	return q == "" || strings.Contains(strings.ToLower(name), strings.ToLower(q))
+}
+
+// sortRows сортирует строки по имени или значению
+func sortRows(rows []dashboardRow, by string, desc bool) {
+	sort.SliceStable(rows, func(i, j int) bool {
+		a, b := rows[i], rows[j]
+		if desc {
+			a, b = b, a
+		}
+		if by == "value" && a.num != b.num {
+			return a.num < b.num
+		}
+		return a.Name < b.Name
+	})
+}
+
+// renderDashboard отдает HTML-страницу со всеми метриками
+func (h *handler) renderDashboard(ctx echo.Context) error {
+	var sb strings.Builder
+	if err := dashboardTmpl.Execute(&sb, h.dashboard(ctx)); err != nil {
+		return err
+	}
+	return ctx.HTML(http.StatusOK, sb.String())
+}
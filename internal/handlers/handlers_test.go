@@ -1,8 +1,845 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lionslon/go-yapmetrics/internal/acl"
+	"github.com/lionslon/go-yapmetrics/internal/alerting"
+	"github.com/lionslon/go-yapmetrics/internal/auth"
+	"github.com/lionslon/go-yapmetrics/internal/middlewares"
+	"github.com/lionslon/go-yapmetrics/internal/problem"
+	"github.com/lionslon/go-yapmetrics/internal/recording"
+	"github.com/lionslon/go-yapmetrics/internal/storage"
+	"github.com/lionslon/go-yapmetrics/internal/validation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
 )
 
 func TestWebhook(t *testing.T) {
 }
+
+func TestAllMetricsValuesDashboard(t *testing.T) {
+	st := storage.NewMemoryStorage()
+	st.UpdateGauge("Alloc", 3)
+	st.UpdateGauge("HeapAlloc", 1)
+	st.UpdateGauge("Sys", 2)
+	st.UpdateCounter("PollCount", 5)
+	h := New(st, validation.Default())
+	e := echo.New()
+
+	testCases := []struct {
+		name    string
+		query   string
+		order   []string
+		absent  []string
+		refresh bool
+	}{
+		{name: "sorted by name", query: "", order: []string{"Alloc", "HeapAlloc", "Sys", "PollCount"}, refresh: true},
+		{name: "sorted by value desc", query: "?sort=value&order=desc", order: []string{"Alloc", "Sys", "HeapAlloc"}, refresh: true},
+		{name: "search", query: "?q=alloc", order: []string{"Alloc", "HeapAlloc"}, absent: []string{"Sys", "PollCount"}, refresh: true},
+		{name: "refresh disabled", query: "?refresh=0", order: []string{"Alloc"}, refresh: false},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/"+test.query, nil)
+			rec := httptest.NewRecorder()
+			require.NoError(t, h.AllMetricsValues()(e.NewContext(req, rec)))
+
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.Contains(t, rec.Header().Get("Content-Type"), "text/html")
+			body := rec.Body.String()
+			pos := -1
+			for _, name := range test.order {
+				i := strings.Index(body, `<td class="name">`+name+`</td>`)
+				require.Greater(t, i, pos, name)
+				pos = i
+			}
+			for _, name := range test.absent {
+				assert.NotContains(t, body, `<td class="name">`+name+`</td>`)
+			}
+			assert.Equal(t, test.refresh, strings.Contains(body, `http-equiv="refresh"`))
+			if test.name == "sorted by value desc" {
+				// сортировка из адреса переживает автообновление страницы
+				assert.Contains(t, body, `<input type="hidden" name="sort" value="value">`)
+				assert.Contains(t, body, `<input type="hidden" name="order" value="desc">`)
+				assert.Contains(t, body, `<th class="active desc"><a href="/?order=asc&amp;refresh=10&amp;sort=value">Value</a></th>`)
+			}
+		})
+	}
+}
+
+func TestListValues(t *testing.T) {
+	st := storage.NewMemoryStorage()
+	for i := 0; i < 5; i++ {
+		st.UpdateGauge(fmt.Sprintf("CPUutilization%d", i+1), float64(i))
+	}
+	st.UpdateGauge("Alloc", 1)
+	st.UpdateCounter("Alloc", 2)
+	st.UpdateCounter("PollCount", 3)
+	st.UpdateLabels("counter", "PollCount", map[string]string{"host": "a"})
+	h := New(st, validation.Default())
+	e := echo.New()
+
+	list := func(t *testing.T, query string) (int, valuesPage) {
+		req := httptest.NewRequest(http.MethodGet, "/values?"+query, nil)
+		rec := httptest.NewRecorder()
+		require.NoError(t, h.ListValues()(e.NewContext(req, rec)))
+		var page valuesPage
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &page))
+		}
+		return rec.Code, page
+	}
+	ids := func(page valuesPage) []string {
+		res := make([]string, 0, len(page.Metrics))
+		for _, m := range page.Metrics {
+			res = append(res, m.MType+":"+m.ID)
+		}
+		return res
+	}
+
+	testCases := []struct {
+		name   string
+		query  string
+		status int
+		want   []string
+	}{
+		{name: "all", query: "", status: http.StatusOK, want: []string{"counter:Alloc", "gauge:Alloc", "gauge:CPUutilization1", "gauge:CPUutilization2", "gauge:CPUutilization3", "gauge:CPUutilization4", "gauge:CPUutilization5", "counter:PollCount"}},
+		{name: "by type", query: "type=counter", status: http.StatusOK, want: []string{"counter:Alloc", "counter:PollCount"}},
+		{name: "by prefix", query: "prefix=CPU&order=desc&limit=2", status: http.StatusOK, want: []string{"gauge:CPUutilization5", "gauge:CPUutilization4"}},
+		{name: "by regex", query: "regex=%5EC.*%5B13%5D%24", status: http.StatusOK, want: []string{"gauge:CPUutilization1", "gauge:CPUutilization3"}},
+		{name: "by label", query: "label=host%3Da", status: http.StatusOK, want: []string{"counter:PollCount"}},
+		{name: "by label key", query: "label=team", status: http.StatusOK, want: []string{}},
+		{name: "bad type", query: "type=histogram", status: http.StatusBadRequest},
+		{name: "bad regex", query: "regex=%28", status: http.StatusBadRequest},
+		{name: "bad limit", query: "limit=-1", status: http.StatusBadRequest},
+		{name: "bad cursor", query: "cursor=%21", status: http.StatusBadRequest},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			status, page := list(t, test.query)
+			require.Equal(t, test.status, status)
+			if status == http.StatusOK {
+				assert.Equal(t, test.want, ids(page))
+			}
+		})
+	}
+
+	t.Run("pagination", func(t *testing.T) {
+		var got []string
+		cursor := ""
+		for {
+			status, page := list(t, "limit=3&cursor="+cursor)
+			require.Equal(t, http.StatusOK, status)
+			got = append(got, ids(page)...)
+			if page.NextCursor == "" {
+				break
+			}
+			cursor = page.NextCursor
+		}
+		_, all := list(t, "")
+		assert.Equal(t, ids(all), got)
+	})
+}
+
+func TestGetValuesJSON(t *testing.T) {
+	st := storage.NewMemoryStorage()
+	st.UpdateGauge("Alloc", 1.5)
+	st.UpdateCounter("PollCount", 3)
+	st.UpdateGauge("Zero", 0)
+	h := New(st, validation.Default())
+	e := echo.New()
+
+	body := `[{"id":"PollCount","type":"counter"},{"id":"Missing","type":"gauge"},{"id":"Alloc","type":"gauge"},{"id":"Zero","type":"gauge"},{"id":"Alloc","type":"histogram"}]`
+	req := httptest.NewRequest(http.MethodPost, "/values/", strings.NewReader(body))
+	rec := httptest.NewRecorder()
+	require.NoError(t, h.GetValuesJSON()(e.NewContext(req, rec)))
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	var res []batchValue
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	require.Len(t, res, 5)
+
+	assert.True(t, res[0].Found)
+	assert.Equal(t, int64(3), *res[0].Delta)
+	assert.False(t, res[1].Found)
+	assert.Nil(t, res[1].Value)
+	assert.NotEmpty(t, res[1].Error)
+	assert.True(t, res[2].Found)
+	assert.Equal(t, 1.5, *res[2].Value)
+	assert.True(t, res[3].Found)
+	assert.Equal(t, 0.0, *res[3].Value)
+	assert.False(t, res[4].Found)
+	assert.NotEmpty(t, res[4].Error)
+
+	req = httptest.NewRequest(http.MethodPost, "/values/", strings.NewReader(`{`))
+	rec = httptest.NewRecorder()
+	require.NoError(t, h.GetValuesJSON()(e.NewContext(req, rec)))
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+}
+
+type fakeWorker struct {
+	dumps int
+}
+
+func (f *fakeWorker) Restore() error { return nil }
+func (f *fakeWorker) Dump() error    { f.dumps++; return nil }
+func (f *fakeWorker) IntervalDump()  {}
+func (f *fakeWorker) Check() error   { return nil }
+
+func TestDeleteAndReset(t *testing.T) {
+	st := storage.NewMemoryStorage()
+	st.UpdateGauge("Alloc", 1)
+	st.UpdateGauge("CPUutilization1", 1)
+	st.UpdateGauge("CPUutilization2", 1)
+	st.UpdateCounter("PollCount", 10)
+	h := New(st, validation.Default())
+	sw := &fakeWorker{}
+	e := echo.New()
+	e.DELETE("/value/:typeM/:nameM", h.DeleteMetric(sw))
+	e.DELETE("/values/", h.DeleteMetrics(sw))
+	e.POST("/reset/counter/:nameM", h.ResetCounter(sw))
+
+	testCases := []struct {
+		name   string
+		method string
+		target string
+		status int
+	}{
+		{name: "delete gauge", method: http.MethodDelete, target: "/value/gauge/Alloc", status: http.StatusOK},
+		{name: "delete missing", method: http.MethodDelete, target: "/value/gauge/Alloc", status: http.StatusNotFound},
+		{name: "delete bad type", method: http.MethodDelete, target: "/value/histogram/Alloc", status: http.StatusBadRequest},
+		{name: "delete by prefix", method: http.MethodDelete, target: "/values/?prefix=CPU", status: http.StatusOK},
+		{name: "delete without prefix", method: http.MethodDelete, target: "/values/", status: http.StatusBadRequest},
+		{name: "reset counter", method: http.MethodPost, target: "/reset/counter/PollCount", status: http.StatusOK},
+		{name: "reset missing", method: http.MethodPost, target: "/reset/counter/Missing", status: http.StatusNotFound},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(test.method, test.target, nil))
+			assert.Equal(t, test.status, rec.Code)
+		})
+	}
+
+	assert.Empty(t, st.Gauges())
+	assert.Equal(t, int64(0), st.GetCounterValue("PollCount"))
+	assert.Equal(t, 3, sw.dumps)
+}
+
+func TestAuditLog(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	defer zap.ReplaceGlobals(zap.New(core))()
+
+	st := storage.NewMemoryStorage()
+	st.UpdateCounter("PollCount", 10)
+	h := New(st, validation.Default())
+	e := echo.New()
+	e.IPExtractor = middlewares.IPExtractor(middlewares.SubnetConfig{})
+	e.POST("/reset/counter/:nameM", h.ResetCounter(nil), func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			ctx.Set(middlewares.ContextTokenID, "ops")
+			return next(ctx)
+		}
+	})
+
+	req := httptest.NewRequest(http.MethodPost, "/reset/counter/PollCount", nil)
+	req.Header.Set(echo.HeaderXRealIP, "10.0.0.7")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	require.Equal(t, http.StatusOK, rec.Code)
+
+	entries := logs.FilterMessage("reset").All()
+	require.Len(t, entries, 1)
+	fields := entries[0].ContextMap()
+	assert.Equal(t, "ops", fields["principal"])
+	assert.Equal(t, "192.0.2.1", fields["remote"], "X-Real-IP from an untrusted peer is ignored")
+	assert.Equal(t, "PollCount", fields["name"])
+	assert.Equal(t, "audit", entries[0].LoggerName)
+}
+
+type failingWorker struct {
+	fakeWorker
+}
+
+func (f *failingWorker) Dump() error  { return errors.New("disk is full") }
+func (f *failingWorker) Check() error { return errors.New("no connection") }
+
+func TestTokens(t *testing.T) {
+	tokens, err := auth.NewStore(filepath.Join(t.TempDir(), "tokens.json"), "root")
+	require.NoError(t, err)
+	h := New(storage.NewMemoryStorage(), validation.Default())
+	e := echo.New()
+	e.GET("/admin/tokens", h.ListTokens(tokens))
+	e.POST("/admin/tokens", h.CreateToken(tokens))
+	e.DELETE("/admin/tokens/:id", h.RevokeToken(tokens))
+
+	testCases := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		status int
+	}{
+		{name: "create", method: http.MethodPost, target: "/admin/tokens", body: `{"id":"agent-1","roles":["ingest"]}`, status: http.StatusCreated},
+		{name: "create duplicate", method: http.MethodPost, target: "/admin/tokens", body: `{"id":"agent-1","roles":["read"]}`, status: http.StatusConflict},
+		{name: "create unknown role", method: http.MethodPost, target: "/admin/tokens", body: `{"id":"agent-2","roles":["owner"]}`, status: http.StatusBadRequest},
+		{name: "create without roles", method: http.MethodPost, target: "/admin/tokens", body: `{"id":"agent-2"}`, status: http.StatusBadRequest},
+		{name: "create bootstrap", method: http.MethodPost, target: "/admin/tokens", body: `{"id":"bootstrap","roles":["read"]}`, status: http.StatusBadRequest},
+		{name: "list", method: http.MethodGet, target: "/admin/tokens", status: http.StatusOK},
+		{name: "revoke", method: http.MethodDelete, target: "/admin/tokens/agent-1", status: http.StatusOK},
+		{name: "revoke missing", method: http.MethodDelete, target: "/admin/tokens/agent-1", status: http.StatusNotFound},
+		{name: "revoke bootstrap", method: http.MethodDelete, target: "/admin/tokens/bootstrap", status: http.StatusNotFound},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(test.method, test.target, strings.NewReader(test.body)))
+			assert.Equal(t, test.status, rec.Code)
+
+			if test.name == "create" {
+				var got issuedToken
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+				tok, ok := tokens.Authenticate(got.Secret)
+				require.True(t, ok)
+				assert.Equal(t, []string{auth.RoleIngest}, tok.Roles)
+				assert.NotContains(t, rec.Body.String(), "hash")
+			}
+			if test.name == "list" {
+				assert.Contains(t, rec.Body.String(), `"id":"agent-1"`)
+				assert.NotContains(t, rec.Body.String(), "hash")
+			}
+		})
+	}
+}
+
+func TestTokensWithoutFile(t *testing.T) {
+	tokens, err := auth.NewStore("", "root")
+	require.NoError(t, err)
+	h := New(storage.NewMemoryStorage(), validation.Default())
+	e := echo.New()
+	e.POST("/admin/tokens", h.CreateToken(tokens))
+	e.DELETE("/admin/tokens/:id", h.RevokeToken(tokens))
+
+	for _, req := range []*http.Request{
+		httptest.NewRequest(http.MethodPost, "/admin/tokens", strings.NewReader(`{"id":"agent-1","roles":["ingest"]}`)),
+		httptest.NewRequest(http.MethodDelete, "/admin/tokens/agent-1", nil),
+	} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusConflict, rec.Code)
+		var p problem.Problem
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &p))
+		assert.Equal(t, problem.CodeConflict, p.Code)
+		assert.Contains(t, p.Detail, "-tokens-file")
+	}
+	assert.Len(t, tokens.List(), 1, "only the bootstrap token")
+}
+
+func TestWritePrefixes(t *testing.T) {
+	st := storage.NewMemoryStorage()
+	h := New(st, validation.Default())
+	e := echo.New()
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			ctx.Set(auth.ContextToken, &auth.Token{ID: "agent-1", Prefixes: []string{"host1."}})
+			return next(ctx)
+		}
+	})
+	e.POST("/update/", h.UpdateJSON())
+	e.POST("/update/:typeM/:nameM/:valueM", h.UpdateMetrics())
+	e.POST("/updates/", h.UpdatesJSON())
+
+	testCases := []struct {
+		name   string
+		target string
+		body   string
+		status int
+	}{
+		{name: "json allowed", target: "/update/", body: `{"id":"host1.cpu","type":"gauge","value":1}`, status: http.StatusOK},
+		{name: "json denied", target: "/update/", body: `{"id":"host2.cpu","type":"gauge","value":1}`, status: http.StatusForbidden},
+		{name: "url allowed", target: "/update/counter/host1.hits/1", status: http.StatusOK},
+		{name: "url denied", target: "/update/counter/hits/1", status: http.StatusForbidden},
+		{name: "batch partial", target: "/updates/", body: `[{"id":"host1.mem","type":"gauge","value":1},{"id":"mem","type":"gauge","value":1}]`, status: http.StatusOK},
+		{name: "batch denied", target: "/updates/", body: `[{"id":"mem","type":"gauge","value":1}]`, status: http.StatusForbidden},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, test.target, strings.NewReader(test.body)))
+			assert.Equal(t, test.status, rec.Code)
+		})
+	}
+	assert.Equal(t, map[string]float64{"host1.cpu": 1, "host1.mem": 1}, st.Gauges())
+}
+
+func TestWriteACL(t *testing.T) {
+	rules, err := acl.New([]acl.Rule{
+		{Identity: "key:team-a", Prefixes: []string{"teamA."}},
+		{Identity: "key:team-b", Labels: map[string][]string{"team": {"b"}}},
+	})
+	require.NoError(t, err)
+	st := storage.NewMemoryStorage()
+	h := New(st, validation.Default())
+	e := echo.New()
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			ctx.Set(middlewares.ContextKeyID, ctx.Request().Header.Get(middlewares.HeaderKeyID))
+			return next(ctx)
+		}
+	})
+	e.POST("/updates/", h.UpdatesJSON(), middlewares.WriteACL(rules))
+
+	body := `[
+		{"id":"teamA.HeapAlloc","type":"gauge","value":1},
+		{"id":"HeapAlloc","type":"gauge","value":2,"labels":{"team":"b"}},
+		{"id":"HeapAlloc","type":"counter","delta":3}
+	]`
+	testCases := []struct {
+		name   string
+		keyID  string
+		status int
+		denied []int
+	}{
+		{name: "team a", keyID: "team-a", status: http.StatusOK, denied: []int{1, 2}},
+		{name: "team b", keyID: "team-b", status: http.StatusOK, denied: []int{0, 2}},
+		{name: "default key", status: http.StatusForbidden},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/updates/", strings.NewReader(body))
+			req.Header.Set(middlewares.HeaderKeyID, test.keyID)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			require.Equal(t, test.status, rec.Code)
+			if test.status != http.StatusOK {
+				return
+			}
+
+			var got struct {
+				Status string
+				Denied []deniedMetric
+			}
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+			assert.Equal(t, "partial", got.Status)
+			var idx []int
+			for _, d := range got.Denied {
+				idx = append(idx, d.Index)
+				assert.Contains(t, d.Detail, "key:"+test.keyID)
+			}
+			assert.Equal(t, test.denied, idx)
+		})
+	}
+	assert.Equal(t, map[string]float64{"teamA.HeapAlloc": 1, "HeapAlloc": 2}, st.Gauges())
+	assert.Empty(t, st.Counters())
+}
+
+func TestWriteACLCrossTeam(t *testing.T) {
+	rules, err := acl.New([]acl.Rule{
+		{Identity: "key:team-a", Labels: map[string][]string{"team": {"a"}}},
+		{Identity: "key:team-b", Labels: map[string][]string{"team": {"b"}}},
+	})
+	require.NoError(t, err)
+	st := storage.NewMemoryStorage()
+	st.UpdateGauge("HeapAlloc", 1)
+	st.UpdateLabels("gauge", "HeapAlloc", map[string]string{"team": "a"})
+	h := New(st, validation.Default())
+	e := echo.New()
+	e.Use(func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			ctx.Set(middlewares.ContextKeyID, ctx.Request().Header.Get(middlewares.HeaderKeyID))
+			return next(ctx)
+		}
+	})
+	e.POST("/update/", h.UpdateJSON(), middlewares.WriteACL(rules))
+	e.POST("/update/:typeM/:nameM/:valueM", h.UpdateMetrics(), middlewares.WriteACL(rules))
+
+	testCases := []struct {
+		name   string
+		keyID  string
+		target string
+		body   string
+		status int
+	}{
+		{name: "other team relabels", keyID: "team-b", target: "/update/", body: `{"id":"HeapAlloc","type":"gauge","value":2,"labels":{"team":"b"}}`, status: http.StatusForbidden},
+		{name: "other team without labels", keyID: "team-b", target: "/update/", body: `{"id":"HeapAlloc","type":"gauge","value":2}`, status: http.StatusForbidden},
+		{name: "other team by URL", keyID: "team-b", target: "/update/gauge/HeapAlloc/2", status: http.StatusForbidden},
+		{name: "owner moves metric", keyID: "team-a", target: "/update/", body: `{"id":"HeapAlloc","type":"gauge","value":2,"labels":{"team":"b"}}`, status: http.StatusForbidden},
+		{name: "owner", keyID: "team-a", target: "/update/", body: `{"id":"HeapAlloc","type":"gauge","value":3}`, status: http.StatusOK},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, test.target, strings.NewReader(test.body))
+			req.Header.Set(middlewares.HeaderKeyID, test.keyID)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			assert.Equal(t, test.status, rec.Code)
+		})
+	}
+	assert.Equal(t, map[string]float64{"HeapAlloc": 3}, st.Gauges())
+	assert.Equal(t, map[string]string{"team": "a"}, st.Labels("gauge", "HeapAlloc"))
+}
+
+func TestAlerts(t *testing.T) {
+	st := storage.NewMemoryStorage()
+	st.UpdateGauge("HeapAlloc", 200)
+	engine := alerting.New([]alerting.Rule{
+		{Name: "HighHeap", Metric: "HeapAlloc", Type: "gauge", Op: ">", Threshold: 100},
+		{Name: "SlowHeap", Metric: "HeapAlloc", Type: "gauge", Op: ">", Threshold: 100, For: alerting.Duration(time.Hour)},
+	}, st, nil)
+	engine.Eval(time.Now())
+	h := New(st, validation.Default())
+	e := echo.New()
+	e.GET("/alerts", h.Alerts(engine))
+
+	testCases := []struct {
+		name   string
+		target string
+		status int
+		rules  []string
+	}{
+		{name: "all", target: "/alerts", status: http.StatusOK, rules: []string{"HighHeap", "SlowHeap"}},
+		{name: "firing", target: "/alerts?state=firing", status: http.StatusOK, rules: []string{"HighHeap"}},
+		{name: "resolved", target: "/alerts?state=resolved", status: http.StatusOK, rules: []string{}},
+		{name: "bad state", target: "/alerts?state=silenced", status: http.StatusBadRequest},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.target, nil))
+			require.Equal(t, test.status, rec.Code)
+			if test.status != http.StatusOK {
+				return
+			}
+			var got []alerting.Alert
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+			rules := []string{}
+			for _, a := range got {
+				rules = append(rules, a.Rule)
+			}
+			assert.Equal(t, test.rules, rules)
+		})
+	}
+}
+
+func TestSilences(t *testing.T) {
+	silences := alerting.NewSilences(nil)
+	h := New(storage.NewMemoryStorage(), validation.Default())
+	e := echo.New()
+	e.GET("/silences", h.ListSilences(silences))
+	e.POST("/silences", h.CreateSilence(silences), func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			ctx.Set(middlewares.ContextTokenID, "ops")
+			return next(ctx)
+		}
+	})
+	e.DELETE("/silences/:id", h.ExpireSilence(silences))
+	do := func(method, target, body string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	for _, body := range []string{
+		`{"matchers":[],"duration":"1h"}`,
+		`{"matchers":[{"name":"host","value":"(","regex":true}],"duration":"1h"}`,
+		`{"matchers":[{"name":"host","value":"a"}]}`,
+	} {
+		assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/silences", body).Code, body)
+	}
+
+	rec := do(http.MethodPost, "/silences", `{"matchers":[{"name":"host","value":"a"}],"duration":"1h","comment":"maintenance"}`)
+	require.Equal(t, http.StatusCreated, rec.Code)
+	var created alerting.Silence
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	assert.Equal(t, "ops", created.Author, "author defaults to the client identity")
+	assert.WithinDuration(t, time.Now().Add(time.Hour), created.EndsAt, time.Minute)
+
+	rec = do(http.MethodGet, "/silences", "")
+	require.Equal(t, http.StatusOK, rec.Code)
+	var list []alerting.Silence
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Len(t, list, 1)
+	assert.Equal(t, created.ID, list[0].ID)
+
+	assert.Equal(t, http.StatusOK, do(http.MethodDelete, "/silences/"+created.ID, "").Code)
+	assert.Equal(t, http.StatusNotFound, do(http.MethodDelete, "/silences/"+created.ID, "").Code)
+	assert.Empty(t, silences.List(time.Now()))
+}
+
+func TestAgents(t *testing.T) {
+	st := storage.NewMemoryStorage()
+	h := New(st, validation.Default())
+	e := echo.New()
+	e.IPExtractor = middlewares.IPExtractor(middlewares.SubnetConfig{})
+	e.POST("/update/", h.UpdateJSON(), func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			if id := ctx.Request().Header.Get("X-Test-Agent"); id != "" {
+				ctx.Set(middlewares.ContextIdentity, id)
+			}
+			return next(ctx)
+		}
+	})
+	e.GET("/agents", h.Agents())
+	update := func(agent string, body string) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodPost, "/update/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Test-Agent", agent)
+		req.Header.Set("X-Real-IP", "10.0.0.7")
+		e.ServeHTTP(rec, req)
+		require.Equal(t, http.StatusOK, rec.Code)
+	}
+	update("agent-1", `{"id":"PollCount","type":"counter","delta":1}`)
+	update("agent-1", `{"id":"Alloc","type":"gauge","value":1}`)
+	update("", `{"id":"Frees","type":"gauge","value":1}`)
+
+	get := func(target string) (int, []map[string]any) {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		var got []map[string]any
+		if rec.Code == http.StatusOK {
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+		}
+		return rec.Code, got
+	}
+	code, got := get("/agents")
+	require.Equal(t, http.StatusOK, code)
+	ids := map[string]float64{}
+	for _, a := range got {
+		ids[a["id"].(string)] = a["metrics"].(float64)
+	}
+	assert.Equal(t, map[string]float64{"agent-1": 2, "192.0.2.1": 1}, ids, "without identity the agent is its peer address, X-Real-IP is not trusted")
+
+	code, got = get("/agents?stale=1h")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Empty(t, got)
+	code, _ = get("/agents?stale=soon")
+	assert.Equal(t, http.StatusBadRequest, code)
+}
+
+func TestCounterRate(t *testing.T) {
+	st := storage.NewMemoryStorage()
+	st.UpdateCounter("PollCount", 5)
+	st.UpdateCounter("Single", 1)
+	st.ResetCounter("PollCount")
+	st.UpdateCounter("PollCount", 3)
+	h := New(st, validation.Default())
+	e := echo.New()
+	e.GET("/rate/:nameM", h.CounterRate())
+
+	testCases := []struct {
+		name     string
+		target   string
+		status   int
+		increase float64
+		resets   int
+	}{
+		{name: "increase across reset", target: "/rate/PollCount?window=1m", status: http.StatusOK, increase: 3, resets: 1},
+		{name: "default window", target: "/rate/PollCount", status: http.StatusOK, increase: 3, resets: 1},
+		{name: "bad window", target: "/rate/PollCount?window=-1m", status: http.StatusBadRequest},
+		{name: "unknown counter", target: "/rate/Missing", status: http.StatusNotFound},
+		{name: "single sample", target: "/rate/Single", status: http.StatusUnprocessableEntity},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, test.target, nil))
+			require.Equal(t, test.status, rec.Code, rec.Body.String())
+			if test.status != http.StatusOK {
+				return
+			}
+			var got rateView
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+			assert.Equal(t, test.increase, got.Increase)
+			assert.Equal(t, test.resets, got.Resets)
+			assert.Equal(t, 3, got.Samples)
+		})
+	}
+}
+
+func TestQuery(t *testing.T) {
+	st := storage.NewMemoryStorage()
+	st.UpdateGauge("HeapAlloc", 100)
+	st.UpdateLabels("gauge", "HeapAlloc", map[string]string{"host": "a"})
+	st.UpdateGauge("HeapSys", 400)
+	st.UpdateLabels("gauge", "HeapSys", map[string]string{"host": "a"})
+	h := New(st, validation.Default())
+	e := echo.New()
+	e.GET("/query", h.Query())
+
+	testCases := []struct {
+		name   string
+		expr   string
+		status int
+		body   string
+	}{
+		{name: "ratio", expr: "HeapAlloc / HeapSys * 100", status: http.StatusOK,
+			body: `{"type":"vector","series":[{"labels":{"host":"a"},"value":25}]}`},
+		{name: "scalar", expr: "1 + 1", status: http.StatusOK, body: `{"type":"scalar","value":2}`},
+		{name: "syntax error", expr: "sum(HeapAlloc", status: http.StatusBadRequest},
+		{name: "empty", expr: "", status: http.StatusBadRequest},
+		{name: "evaluation error", expr: "1 / 0", status: http.StatusUnprocessableEntity},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/query?expr="+url.QueryEscape(test.expr), nil))
+			require.Equal(t, test.status, rec.Code, rec.Body.String())
+			if test.body != "" {
+				assert.JSONEq(t, test.body, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestRecordingRules(t *testing.T) {
+	st := storage.NewMemoryStorage()
+	st.UpdateGauge("FreeMemory", 100)
+	path := filepath.Join(t.TempDir(), "recording.json")
+	require.NoError(t, os.WriteFile(path, []byte(`[{"name":"recorded:MemFree","expr":"FreeMemory"},{"name":"recorded:Bad","expr":"Missing"}]`), 0o644))
+	r, err := recording.New(path, st, validation.Default())
+	require.NoError(t, err)
+	r.Eval(time.Now())
+
+	h := New(st, validation.Default())
+	e := echo.New()
+	e.GET("/recording-rules", h.RecordingRules(r))
+	e.GET("/recording-rules/none", h.RecordingRules(nil))
+	e.POST("/update/", h.UpdateJSON())
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/recording-rules", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	var status recording.Status
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &status))
+	require.Len(t, status.Rules, 2)
+	assert.Equal(t, 100.0, *status.Rules[0].Value)
+	assert.Contains(t, status.Rules[1].Error, "0 series")
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/recording-rules/none", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"file":"","loaded_at":"0001-01-01T00:00:00Z","rules":[]}`, rec.Body.String())
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/update/", strings.NewReader(`{"id":"recorded:MemFree","type":"gauge","value":1}`)))
+	require.Equal(t, http.StatusForbidden, rec.Code, "agents may not write into the recording rules namespace")
+	v, _ := st.LookupGauge("recorded:MemFree")
+	assert.Equal(t, 100.0, v)
+}
+
+func TestErrorResponses(t *testing.T) {
+	newRouter := func(sw storage.StorageWorker) *echo.Echo {
+		st := storage.NewMemoryStorage()
+		st.UpdateGauge("Alloc", 1)
+		st.UpdateCounter("PollCount", 1)
+		v, err := validation.New(validation.Rules{MaxBatchItems: 2})
+		require.NoError(t, err)
+		h := New(st, v)
+		e := echo.New()
+		e.HTTPErrorHandler = problem.HTTPErrorHandler
+		e.Use(middlewares.LimitBody(256))
+		e.POST("/update/:typeM/:nameM/:valueM", h.UpdateMetrics())
+		e.POST("/update/", h.UpdateJSON())
+		e.POST("/updates/", h.UpdatesJSON())
+		e.GET("/value/:typeM/:nameM", h.MetricsValue())
+		e.POST("/value/", h.GetValueJSON())
+		e.GET("/values", h.ListValues())
+		e.POST("/values/", h.GetValuesJSON())
+		e.GET("/ping", h.PingDB(sw))
+		e.DELETE("/value/:typeM/:nameM", h.DeleteMetric(sw))
+		e.DELETE("/values/", h.DeleteMetrics(sw))
+		e.POST("/reset/counter/:nameM", h.ResetCounter(sw))
+		return e
+	}
+
+	testCases := []struct {
+		name   string
+		method string
+		target string
+		body   string
+		sw     storage.StorageWorker
+		status int
+		code   string
+	}{
+		{name: "update: invalid type", method: http.MethodPost, target: "/update/histogram/x/1", status: http.StatusBadRequest, code: problem.CodeInvalidMetricType},
+		{name: "update: invalid counter", method: http.MethodPost, target: "/update/counter/x/1.5", status: http.StatusBadRequest, code: problem.CodeInvalidValue},
+		{name: "update: invalid gauge", method: http.MethodPost, target: "/update/gauge/x/abc", status: http.StatusBadRequest, code: problem.CodeInvalidValue},
+		{name: "update json: invalid json", method: http.MethodPost, target: "/update/", body: `{`, status: http.StatusBadRequest, code: problem.CodeInvalidJSON},
+		{name: "update json: empty body", method: http.MethodPost, target: "/update/", body: ``, status: http.StatusBadRequest, code: problem.CodeInvalidJSON},
+		{name: "update json: invalid type", method: http.MethodPost, target: "/update/", body: `{"id":"x","type":"histogram","value":1}`, status: http.StatusBadRequest, code: problem.CodeInvalidMetricType},
+		{name: "update json: missing id", method: http.MethodPost, target: "/update/", body: `{"type":"gauge","value":1}`, status: http.StatusBadRequest, code: problem.CodeInvalidMetricID},
+		{name: "update json: counter without delta", method: http.MethodPost, target: "/update/", body: `{"id":"x","type":"counter"}`, status: http.StatusBadRequest, code: problem.CodeMissingDelta},
+		{name: "update json: counter with value", method: http.MethodPost, target: "/update/", body: `{"id":"x","type":"counter","delta":1,"value":1}`, status: http.StatusBadRequest, code: problem.CodeUnexpectedField},
+		{name: "update json: gauge without value", method: http.MethodPost, target: "/update/", body: `{"id":"x","type":"gauge"}`, status: http.StatusBadRequest, code: problem.CodeMissingValue},
+		{name: "update json: gauge with delta", method: http.MethodPost, target: "/update/", body: `{"id":"x","type":"gauge","delta":1,"value":1}`, status: http.StatusBadRequest, code: problem.CodeUnexpectedField},
+		{name: "update: empty name", method: http.MethodPost, target: "/update/gauge//1", status: http.StatusBadRequest, code: problem.CodeInvalidMetricID},
+		{name: "update: invalid name", method: http.MethodPost, target: "/update/gauge/1abc/1", status: http.StatusBadRequest, code: problem.CodeInvalidMetricID},
+		{name: "update: name too long", method: http.MethodPost, target: "/update/gauge/" + strings.Repeat("a", storage.MaxNameLength+1) + "/1", status: http.StatusBadRequest, code: problem.CodeInvalidMetricID},
+		{name: "update: NaN gauge", method: http.MethodPost, target: "/update/gauge/x/NaN", status: http.StatusBadRequest, code: problem.CodeInvalidValue},
+		{name: "update: Inf gauge", method: http.MethodPost, target: "/update/gauge/x/-Inf", status: http.StatusBadRequest, code: problem.CodeInvalidValue},
+		{name: "update json: body too large", method: http.MethodPost, target: "/update/", body: `{"id":"` + strings.Repeat("a", 300) + `","type":"gauge","value":1}`, status: http.StatusRequestEntityTooLarge, code: problem.CodeBodyTooLarge},
+		{name: "updates: batch too large", method: http.MethodPost, target: "/updates/", body: `[{"id":"a","type":"gauge","value":1},{"id":"b","type":"gauge","value":1},{"id":"c","type":"gauge","value":1}]`, status: http.StatusRequestEntityTooLarge, code: problem.CodeBatchTooLarge},
+		{name: "updates: invalid json", method: http.MethodPost, target: "/updates/", body: `[{]`, status: http.StatusBadRequest, code: problem.CodeInvalidJSON},
+		{name: "updates: counter without delta", method: http.MethodPost, target: "/updates/", body: `[{"id":"x","type":"gauge","value":1},{"id":"y","type":"counter"}]`, status: http.StatusBadRequest, code: problem.CodeMissingDelta},
+		{name: "updates: invalid type", method: http.MethodPost, target: "/updates/", body: `[{"id":"x","type":"summary","value":1}]`, status: http.StatusBadRequest, code: problem.CodeInvalidMetricType},
+		{name: "value: invalid type", method: http.MethodGet, target: "/value/histogram/Alloc", status: http.StatusBadRequest, code: problem.CodeInvalidMetricType},
+		{name: "value: not found", method: http.MethodGet, target: "/value/gauge/Missing", status: http.StatusNotFound, code: problem.CodeMetricNotFound},
+		{name: "value: wrong type for existing name", method: http.MethodGet, target: "/value/counter/Alloc", status: http.StatusNotFound, code: problem.CodeMetricNotFound},
+		{name: "value json: invalid json", method: http.MethodPost, target: "/value/", body: `id`, status: http.StatusBadRequest, code: problem.CodeInvalidJSON},
+		{name: "value json: missing id", method: http.MethodPost, target: "/value/", body: `{"type":"gauge"}`, status: http.StatusBadRequest, code: problem.CodeInvalidMetricID},
+		{name: "value json: invalid type", method: http.MethodPost, target: "/value/", body: `{"id":"Alloc","type":"histogram"}`, status: http.StatusBadRequest, code: problem.CodeInvalidMetricType},
+		{name: "value json: gauge not found", method: http.MethodPost, target: "/value/", body: `{"id":"Missing","type":"gauge"}`, status: http.StatusNotFound, code: problem.CodeMetricNotFound},
+		{name: "value json: counter not found", method: http.MethodPost, target: "/value/", body: `{"id":"Missing","type":"counter"}`, status: http.StatusNotFound, code: problem.CodeMetricNotFound},
+		{name: "values: invalid type", method: http.MethodGet, target: "/values?type=histogram", status: http.StatusBadRequest, code: problem.CodeInvalidQuery},
+		{name: "values: invalid order", method: http.MethodGet, target: "/values?order=random", status: http.StatusBadRequest, code: problem.CodeInvalidQuery},
+		{name: "values: invalid label", method: http.MethodGet, target: "/values?label=%3Dx", status: http.StatusBadRequest, code: problem.CodeInvalidQuery},
+		{name: "batch read: too many items", method: http.MethodPost, target: "/values/", body: `[{"id":"a","type":"gauge"},{"id":"b","type":"gauge"},{"id":"c","type":"gauge"}]`, status: http.StatusRequestEntityTooLarge, code: problem.CodeBatchTooLarge},
+		{name: "batch read: invalid json", method: http.MethodPost, target: "/values/", body: `{}`, status: http.StatusBadRequest, code: problem.CodeInvalidJSON},
+		{name: "ping: no storage", method: http.MethodGet, target: "/ping", status: http.StatusInternalServerError, code: problem.CodeStorageUnavailable},
+		{name: "ping: storage down", method: http.MethodGet, target: "/ping", sw: &failingWorker{}, status: http.StatusInternalServerError, code: problem.CodeStorageUnavailable},
+		{name: "delete: invalid type", method: http.MethodDelete, target: "/value/histogram/Alloc", status: http.StatusBadRequest, code: problem.CodeInvalidMetricType},
+		{name: "delete: not found", method: http.MethodDelete, target: "/value/gauge/Missing", status: http.StatusNotFound, code: problem.CodeMetricNotFound},
+		{name: "delete: not persisted", method: http.MethodDelete, target: "/value/gauge/Alloc", sw: &failingWorker{}, status: http.StatusInternalServerError, code: problem.CodeStorageUnavailable},
+		{name: "delete by prefix: no prefix", method: http.MethodDelete, target: "/values/", status: http.StatusBadRequest, code: problem.CodeInvalidQuery},
+		{name: "delete by prefix: invalid type", method: http.MethodDelete, target: "/values/?prefix=A&type=x", status: http.StatusBadRequest, code: problem.CodeInvalidMetricType},
+		{name: "reset: not found", method: http.MethodPost, target: "/reset/counter/Missing", status: http.StatusNotFound, code: problem.CodeMetricNotFound},
+		{name: "reset: not persisted", method: http.MethodPost, target: "/reset/counter/PollCount", sw: &failingWorker{}, status: http.StatusInternalServerError, code: problem.CodeStorageUnavailable},
+		{name: "router: unknown route", method: http.MethodGet, target: "/unknown", status: http.StatusNotFound, code: problem.CodeNotFound},
+		{name: "router: method not allowed", method: http.MethodPut, target: "/update/", status: http.StatusMethodNotAllowed, code: problem.CodeMethodNotAllowed},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			e := newRouter(test.sw)
+			req := httptest.NewRequest(test.method, test.target, strings.NewReader(test.body))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			require.Equal(t, test.status, rec.Code, rec.Body.String())
+			assert.Equal(t, problem.ContentType, rec.Header().Get(echo.HeaderContentType))
+			var p problem.Problem
+			require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &p))
+			assert.Equal(t, test.code, p.Code)
+			assert.Equal(t, test.status, p.Status)
+			assert.NotEmpty(t, p.Type)
+			assert.NotEmpty(t, p.Title)
+		})
+	}
+}
@@ -5,13 +5,20 @@ import (
 	"errors"
 	"fmt"
 	"github.com/labstack/echo/v4"
+	"github.com/lionslon/go-yapmetrics/internal/acl"
+	"github.com/lionslon/go-yapmetrics/internal/auth"
+	"github.com/lionslon/go-yapmetrics/internal/middlewares"
 	"github.com/lionslon/go-yapmetrics/internal/models"
+	"github.com/lionslon/go-yapmetrics/internal/problem"
+	"github.com/lionslon/go-yapmetrics/internal/recording"
 	"github.com/lionslon/go-yapmetrics/internal/storage"
+	"github.com/lionslon/go-yapmetrics/internal/validation"
 	"go.uber.org/zap"
 	"io"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 )
 
 type storageUpdater interface {
@@ -19,45 +26,114 @@ type storageUpdater interface {
 	UpdateGauge(string, float64)
 	GetValue(string, string) (string, int)
 	AllMetrics() string
+	Gauges() map[string]float64
+	Counters() map[string]int64
+	LastUpdate() time.Time
+	UpdateLabels(string, string, map[string]string)
+	Labels(string, string) map[string]string
+	List() []models.Metrics
+	Page(*storage.Cursor, bool, int, func(models.Metrics) bool) ([]models.Metrics, bool)
+	DeleteMetric(string, string) bool
+	DeleteByPrefix(string, string) []models.Metrics
+	ResetCounter(string) bool
 	GetCounterValue(string) int64
 	GetGaugeValue(string) float64
+	LookupCounter(string) (int64, bool)
+	LookupGauge(string) (float64, bool)
 	StoreBatch([]models.Metrics)
+	UpdateAgent(string, string, string)
+	Agents() []storage.Agent
+	CounterRate(string, time.Duration, time.Time) (storage.Rate, bool)
 }
 
 type handler struct {
-	store storageUpdater
+	store     storageUpdater
+	validator *validation.Validator
 }
 
-func New(stor *storage.MemStorage) *handler {
+func New(stor *storage.MemStorage, v *validation.Validator) *handler {
 	return &handler{
-		store: stor,
+		store:     stor,
+		validator: v,
 	}
 }
 
+// errNotFound ошибка отсутствующей метрики
+func errNotFound(t string, id string) *problem.Problem {
+	return problem.New(http.StatusNotFound, problem.CodeMetricNotFound, fmt.Sprintf("%s metric %q not found", t, id))
+}
+
+// checkWrite проверяет, что клиент может записывать метрику: имя не из пространства правил записи,
+// префиксы токена и правила ACL, которые сверяются и с метками уже сохраненной метрики
+func (h *handler) checkWrite(ctx echo.Context, m models.Metrics) *problem.Problem {
+	if strings.HasPrefix(m.ID, recording.NamePrefix) {
+		return problem.New(http.StatusForbidden, problem.CodeForbidden, fmt.Sprintf("metric names starting with %q are reserved for recording rules", recording.NamePrefix))
+	}
+	if t, ok := ctx.Get(auth.ContextToken).(*auth.Token); ok && !t.Allows(m.ID) {
+		return problem.New(http.StatusForbidden, problem.CodeForbidden, fmt.Sprintf("token %q may not write metric %q", t.ID, m.ID))
+	}
+	if s, ok := ctx.Get(acl.ContextSubject).(acl.Subject); ok {
+		if err := s.Check(m, h.store.Labels(m.MType, m.ID)); err != nil {
+			return problem.New(http.StatusForbidden, problem.CodeForbidden, err.Error())
+		}
+	}
+	return nil
+}
+
+// agentID идентификатор агента для учета последних обновлений:
+// клиентский сертификат или токен, без аутентификации адрес клиента.
+// Сервер задает echo.IPExtractor, поэтому X-Real-IP принимается только от доверенных прокси
+func agentID(ctx echo.Context) string {
+	if id := middlewares.Identity(ctx); id != "" {
+		return id
+	}
+	return ctx.RealIP()
+}
+
+// decodeJSON декодирует тело запроса, пустое тело допускается только при allowEmpty
+func decodeJSON(ctx echo.Context, v any, allowEmpty bool) *problem.Problem {
+	err := json.NewDecoder(ctx.Request().Body).Decode(v)
+	if err == nil || (allowEmpty && errors.Is(err, io.EOF)) {
+		return nil
+	}
+	if p := problem.FromBodyError(err); p != nil {
+		return p
+	}
+	return problem.New(http.StatusBadRequest, problem.CodeInvalidJSON, fmt.Sprintf("error in JSON decode: %s", err))
+}
+
 func (h *handler) UpdateMetrics() echo.HandlerFunc {
 	return func(ctx echo.Context) error {
 		metricsType := ctx.Param("typeM")
 		metricsName := ctx.Param("nameM")
 		metricsValue := ctx.Param("valueM")
 
-		zap.S().Infof("Request Headers: %v", ctx.Request().Header)
-
+		if p := h.validator.Name(metricsName); p != nil {
+			return problem.Send(ctx, p)
+		}
+		if p := h.checkWrite(ctx, models.Metrics{ID: metricsName, MType: metricsType}); p != nil {
+			return problem.Send(ctx, p)
+		}
 		switch metricsType {
 		case "counter":
 			value, err := strconv.ParseInt(metricsValue, 10, 64)
 			if err != nil {
-				return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("%s cannot be converted to an integer", metricsValue)})
+				return problem.Write(ctx, http.StatusBadRequest, problem.CodeInvalidValue, fmt.Sprintf("%s cannot be converted to an integer", metricsValue))
 			}
 			h.store.UpdateCounter(metricsName, value)
 		case "gauge":
 			value, err := strconv.ParseFloat(metricsValue, 64)
 			if err != nil {
-				return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("%s cannot be converted to a float", metricsValue)})
+				return problem.Write(ctx, http.StatusBadRequest, problem.CodeInvalidValue, fmt.Sprintf("%s cannot be converted to a float", metricsValue))
+			}
+			if p := h.validator.Gauge(metricsName, value); p != nil {
+				return problem.Send(ctx, p)
 			}
 			h.store.UpdateGauge(metricsName, value)
 		default:
-			return ctx.JSON(http.StatusNotImplemented, map[string]string{"error": "Invalid metric type. Can only be 'gauge' or 'counter'"})
+			return problem.Send(ctx, h.validator.Type(metricsType))
 		}
+		h.store.UpdateAgent(metricsType, metricsName, agentID(ctx))
 
 		acceptHeader := ctx.Request().Header.Get("Accept")
 		zap.S().Infof("Accept Header: %s", acceptHeader)
@@ -75,11 +151,12 @@ func (h *handler) MetricsValue() echo.HandlerFunc {
 		typeM := ctx.Param("typeM")
 		nameM := ctx.Param("nameM")
 
-		zap.S().Infof("Request Headers: %v", ctx.Request().Header)
-
+		if p := h.validator.Type(typeM); p != nil {
+			return problem.Send(ctx, p)
+		}
 		val, status := h.store.GetValue(typeM, nameM)
 		if status != http.StatusOK {
-			return ctx.JSON(status, map[string]string{"error": "Metric not found"})
+			return problem.Send(ctx, errNotFound(typeM, nameM))
 		}
 
 		acceptHeader := ctx.Request().Header.Get("Accept")
@@ -99,20 +176,17 @@ func (h *handler) AllMetricsValues() echo.HandlerFunc {
 		acceptHeader := ctx.Request().Header.Get("Accept")
 		if strings.Contains(acceptHeader, "application/json") {
 			metrics := make(map[string]string)
-			for _, line := range strings.Split(h.store.AllMetrics(), "\n") {
-				if line == "" {
-					continue
-				}
-				parts := strings.Split(line, " = ")
-				if len(parts) == 2 {
-					metrics[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+			for _, m := range h.store.List() {
+				if m.Delta != nil {
+					metrics[m.ID] = strconv.FormatInt(*m.Delta, 10)
+				} else if m.Value != nil {
+					metrics[m.ID] = strconv.FormatFloat(*m.Value, 'f', -1, 64)
 				}
 			}
 			return ctx.JSON(http.StatusOK, metrics)
 		}
 
-		ctx.Response().Header().Set("Content-Type", "text/html")
-		return ctx.String(http.StatusOK, h.store.AllMetrics())
+		return h.renderDashboard(ctx)
 	}
 }
 
@@ -120,9 +194,14 @@ func (h *handler) UpdateJSON() echo.HandlerFunc {
 	return func(ctx echo.Context) error {
 		var metric models.Metrics
 
-		err := json.NewDecoder(ctx.Request().Body).Decode(&metric)
-		if err != nil {
-			return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Ошибка при декодировании JSON: %s", err)})
+		if p := decodeJSON(ctx, &metric, false); p != nil {
+			return problem.Send(ctx, p)
+		}
+		if p := h.validator.Metric(metric); p != nil {
+			return problem.Send(ctx, p)
+		}
+		if p := h.checkWrite(ctx, metric); p != nil {
+			return problem.Send(ctx, p)
 		}
 
 		switch metric.MType {
@@ -130,9 +209,11 @@ func (h *handler) UpdateJSON() echo.HandlerFunc {
 			h.store.UpdateCounter(metric.ID, *metric.Delta)
 		case "gauge":
 			h.store.UpdateGauge(metric.ID, *metric.Value)
-		default:
-			return ctx.JSON(http.StatusNotFound, map[string]string{"error": "Недопустимый тип метрики. Может быть только 'gauge' или 'counter'"})
 		}
+		if metric.Labels != nil {
+			h.store.UpdateLabels(metric.MType, metric.ID, metric.Labels)
+		}
+		h.store.UpdateAgent(metric.MType, metric.ID, agentID(ctx))
 
 		ctx.Response().Header().Set("Content-Type", "application/json")
 		return ctx.JSON(http.StatusOK, metric)
@@ -141,51 +222,93 @@ func (h *handler) UpdateJSON() echo.HandlerFunc {
 
 func (h *handler) GetValueJSON() echo.HandlerFunc {
 	return func(ctx echo.Context) error {
-		ctx.Response().Header().Set("Content-Type", "application/json")
 		var metric models.Metrics
-		err := json.NewDecoder(ctx.Request().Body).Decode(&metric)
-		if err != nil {
-			return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Ошибка при декодировании JSON: %s", err)})
+		if p := decodeJSON(ctx, &metric, false); p != nil {
+			return problem.Send(ctx, p)
+		}
+		if p := h.validator.Query(metric); p != nil {
+			return problem.Send(ctx, p)
 		}
 
 		switch metric.MType {
 		case "counter":
-			value := h.store.GetCounterValue(metric.ID)
+			value, ok := h.store.LookupCounter(metric.ID)
+			if !ok {
+				return problem.Send(ctx, errNotFound(metric.MType, metric.ID))
+			}
 			metric.Delta = &value
 		case "gauge":
-			value := h.store.GetGaugeValue(metric.ID)
+			value, ok := h.store.LookupGauge(metric.ID)
+			if !ok {
+				return problem.Send(ctx, errNotFound(metric.MType, metric.ID))
+			}
 			metric.Value = &value
-		default:
-			return ctx.JSON(http.StatusNotFound, map[string]string{"error": "Недопустимый тип метрики. Может быть только 'gauge' или 'counter'"})
 		}
 
+		ctx.Response().Header().Set("Content-Type", "application/json")
 		return ctx.JSON(http.StatusOK, metric)
 	}
 }
 
 func (h *handler) PingDB(sw storage.StorageWorker) echo.HandlerFunc {
 	return func(ctx echo.Context) error {
-		err := sw.Check()
-		ctx.Response().Header().Set("Content-Type", "text/html")
-		if err == nil {
-			return ctx.String(http.StatusOK, "Connection database is OK")
-		} else {
+		if sw == nil || sw.Check() != nil {
 			zap.S().Error("Connection database is NOT OK")
-			return ctx.String(http.StatusInternalServerError, "Connection database is NOT OK")
+			return problem.Write(ctx, http.StatusInternalServerError, problem.CodeStorageUnavailable, "Connection database is NOT OK")
 		}
+		ctx.Response().Header().Set("Content-Type", "text/html")
+		return ctx.String(http.StatusOK, "Connection database is OK")
 	}
 }
 
+// deniedMetric метрика пакета, запись которой запрещена
+type deniedMetric struct {
+	Index  int    `json:"index"`
+	ID     string `json:"id"`
+	MType  string `json:"type"`
+	Detail string `json:"detail"`
+}
+
+// UpdatesJSON сохраняет пакет метрик. Некорректный пакет отклоняется целиком,
+// а метрики, запись которых запрещена, отбрасываются и перечисляются в ответе
 func (h *handler) UpdatesJSON() echo.HandlerFunc {
 	return func(ctx echo.Context) error {
 		metrics := make([]models.Metrics, 0)
-		err := json.NewDecoder(ctx.Request().Body).Decode(&metrics)
-		if err != nil && !errors.Is(err, io.EOF) {
-			return ctx.JSON(http.StatusBadRequest, map[string]string{"error": fmt.Sprintf("Error in JSON decode: %s", err)})
+		if p := decodeJSON(ctx, &metrics, true); p != nil {
+			return problem.Send(ctx, p)
+		}
+		if p := h.validator.Batch(len(metrics)); p != nil {
+			return problem.Send(ctx, p)
+		}
+		for i, m := range metrics {
+			if p := h.validator.Metric(m); p != nil {
+				p.Detail = fmt.Sprintf("metric #%d: %s", i, p.Detail)
+				return problem.Send(ctx, p)
+			}
+		}
+
+		allowed := metrics[:0:0]
+		var denied []deniedMetric
+		for i, m := range metrics {
+			if p := h.checkWrite(ctx, m); p != nil {
+				denied = append(denied, deniedMetric{Index: i, ID: m.ID, MType: m.MType, Detail: p.Detail})
+				continue
+			}
+			allowed = append(allowed, m)
+		}
+		if len(allowed) == 0 && len(denied) > 0 {
+			return problem.Write(ctx, http.StatusForbidden, problem.CodeForbidden, fmt.Sprintf("all %d metrics are denied: %s", len(denied), denied[0].Detail))
+		}
+		h.store.StoreBatch(allowed)
+		agent := agentID(ctx)
+		for _, m := range allowed {
+			h.store.UpdateAgent(m.MType, m.ID, agent)
 		}
-		h.store.StoreBatch(metrics)
 		ctx.Response().Header().Set("Content-Type", "application/json")
 
+		if len(denied) > 0 {
+			return ctx.JSON(http.StatusOK, map[string]any{"status": "partial", "stored": len(allowed), "denied": denied})
+		}
 		return ctx.JSON(http.StatusOK, map[string]string{"status": "success"})
 	}
 }
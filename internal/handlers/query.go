@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lionslon/go-yapmetrics/internal/problem"
+	"github.com/lionslon/go-yapmetrics/internal/query"
+)
+
+// maxQueryLength ограничение длины выражения
+const maxQueryLength = 4096
+
+// Query вычисляет выражение языка запросов из параметра expr
+func (h *handler) Query() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		expr := ctx.QueryParam("expr")
+		if expr == "" {
+			return problem.Write(ctx, http.StatusBadRequest, problem.CodeInvalidQuery, "expr is required")
+		}
+		if len(expr) > maxQueryLength {
+			return problem.Write(ctx, http.StatusBadRequest, problem.CodeInvalidQuery, fmt.Sprintf("expr is longer than %d characters", maxQueryLength))
+		}
+		e, err := query.Parse(expr)
+		if err != nil {
+			return problem.Write(ctx, http.StatusBadRequest, problem.CodeInvalidQuery, fmt.Sprintf("invalid expr %s", err))
+		}
+		res, err := query.Eval(e, h.store, time.Now())
+		if err != nil {
+			return problem.Write(ctx, http.StatusUnprocessableEntity, problem.CodeInvalidQuery, err.Error())
+		}
+		return ctx.JSON(http.StatusOK, res)
+	}
+}
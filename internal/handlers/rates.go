@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lionslon/go-yapmetrics/internal/problem"
+	"github.com/lionslon/go-yapmetrics/internal/storage"
+)
+
+// defaultRateWindow окно rate по умолчанию
+const defaultRateWindow = 5 * time.Minute
+
+// rateView ответ GET /rate/:nameM
+type rateView struct {
+	storage.Rate
+	Window string `json:"window"`
+}
+
+// CounterRate прирост counter-метрики и прирост в секунду за окно window
+func (h *handler) CounterRate() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		name := ctx.Param("nameM")
+		window := defaultRateWindow
+		if v := ctx.QueryParam("window"); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return problem.Write(ctx, http.StatusBadRequest, problem.CodeInvalidQuery, "window must be a positive duration like 5m")
+			}
+			window = d
+		}
+		if _, ok := h.store.LookupCounter(name); !ok {
+			return problem.Send(ctx, errNotFound("counter", name))
+		}
+		rate, ok := h.store.CounterRate(name, window, time.Now())
+		if !ok {
+			return problem.Write(ctx, http.StatusUnprocessableEntity, problem.CodeNotEnoughSamples,
+				fmt.Sprintf("counter %q has %d samples in the last %s, at least 2 are needed", name, rate.Samples, window))
+		}
+		return ctx.JSON(http.StatusOK, rateView{Rate: rate, Window: window.String()})
+	}
+}
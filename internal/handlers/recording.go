@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lionslon/go-yapmetrics/internal/recording"
+)
+
+// RecordingRules состояние правил записи: ошибка загрузки файла и результат последнего вычисления
+// каждого правила. Без файла правил возвращается пустой список
+func (h *handler) RecordingRules(r *recording.Recorder) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		if r == nil {
+			return ctx.JSON(http.StatusOK, recording.Status{Rules: []recording.RuleStatus{}})
+		}
+		return ctx.JSON(http.StatusOK, r.Status())
+	}
+}
@@ -0,0 +1,87 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lionslon/go-yapmetrics/internal/alerting"
+	"github.com/lionslon/go-yapmetrics/internal/middlewares"
+	"github.com/lionslon/go-yapmetrics/internal/problem"
+	"go.uber.org/zap"
+)
+
+// silenceRequest запрос на создание тишины: конец задается временем ends_at или длительностью duration
+type silenceRequest struct {
+	Matchers []alerting.Matcher `json:"matchers"`
+	StartsAt time.Time          `json:"starts_at"`
+	EndsAt   time.Time          `json:"ends_at"`
+	Duration alerting.Duration  `json:"duration"`
+	Author   string             `json:"author"`
+	Comment  string             `json:"comment"`
+}
+
+// ListSilences список действующих и запланированных тишин
+func (h *handler) ListSilences(silences *alerting.Silences) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		return ctx.JSON(http.StatusOK, silences.List(time.Now()))
+	}
+}
+
+// CreateSilence создает тишину, по умолчанию автором считается клиент запроса
+func (h *handler) CreateSilence(silences *alerting.Silences) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		var req silenceRequest
+		if p := decodeJSON(ctx, &req, false); p != nil {
+			return problem.Send(ctx, p)
+		}
+		now := time.Now()
+		sl := alerting.Silence{
+			Matchers: req.Matchers,
+			StartsAt: req.StartsAt,
+			EndsAt:   req.EndsAt,
+			Author:   req.Author,
+			Comment:  req.Comment,
+		}
+		if sl.Author == "" {
+			sl.Author = middlewares.Identity(ctx)
+		}
+		if sl.EndsAt.IsZero() && req.Duration > 0 {
+			start := sl.StartsAt
+			if start.IsZero() {
+				start = now
+			}
+			sl.EndsAt = start.Add(time.Duration(req.Duration))
+		}
+
+		created, err := silences.Add(sl, now)
+		switch {
+		case errors.Is(err, alerting.ErrInvalidSilence):
+			return problem.Write(ctx, http.StatusBadRequest, problem.CodeInvalidValue, err.Error())
+		case err != nil:
+			zap.S().Error(err)
+			return problem.Write(ctx, http.StatusInternalServerError, problem.CodeStorageUnavailable, "silence is not saved")
+		}
+		auditLog(ctx).Infow("create silence", "silence", created.ID, "author", created.Author)
+		return ctx.JSON(http.StatusCreated, created)
+	}
+}
+
+// ExpireSilence досрочно завершает тишину
+func (h *handler) ExpireSilence(silences *alerting.Silences) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		id := ctx.Param("id")
+		expired, err := silences.Expire(id, time.Now())
+		if err != nil {
+			zap.S().Error(err)
+			return problem.Write(ctx, http.StatusInternalServerError, problem.CodeStorageUnavailable, "silence is not saved")
+		}
+		if !expired {
+			return problem.Write(ctx, http.StatusNotFound, problem.CodeNotFound, fmt.Sprintf("silence %q not found", id))
+		}
+		auditLog(ctx).Infow("expire silence", "silence", id)
+		return ctx.JSON(http.StatusOK, map[string]string{"status": "expired"})
+	}
+}
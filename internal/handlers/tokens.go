@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lionslon/go-yapmetrics/internal/auth"
+	"github.com/lionslon/go-yapmetrics/internal/problem"
+	"go.uber.org/zap"
+)
+
+// maxTokenIDLength ограничение длины идентификатора токена
+const maxTokenIDLength = 64
+
+// tokenRequest запрос на выпуск токена
+type tokenRequest struct {
+	ID    string   `json:"id"`
+	Roles []string `json:"roles"`
+}
+
+// issuedToken выпущенный токен, открытое значение возвращается только в этом ответе
+type issuedToken struct {
+	auth.Token
+	Secret string `json:"token"`
+}
+
+// auditToken журнал действий с токенами
+func auditToken(ctx echo.Context, action string, id string) {
+	auditLog(ctx).Infow(action,
+		"token", id,
+	)
+}
+
+// errNotPersistent ошибка управления токенами без файла токенов
+func errNotPersistent() *problem.Problem {
+	return problem.New(http.StatusConflict, problem.CodeConflict, "tokens can be managed only with -tokens-file")
+}
+
+// ListTokens список токенов без открытых значений и хешей
+func (h *handler) ListTokens(tokens *auth.Store) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		return ctx.JSON(http.StatusOK, tokens.List())
+	}
+}
+
+// CreateToken выпускает токен с ролями
+func (h *handler) CreateToken(tokens *auth.Store) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		var req tokenRequest
+		if p := decodeJSON(ctx, &req, false); p != nil {
+			return problem.Send(ctx, p)
+		}
+		if req.ID == "" || len(req.ID) > maxTokenIDLength || req.ID == auth.BootstrapID {
+			return problem.Write(ctx, http.StatusBadRequest, problem.CodeInvalidValue, fmt.Sprintf("token id must be 1 to %d characters and not %q", maxTokenIDLength, auth.BootstrapID))
+		}
+		if len(req.Roles) == 0 {
+			return problem.Write(ctx, http.StatusBadRequest, problem.CodeInvalidValue, "at least one role is required")
+		}
+
+		plain, t, err := tokens.Create(req.ID, req.Roles)
+		switch {
+		case errors.Is(err, auth.ErrInvalidRole):
+			return problem.Write(ctx, http.StatusBadRequest, problem.CodeInvalidValue, err.Error())
+		case errors.Is(err, auth.ErrExists):
+			return problem.Write(ctx, http.StatusConflict, problem.CodeConflict, fmt.Sprintf("token %q already exists", req.ID))
+		case errors.Is(err, auth.ErrNotPersistent):
+			return problem.Send(ctx, errNotPersistent())
+		case err != nil:
+			zap.S().Error(err)
+			return problem.Write(ctx, http.StatusInternalServerError, problem.CodeStorageUnavailable, "token is not saved")
+		}
+		auditToken(ctx, "create token", t.ID)
+		return ctx.JSON(http.StatusCreated, issuedToken{Token: t, Secret: plain})
+	}
+}
+
+// RevokeToken отзывает токен
+func (h *handler) RevokeToken(tokens *auth.Store) echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		id := ctx.Param("id")
+		revoked, err := tokens.Revoke(id)
+		if errors.Is(err, auth.ErrNotPersistent) {
+			return problem.Send(ctx, errNotPersistent())
+		}
+		if err != nil {
+			zap.S().Error(err)
+			return problem.Write(ctx, http.StatusInternalServerError, problem.CodeStorageUnavailable, "token is not revoked")
+		}
+		if !revoked {
+			return problem.Write(ctx, http.StatusNotFound, problem.CodeNotFound, fmt.Sprintf("token %q not found", id))
+		}
+		auditToken(ctx, "revoke token", id)
+		return ctx.JSON(http.StatusOK, map[string]string{"status": "revoked"})
+	}
+}
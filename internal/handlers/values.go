@@ -0,0 +1,194 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lionslon/go-yapmetrics/internal/models"
+	"github.com/lionslon/go-yapmetrics/internal/problem"
+	"github.com/lionslon/go-yapmetrics/internal/storage"
+)
+
+const (
+	defaultListLimit = 100
+	maxListLimit     = 1000
+)
+
+// valuesPage ответ GET /values
+type valuesPage struct {
+	Metrics    []models.Metrics `json:"metrics"`
+	NextCursor string           `json:"next_cursor,omitempty"`
+}
+
+// listFilter параметры фильтрации списка метрик
+type listFilter struct {
+	mtype  string
+	prefix string
+	re     *regexp.Regexp
+	labels map[string]*string
+}
+
+// match проверяет метрику на соответствие фильтру
+func (f listFilter) match(m models.Metrics) bool {
+	if f.mtype != "" && m.MType != f.mtype {
+		return false
+	}
+	if !strings.HasPrefix(m.ID, f.prefix) {
+		return false
+	}
+	if f.re != nil && !f.re.MatchString(m.ID) {
+		return false
+	}
+	for k, want := range f.labels {
+		v, ok := m.Labels[k]
+		if !ok || (want != nil && v != *want) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseListFilter разбирает параметры type, prefix, regex и label
+func parseListFilter(ctx echo.Context) (listFilter, error) {
+	f := listFilter{
+		mtype:  ctx.QueryParam("type"),
+		prefix: ctx.QueryParam("prefix"),
+		labels: make(map[string]*string),
+	}
+	if f.mtype != "" && f.mtype != "gauge" && f.mtype != "counter" {
+		return f, fmt.Errorf("invalid type %q, can only be 'gauge' or 'counter'", f.mtype)
+	}
+	if expr := ctx.QueryParam("regex"); expr != "" {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return f, fmt.Errorf("invalid regex: %w", err)
+		}
+		f.re = re
+	}
+	for _, l := range ctx.QueryParams()["label"] {
+		k, v, found := strings.Cut(l, "=")
+		if k == "" {
+			return f, fmt.Errorf("invalid label filter %q, expected key or key=value", l)
+		}
+		if found {
+			f.labels[k] = &v
+		} else {
+			f.labels[k] = nil
+		}
+	}
+	return f, nil
+}
+
+// encodeCursor кодирует позицию последней отданной метрики
+func encodeCursor(m models.Metrics) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(m.MType + ":" + m.ID))
+}
+
+// decodeCursor возвращает имя и тип метрики, после которой продолжается выдача
+func decodeCursor(c string) (string, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(c)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid cursor")
+	}
+	t, id, found := strings.Cut(string(raw), ":")
+	if !found {
+		return "", "", fmt.Errorf("invalid cursor")
+	}
+	return id, t, nil
+}
+
+// ListValues отдает типизированный список метрик с фильтрацией, сортировкой и постраничной выдачей
+func (h *handler) ListValues() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		f, err := parseListFilter(ctx)
+		if err != nil {
+			return problem.Write(ctx, http.StatusBadRequest, problem.CodeInvalidQuery, err.Error())
+		}
+
+		limit := defaultListLimit
+		if l := ctx.QueryParam("limit"); l != "" {
+			limit, err = strconv.Atoi(l)
+			if err != nil || limit <= 0 {
+				return problem.Write(ctx, http.StatusBadRequest, problem.CodeInvalidQuery, fmt.Sprintf("%s is not a valid limit", l))
+			}
+		}
+		if limit > maxListLimit {
+			limit = maxListLimit
+		}
+
+		desc := false
+		switch ctx.QueryParam("order") {
+		case "", "asc":
+		case "desc":
+			desc = true
+		default:
+			return problem.Write(ctx, http.StatusBadRequest, problem.CodeInvalidQuery, "invalid order, can only be 'asc' or 'desc'")
+		}
+
+		// выдача идет по (ID, MType), поэтому курсор однозначно задает позицию
+		var after *storage.Cursor
+		if c := ctx.QueryParam("cursor"); c != "" {
+			id, t, err := decodeCursor(c)
+			if err != nil {
+				return problem.Write(ctx, http.StatusBadRequest, problem.CodeInvalidQuery, err.Error())
+			}
+			after = &storage.Cursor{ID: id, MType: t}
+		}
+
+		metrics, more := h.store.Page(after, desc, limit, f.match)
+		page := valuesPage{Metrics: metrics}
+		if more {
+			page.NextCursor = encodeCursor(metrics[len(metrics)-1])
+		}
+
+		return ctx.JSON(http.StatusOK, page)
+	}
+}
+
+// batchValue элемент ответа POST /values/
+type batchValue struct {
+	models.Metrics
+	Found bool   `json:"found"`
+	Error string `json:"error,omitempty"` // код ошибки из пакета problem
+}
+
+// GetValuesJSON возвращает значения набора метрик в порядке запроса
+func (h *handler) GetValuesJSON() echo.HandlerFunc {
+	return func(ctx echo.Context) error {
+		var req []models.Metrics
+		if p := decodeJSON(ctx, &req, false); p != nil {
+			return problem.Send(ctx, p)
+		}
+		if p := h.validator.Batch(len(req)); p != nil {
+			return problem.Send(ctx, p)
+		}
+
+		res := make([]batchValue, len(req))
+		for i, m := range req {
+			item := batchValue{Metrics: models.Metrics{ID: m.ID, MType: m.MType}}
+			switch m.MType {
+			case "counter":
+				if v, ok := h.store.LookupCounter(m.ID); ok {
+					item.Delta, item.Found = &v, true
+				}
+			case "gauge":
+				if v, ok := h.store.LookupGauge(m.ID); ok {
+					item.Value, item.Found = &v, true
+				}
+			default:
+				item.Error = problem.CodeInvalidMetricType
+			}
+			if !item.Found && item.Error == "" {
+				item.Error = problem.CodeMetricNotFound
+			}
+			res[i] = item
+		}
+
+		return ctx.JSON(http.StatusOK, res)
+	}
+}
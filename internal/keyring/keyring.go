@@ -0,0 +1,173 @@
+// Package keyring хранит ключи подписи агентов по идентификаторам.
+// Ключи читаются из JSON-файла вида {"agent-1": "secret"} и из строки вида "agent-1:secret,agent-2:secret".
+// Файл можно менять на ходу: старый и новый ключ действуют одновременно, пока агенты переходят
+// на новый, а удаление ключа из файла отзывает его
+package keyring
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Keyring набор ключей подписи
+type Keyring struct {
+	mu         sync.RWMutex
+	defaultKey []byte
+	static     map[string][]byte
+	keys       map[string][]byte
+	path       string
+	modTime    time.Time
+}
+
+// New создает набор ключей. defaultKey используется для запросов без идентификатора ключа,
+// spec задает неизменяемые ключи, path — файл с ключами, который можно перечитывать
+func New(defaultKey string, spec string, path string) (*Keyring, error) {
+	static, err := Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	k := &Keyring{
+		static: static,
+		keys:   static,
+		path:   path,
+	}
+	if defaultKey != "" {
+		k.defaultKey = []byte(defaultKey)
+	}
+	if path != "" {
+		if err := k.Reload(); err != nil {
+			return nil, err
+		}
+	}
+	return k, nil
+}
+
+// Parse разбирает строку вида "id1:secret1,id2:secret2"
+func Parse(spec string) (map[string][]byte, error) {
+	res := make(map[string][]byte)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		id, secret, found := strings.Cut(item, ":")
+		if !found || id == "" || secret == "" {
+			return nil, fmt.Errorf("invalid keyring entry %q, expected id:secret", item)
+		}
+		res[id] = []byte(secret)
+	}
+	return res, nil
+}
+
+// Key возвращает секрет по идентификатору, пустой идентификатор означает ключ по умолчанию
+func (k *Keyring) Key(id string) ([]byte, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	if id == "" {
+		return k.defaultKey, k.defaultKey != nil
+	}
+	key, ok := k.keys[id]
+	return key, ok
+}
+
+// Empty сообщает, что ни одного ключа не задано
+func (k *Keyring) Empty() bool {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.defaultKey == nil && len(k.keys) == 0
+}
+
+// IDs возвращает идентификаторы известных ключей
+func (k *Keyring) IDs() []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	res := make([]string, 0, len(k.keys))
+	for id := range k.keys {
+		res = append(res, id)
+	}
+	return res
+}
+
+// Reload перечитывает файл с ключами. При ошибке действующие ключи не меняются
+func (k *Keyring) Reload() error {
+	if k.path == "" {
+		return nil
+	}
+	info, err := os.Stat(k.path)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(k.path)
+	if err != nil {
+		return err
+	}
+	var fileKeys map[string]string
+	if err = json.Unmarshal(data, &fileKeys); err != nil {
+		return fmt.Errorf("invalid keyring file %s: %w", k.path, err)
+	}
+
+	keys := make(map[string][]byte, len(k.static)+len(fileKeys))
+	for id, secret := range fileKeys {
+		if id == "" || secret == "" {
+			return fmt.Errorf("invalid keyring file %s: empty key id or secret", k.path)
+		}
+		keys[id] = []byte(secret)
+	}
+	for id, secret := range k.static {
+		keys[id] = secret
+	}
+
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.keys = keys
+	k.modTime = info.ModTime()
+	return nil
+}
+
+// changed сообщает, изменился ли файл с момента последнего чтения
+func (k *Keyring) changed() bool {
+	info, err := os.Stat(k.path)
+	if err != nil {
+		return false
+	}
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return !info.ModTime().Equal(k.modTime)
+}
+
+// Watch перечитывает файл при его изменении (проверка раз в interval) и по сигналу SIGHUP
+func (k *Keyring) Watch(interval time.Duration) {
+	if k.path == "" {
+		return
+	}
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-hup:
+		case <-tick:
+			if !k.changed() {
+				continue
+			}
+		}
+		if err := k.Reload(); err != nil {
+			zap.S().Error(err)
+			continue
+		}
+		zap.S().Infof("keyring %s reloaded, %d keys", k.path, len(k.IDs()))
+	}
+}
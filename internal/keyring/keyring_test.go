@@ -0,0 +1,66 @@
+package keyring
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyring(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"agent-1":"old","agent-2":"two"}`), 0600))
+
+	k, err := New("default", "static:s", path)
+	require.NoError(t, err)
+
+	key, ok := k.Key("")
+	assert.True(t, ok)
+	assert.Equal(t, "default", string(key))
+	key, ok = k.Key("agent-1")
+	assert.True(t, ok)
+	assert.Equal(t, "old", string(key))
+	_, ok = k.Key("static")
+	assert.True(t, ok)
+
+	// ротация ключа agent-1 и отзыв agent-2
+	require.NoError(t, os.WriteFile(path, []byte(`{"agent-1":"new","agent-1-next":"next"}`), 0600))
+	require.NoError(t, os.Chtimes(path, time.Now(), time.Now().Add(time.Second)))
+	assert.True(t, k.changed())
+	require.NoError(t, k.Reload())
+
+	key, _ = k.Key("agent-1")
+	assert.Equal(t, "new", string(key))
+	_, ok = k.Key("agent-1-next")
+	assert.True(t, ok)
+	_, ok = k.Key("agent-2")
+	assert.False(t, ok)
+	_, ok = k.Key("static")
+	assert.True(t, ok, "keys from the spec survive reloads")
+
+	// битый файл не ломает действующие ключи
+	require.NoError(t, os.WriteFile(path, []byte(`{`), 0600))
+	assert.Error(t, k.Reload())
+	_, ok = k.Key("agent-1")
+	assert.True(t, ok)
+}
+
+func TestParse(t *testing.T) {
+	keys, err := Parse("a:1, b:2")
+	require.NoError(t, err)
+	assert.Len(t, keys, 2)
+
+	_, err = Parse("a")
+	assert.Error(t, err)
+	_, err = Parse("a:")
+	assert.Error(t, err)
+
+	k, err := New("", "", "")
+	require.NoError(t, err)
+	assert.True(t, k.Empty())
+	_, ok := k.Key("")
+	assert.False(t, ok)
+}
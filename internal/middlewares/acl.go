@@ -0,0 +1,37 @@
+package middlewares
+
+import (
+	"github.com/labstack/echo/v4"
+	"github.com/lionslon/go-yapmetrics/internal/acl"
+)
+
+// identities собирает все идентичности клиента, подтвержденные предыдущими middleware
+func identities(ctx echo.Context) []string {
+	var ids []string
+	if id, ok := ctx.Get(ContextKeyID).(string); ok {
+		if id == "" {
+			id = acl.DefaultKeyID
+		}
+		ids = append(ids, acl.Identity(acl.KindKey, id))
+	}
+	if id, _ := ctx.Get(ContextTokenID).(string); id != "" {
+		ids = append(ids, acl.Identity(acl.KindToken, id))
+	}
+	if id, _ := ctx.Get(ContextIdentity).(string); id != "" {
+		ids = append(ids, acl.Identity(acl.KindCert, id))
+	}
+	return ids
+}
+
+// WriteACL передает обработчикам записи субъект проверки ACL.
+// Middleware должен стоять после CheckSignReq, ClientCert и RequireRole, nil отключает проверку
+func WriteACL(rules *acl.ACL) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			if rules != nil {
+				ctx.Set(acl.ContextSubject, acl.Subject{ACL: rules, Identities: identities(ctx)})
+			}
+			return next(ctx)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lionslon/go-yapmetrics/internal/auth"
+	"github.com/lionslon/go-yapmetrics/internal/problem"
+)
+
+// ContextTokenID ключ echo.Context с идентификатором проверенного токена
+const ContextTokenID = "tokenID"
+
+// RequireRole пропускает только запросы с токеном в заголовке Authorization, которому разрешена роль role.
+// Без файла токенов и JWKS роли ingest и read не проверяются, а административные методы
+// доступны только токену из конфигурации
+func RequireRole(tokens auth.Authenticator, role string) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			if role != auth.RoleAdmin && !tokens.Enabled() {
+				return next(ctx)
+			}
+			got, ok := bearerToken(ctx.Request())
+			if !ok {
+				ctx.Response().Header().Set(echo.HeaderWWWAuthenticate, "Bearer")
+				return problem.Write(ctx, http.StatusUnauthorized, problem.CodeUnauthorized, "bearer token is required")
+			}
+			t, ok := tokens.Authenticate(got)
+			if !ok {
+				ctx.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+				return problem.Write(ctx, http.StatusUnauthorized, problem.CodeUnauthorized, "bearer token is not valid")
+			}
+			if !t.Has(role) {
+				return problem.Write(ctx, http.StatusForbidden, problem.CodeForbidden, fmt.Sprintf("token %q has no %s role", t.ID, role))
+			}
+			ctx.Set(ContextTokenID, t.ID)
+			ctx.Set(auth.ContextToken, t)
+			return next(ctx)
+		}
+	}
+}
+
+// bearerToken извлекает токен из заголовка Authorization: Bearer <token>
+func bearerToken(req *http.Request) (string, bool) {
+	scheme, token, found := strings.Cut(req.Header.Get(echo.HeaderAuthorization), " ")
+	if !found || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", false
+	}
+	return strings.TrimSpace(token), true
+}
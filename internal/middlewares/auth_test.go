@@ -0,0 +1,61 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lionslon/go-yapmetrics/internal/auth"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequireRole(t *testing.T) {
+	tokens, err := auth.NewStore(filepath.Join(t.TempDir(), "tokens.json"), "root")
+	require.NoError(t, err)
+	agent, _, err := tokens.Create("agent-1", []string{auth.RoleIngest})
+	require.NoError(t, err)
+	dashboard, _, err := tokens.Create("grafana", []string{auth.RoleRead})
+	require.NoError(t, err)
+	open, err := auth.NewStore("", "root")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name   string
+		tokens *auth.Store
+		role   string
+		token  string
+		status int
+		id     string
+	}{
+		{name: "ingest token", tokens: tokens, role: auth.RoleIngest, token: agent, status: http.StatusOK, id: "agent-1"},
+		{name: "read token can not ingest", tokens: tokens, role: auth.RoleIngest, token: dashboard, status: http.StatusForbidden},
+		{name: "ingest token can not read", tokens: tokens, role: auth.RoleRead, token: agent, status: http.StatusForbidden},
+		{name: "admin can read", tokens: tokens, role: auth.RoleRead, token: "root", status: http.StatusOK, id: auth.BootstrapID},
+		{name: "missing token", tokens: tokens, role: auth.RoleRead, status: http.StatusUnauthorized},
+		{name: "unknown token", tokens: tokens, role: auth.RoleRead, token: "guess", status: http.StatusUnauthorized},
+		{name: "no token file: read is open", tokens: open, role: auth.RoleRead, status: http.StatusOK},
+		{name: "no token file: admin needs token", tokens: open, role: auth.RoleAdmin, status: http.StatusUnauthorized},
+		{name: "no token file: admin token", tokens: open, role: auth.RoleAdmin, token: "root", status: http.StatusOK, id: auth.BootstrapID},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			e := echo.New()
+			e.GET("/", func(ctx echo.Context) error {
+				assert.Equal(t, test.id, Identity(ctx))
+				return ctx.NoContent(http.StatusOK)
+			}, RequireRole(test.tokens, test.role))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.token != "" {
+				req.Header.Set(echo.HeaderAuthorization, "Bearer "+test.token)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.status, rec.Code)
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package middlewares
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lionslon/go-yapmetrics/internal/codec"
+	"github.com/lionslon/go-yapmetrics/internal/problem"
+)
+
+// ratioCheckThreshold объем распакованных данных, после которого начинает проверяться
+// степень сжатия: маленькие тела из повторяющихся символов сжимаются очень сильно
+const ratioCheckThreshold = 64 << 10
+
+// DecompressConfig настройки распаковки тела запроса
+type DecompressConfig struct {
+	MaxSize  int64 // максимальный размер распакованного тела
+	MaxRatio int64 // максимальное отношение распакованного размера к сжатому
+}
+
+// countingReader считает прочитанные байты
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// decompressReader распаковывает тело запроса и прерывает чтение, если распакованные данные
+// превышают абсолютный лимит или подозрительно сильно сжаты (zip-бомба)
+type decompressReader struct {
+	body io.ReadCloser
+	in   *countingReader
+	zr   io.ReadCloser
+	out  int64
+	cfg  DecompressConfig
+}
+
+// Read читает распакованные данные и проверяет лимиты
+func (d *decompressReader) Read(p []byte) (int, error) {
+	n, err := d.zr.Read(p)
+	d.out += int64(n)
+	if d.cfg.MaxSize > 0 && d.out > d.cfg.MaxSize {
+		return n, &http.MaxBytesError{Limit: d.cfg.MaxSize}
+	}
+	if d.cfg.MaxRatio > 0 && d.out > ratioCheckThreshold && d.out > d.in.n*d.cfg.MaxRatio {
+		return n, &http.MaxBytesError{Limit: d.in.n * d.cfg.MaxRatio}
+	}
+	return n, err
+}
+
+// Close закрывает распаковщик и исходное тело
+func (d *decompressReader) Close() error {
+	return errors.Join(d.zr.Close(), d.body.Close())
+}
+
+// Decompress распаковывает тело запроса любым алгоритмом из реестра codec.
+// После распаковки заголовок Content-Encoding удаляется, а длина тела считается неизвестной.
+// Поддерживаемые алгоритмы сервер объявляет в заголовке ответа Accept-Encoding (RFC 7694)
+func Decompress(cfg DecompressConfig) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			req := ctx.Request()
+			ctx.Response().Header().Set(echo.HeaderAcceptEncoding, strings.Join(codec.Names(), ", "))
+			encoding := strings.ToLower(strings.TrimSpace(req.Header.Get(echo.HeaderContentEncoding)))
+			if encoding == "" || encoding == "identity" || req.Body == nil || req.Body == http.NoBody {
+				return next(ctx)
+			}
+
+			in := &countingReader{r: req.Body}
+			c, err := codec.Get(encoding)
+			var zr io.ReadCloser
+			if err == nil {
+				zr, err = c.NewReader(in, cfg.MaxSize)
+			}
+			if err != nil {
+				if errors.Is(err, codec.ErrUnsupported) {
+					return problem.Write(ctx, http.StatusUnsupportedMediaType, problem.CodeUnsupportedEncoding, err.Error())
+				}
+				if p := problem.FromBodyError(err); p != nil {
+					return problem.Send(ctx, p)
+				}
+				return problem.Write(ctx, http.StatusBadRequest, problem.CodeInvalidEncoding, err.Error())
+			}
+
+			req.Body = &decompressReader{body: req.Body, in: in, zr: zr, cfg: cfg}
+			req.Header.Del(echo.HeaderContentEncoding)
+			req.Header.Del(echo.HeaderContentLength)
+			req.ContentLength = -1
+			defer req.Body.Close()
+
+			return next(ctx)
+		}
+	}
+}
+
+// CompressConfig настройки сжатия ответов
+type CompressConfig struct {
+	Level     int // уровень сжатия, 0 — уровень алгоритма по умолчанию
+	MinLength int // ответы меньшего размера отправляются без сжатия
+}
+
+// Validate проверяет уровень сжатия по шкале каждого алгоритма, которым может быть сжат ответ
+func (c CompressConfig) Validate() error {
+	for _, name := range codec.Names() {
+		cdc, err := codec.Get(name)
+		if err != nil {
+			return err
+		}
+		if err := codec.CheckLevel(cdc, c.Level); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// negotiateEncoding выбирает алгоритм сжатия по заголовку Accept-Encoding с учетом q-значений.
+// Пустая строка означает, что ответ сжимать не нужно
+func negotiateEncoding(header string) string {
+	if header == "" {
+		return ""
+	}
+	weights := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if k, v, ok := strings.Cut(strings.TrimSpace(params), "="); ok && strings.TrimSpace(k) == "q" {
+			if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				q = f
+			}
+		}
+		weights[name] = q
+	}
+
+	names := codec.Names()
+	candidates := make([]string, 0, len(names))
+	for _, enc := range names {
+		q, ok := weights[enc]
+		if !ok {
+			q, ok = weights["*"]
+		}
+		if ok && q > 0 {
+			candidates = append(candidates, enc)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return weightOf(weights, candidates[i]) > weightOf(weights, candidates[j])
+	})
+	if len(candidates) == 0 {
+		return ""
+	}
+	return candidates[0]
+}
+
+// weightOf возвращает q-значение алгоритма с учетом "*"
+func weightOf(weights map[string]float64, enc string) float64 {
+	if q, ok := weights[enc]; ok {
+		return q
+	}
+	return weights["*"]
+}
+
+// compressWriter реализует интерфейс http.ResponseWriter и позволяет прозрачно для сервера
+// сжимать передаваемые данные и выставлять правильные HTTP-заголовки.
+// Данные копятся в буфере, пока не наберется MinLength, короткие ответы уходят без сжатия
+type compressWriter struct {
+	http.ResponseWriter
+	codec   codec.Codec
+	cfg     CompressConfig
+	buf     []byte
+	status  int
+	started bool
+	zw      io.WriteCloser
+}
+
+// WriteHeader откладывает отправку статуса до решения о сжатии
+func (c *compressWriter) WriteHeader(statusCode int) {
+	if c.status == 0 {
+		c.status = statusCode
+	}
+}
+
+// Write копит данные до порога сжатия, после него пишет через упаковщик
+func (c *compressWriter) Write(p []byte) (int, error) {
+	if c.started {
+		if c.zw != nil {
+			return c.zw.Write(p)
+		}
+		return c.ResponseWriter.Write(p)
+	}
+	c.buf = append(c.buf, p...)
+	if len(c.buf) >= c.cfg.MinLength {
+		if err := c.start(true); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// start отправляет заголовки и накопленные данные, сжимая их, если compress и ответ допускает сжатие
+func (c *compressWriter) start(compress bool) error {
+	c.started = true
+	if c.status == 0 {
+		c.status = http.StatusOK
+	}
+	h := c.Header()
+	if compress && h.Get(echo.HeaderContentEncoding) == "" && c.status != http.StatusNoContent && c.status != http.StatusNotModified {
+		zw, err := c.codec.NewWriter(c.ResponseWriter, c.cfg.Level)
+		if err != nil {
+			return err
+		}
+		c.zw = zw
+		h.Set(echo.HeaderContentEncoding, c.codec.Name())
+		h.Del(echo.HeaderContentLength)
+	}
+	c.ResponseWriter.WriteHeader(c.status)
+	if len(c.buf) == 0 {
+		return nil
+	}
+	var err error
+	if c.zw != nil {
+		_, err = c.zw.Write(c.buf)
+	} else {
+		_, err = c.ResponseWriter.Write(c.buf)
+	}
+	c.buf = nil
+	return err
+}
+
+// Flush отправляет накопленные данные клиенту
+func (c *compressWriter) Flush() {
+	if !c.started {
+		if err := c.start(len(c.buf) >= c.cfg.MinLength); err != nil {
+			return
+		}
+	}
+	if f, ok := c.zw.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+	if f, ok := c.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close досылает все данные из буфера и закрывает упаковщик
+func (c *compressWriter) Close() error {
+	if !c.started {
+		return c.start(false)
+	}
+	if c.zw != nil {
+		return c.zw.Close()
+	}
+	return nil
+}
+
+// Compress сжимает ответы алгоритмом из реестра codec, выбранным по заголовку Accept-Encoding
+func Compress(cfg CompressConfig) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			res := ctx.Response()
+			res.Header().Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+			encoding := negotiateEncoding(ctx.Request().Header.Get(echo.HeaderAcceptEncoding))
+			if encoding == "" || ctx.Request().Method == http.MethodHead {
+				return next(ctx)
+			}
+			c, err := codec.Get(encoding)
+			if err != nil {
+				return next(ctx)
+			}
+
+			cw := &compressWriter{ResponseWriter: res.Writer, codec: c, cfg: cfg}
+			res.Writer = cw
+			defer func() {
+				if err := cw.Close(); err != nil {
+					ctx.Logger().Error(err)
+				}
+				res.Writer = cw.ResponseWriter
+			}()
+
+			if err := next(ctx); err != nil {
+				// ошибку отрисовываем до закрытия упаковщика, чтобы она попала в сжатый поток
+				ctx.Error(err)
+			}
+			return nil
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package middlewares
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lionslon/go-yapmetrics/internal/codec"
+	"github.com/lionslon/go-yapmetrics/internal/problem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func gzipBytes(t *testing.T, b []byte) []byte {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := zw.Write(b)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func zlibBytes(t *testing.T, b []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	_, err := zw.Write(b)
+	require.NoError(t, err)
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func zstdBytes(t *testing.T, b []byte) []byte {
+	c, err := codec.Get("zstd")
+	require.NoError(t, err)
+	res, err := codec.Compress(c, b, 0)
+	require.NoError(t, err)
+	return res
+}
+
+func TestDecompress(t *testing.T) {
+	payload := []byte(`{"id":"Alloc","type":"gauge","value":1}`)
+	bomb := bytes.Repeat([]byte("0"), 4<<20)
+
+	e := echo.New()
+	e.HTTPErrorHandler = problem.HTTPErrorHandler
+	e.Use(Decompress(DecompressConfig{MaxSize: 8 << 20, MaxRatio: 100}))
+	e.POST("/", func(ctx echo.Context) error {
+		body, err := io.ReadAll(ctx.Request().Body)
+		if p := problem.FromBodyError(err); p != nil {
+			return problem.Send(ctx, p)
+		}
+		require.NoError(t, err)
+		assert.Empty(t, ctx.Request().Header.Get(echo.HeaderContentEncoding))
+		return ctx.Blob(http.StatusOK, "application/octet-stream", body)
+	})
+
+	testCases := []struct {
+		name     string
+		encoding string
+		body     []byte
+		status   int
+		want     []byte
+		code     string
+	}{
+		{name: "identity", body: payload, status: http.StatusOK, want: payload},
+		{name: "gzip", encoding: "gzip", body: gzipBytes(t, payload), status: http.StatusOK, want: payload},
+		{name: "deflate", encoding: "deflate", body: zlibBytes(t, payload), status: http.StatusOK, want: payload},
+		{name: "zstd", encoding: "zstd", body: zstdBytes(t, payload), status: http.StatusOK, want: payload},
+		{name: "corrupt gzip", encoding: "gzip", body: payload, status: http.StatusBadRequest, code: problem.CodeInvalidEncoding},
+		{name: "unsupported", encoding: "br", body: payload, status: http.StatusUnsupportedMediaType, code: problem.CodeUnsupportedEncoding},
+		{name: "ratio bomb", encoding: "gzip", body: gzipBytes(t, bomb), status: http.StatusRequestEntityTooLarge, code: problem.CodeBodyTooLarge},
+		{name: "size bomb", encoding: "gzip", body: gzipBytes(t, append(bytes.Repeat([]byte("0"), 9<<20), 'x')), status: http.StatusRequestEntityTooLarge, code: problem.CodeBodyTooLarge},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(test.body))
+			if test.encoding != "" {
+				req.Header.Set(echo.HeaderContentEncoding, test.encoding)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			require.Equal(t, test.status, rec.Code)
+			assert.Equal(t, "zstd, gzip, deflate", rec.Header().Get(echo.HeaderAcceptEncoding))
+			if test.code != "" {
+				var p problem.Problem
+				require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &p))
+				assert.Equal(t, test.code, p.Code)
+				return
+			}
+			assert.Equal(t, test.want, rec.Body.Bytes())
+		})
+	}
+}
+
+func TestCompressConfigValidate(t *testing.T) {
+	assert.NoError(t, CompressConfig{}.Validate())
+	assert.NoError(t, CompressConfig{Level: 9}.Validate())
+	assert.ErrorIs(t, CompressConfig{Level: 10}.Validate(), codec.ErrInvalidLevel, "gzip and deflate stop at 9")
+	assert.ErrorIs(t, CompressConfig{Level: -1}.Validate(), codec.ErrInvalidLevel, "zstd has no negative levels")
+}
+
+func TestCompress(t *testing.T) {
+	long := strings.Repeat("metric ", 100)
+	e := echo.New()
+	e.Use(Compress(CompressConfig{Level: 5, MinLength: 256}))
+	e.GET("/long", func(ctx echo.Context) error { return ctx.String(http.StatusOK, long) })
+	e.GET("/short", func(ctx echo.Context) error { return ctx.String(http.StatusOK, "ok") })
+	e.GET("/empty", func(ctx echo.Context) error { return ctx.NoContent(http.StatusNoContent) })
+
+	testCases := []struct {
+		name     string
+		target   string
+		accept   string
+		encoding string
+		status   int
+		want     string
+	}{
+		{name: "gzip", target: "/long", accept: "gzip", encoding: "gzip", status: http.StatusOK, want: long},
+		{name: "deflate", target: "/long", accept: "deflate", encoding: "deflate", status: http.StatusOK, want: long},
+		{name: "q-values", target: "/long", accept: "gzip;q=0.5, deflate;q=0.8", encoding: "deflate", status: http.StatusOK, want: long},
+		{name: "zstd", target: "/long", accept: "zstd", encoding: "zstd", status: http.StatusOK, want: long},
+		{name: "wildcard prefers zstd", target: "/long", accept: "*", encoding: "zstd", status: http.StatusOK, want: long},
+		{name: "refused", target: "/long", accept: "gzip;q=0, deflate;q=0", status: http.StatusOK, want: long},
+		{name: "unsupported only", target: "/long", accept: "br", status: http.StatusOK, want: long},
+		{name: "no accept-encoding", target: "/long", status: http.StatusOK, want: long},
+		{name: "below threshold", target: "/short", accept: "gzip", status: http.StatusOK, want: "ok"},
+		{name: "no content", target: "/empty", accept: "gzip", status: http.StatusNoContent},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, test.target, nil)
+			if test.accept != "" {
+				req.Header.Set(echo.HeaderAcceptEncoding, test.accept)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			require.Equal(t, test.status, rec.Code)
+			assert.Equal(t, test.encoding, rec.Header().Get(echo.HeaderContentEncoding))
+			assert.Contains(t, rec.Header().Values(echo.HeaderVary), echo.HeaderAcceptEncoding)
+
+			var body io.Reader = rec.Body
+			if test.encoding != "" {
+				c, err := codec.Get(test.encoding)
+				require.NoError(t, err)
+				zr, err := c.NewReader(body, 0)
+				require.NoError(t, err)
+				body = zr
+			}
+			got, err := io.ReadAll(body)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, string(got))
+		})
+	}
+}
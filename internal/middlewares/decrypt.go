@@ -0,0 +1,73 @@
+package middlewares
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lionslon/go-yapmetrics/internal/encryption"
+	"github.com/lionslon/go-yapmetrics/internal/problem"
+)
+
+// ContextPlaintext ключ echo.Context, отмечающий запрос с незашифрованным телом
+const ContextPlaintext = "plaintextBody"
+
+// Decrypt расшифровывает тело запроса, отмеченного заголовком X-Encryption, закрытым ключом сервера.
+// Запрос с телом без заголовка пропускается с отметкой ContextPlaintext, отклоняет его RequireEncryption.
+// Middleware должен стоять перед Decompress и CheckSignReq: агент сначала сжимает тело, затем шифрует
+func Decrypt(key *rsa.PrivateKey) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			req := ctx.Request()
+			scheme := req.Header.Get(encryption.HeaderEncryption)
+			if scheme == "" {
+				if req.Body != nil && req.Body != http.NoBody && req.ContentLength != 0 {
+					ctx.Set(ContextPlaintext, true)
+				}
+				return next(ctx)
+			}
+			if !strings.EqualFold(scheme, encryption.Scheme) {
+				return problem.Write(ctx, http.StatusUnsupportedMediaType, problem.CodeInvalidEncryption, fmt.Sprintf("unsupported encryption scheme %q", scheme))
+			}
+			if req.Body == nil {
+				return problem.Write(ctx, http.StatusBadRequest, problem.CodeInvalidEncryption, "encrypted request has no body")
+			}
+
+			msg, err := io.ReadAll(req.Body)
+			if p := problem.FromBodyError(err); p != nil {
+				return problem.Send(ctx, p)
+			}
+			if err != nil {
+				return problem.Write(ctx, http.StatusBadRequest, problem.CodeInvalidEncryption, fmt.Sprintf("failed to read request body: %s", err))
+			}
+			body, err := encryption.Decrypt(key, msg)
+			if err != nil {
+				return problem.Write(ctx, http.StatusBadRequest, problem.CodeInvalidEncryption, err.Error())
+			}
+
+			req.Body = io.NopCloser(bytes.NewReader(body))
+			req.Header.Del(encryption.HeaderEncryption)
+			req.Header.Del(echo.HeaderContentLength)
+			req.ContentLength = int64(len(body))
+			return next(ctx)
+		}
+	}
+}
+
+// RequireEncryption отклоняет запросы, тело которых Decrypt пропустил незашифрованным.
+// Ставится на маршруты приема метрик, false отключает проверку
+func RequireEncryption(required bool) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			if plain, _ := ctx.Get(ContextPlaintext).(bool); required && plain {
+				return problem.Write(ctx, http.StatusUnsupportedMediaType, problem.CodeInvalidEncryption,
+					fmt.Sprintf("request body must be encrypted, set %s: %s", encryption.HeaderEncryption, encryption.Scheme))
+			}
+			return next(ctx)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package middlewares
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lionslon/go-yapmetrics/internal/encryption"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecrypt(t *testing.T) {
+	privPEM, pubPEM, err := encryption.GenerateKeys(2048)
+	require.NoError(t, err)
+	priv, err := encryption.ParsePrivateKey(privPEM)
+	require.NoError(t, err)
+	pub, err := encryption.ParsePublicKey(pubPEM)
+	require.NoError(t, err)
+
+	const key = "secret"
+	body := []byte(`{"id":"PollCount","type":"counter","delta":1}`)
+	encrypted, err := encryption.Encrypt(pub, gzipBytes(t, body))
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name   string
+		scheme string
+		body   []byte
+		status int
+	}{
+		{name: "encrypted", scheme: encryption.Scheme, body: encrypted, status: http.StatusOK},
+		{name: "tampered", scheme: encryption.Scheme, body: append(bytes.Clone(encrypted[:len(encrypted)-1]), encrypted[len(encrypted)-1]^1), status: http.StatusBadRequest},
+		{name: "not encrypted", scheme: encryption.Scheme, body: gzipBytes(t, body), status: http.StatusBadRequest},
+		{name: "unknown scheme", scheme: "rot13", body: encrypted, status: http.StatusUnsupportedMediaType},
+		{name: "plain", body: gzipBytes(t, body), status: http.StatusOK},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			e := echo.New()
+			e.Use(Decrypt(priv))
+			e.Use(Decompress(DecompressConfig{}))
+			e.Use(CheckSignReq(SignConfig{Key: key, Mode: SignStrict}))
+			e.POST("/", func(ctx echo.Context) error {
+				got, err := io.ReadAll(ctx.Request().Body)
+				require.NoError(t, err)
+				assert.Equal(t, body, got)
+				return ctx.NoContent(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(test.body))
+			req.Header.Set(echo.HeaderContentEncoding, "gzip")
+			req.Header.Set("HashSHA256", GetSign(body, []byte(key)))
+			if test.scheme != "" {
+				req.Header.Set(encryption.HeaderEncryption, test.scheme)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.status, rec.Code)
+		})
+	}
+}
+
+func TestRequireEncryption(t *testing.T) {
+	privPEM, pubPEM, err := encryption.GenerateKeys(2048)
+	require.NoError(t, err)
+	priv, err := encryption.ParsePrivateKey(privPEM)
+	require.NoError(t, err)
+	pub, err := encryption.ParsePublicKey(pubPEM)
+	require.NoError(t, err)
+
+	body := []byte(`{"id":"PollCount","type":"counter","delta":1}`)
+	encrypted, err := encryption.Encrypt(pub, body)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name      string
+		required  bool
+		encrypted bool
+		body      []byte
+		status    int
+	}{
+		{name: "encrypted", required: true, encrypted: true, body: encrypted, status: http.StatusOK},
+		{name: "plain", required: true, body: body, status: http.StatusUnsupportedMediaType},
+		{name: "no body", required: true, status: http.StatusOK},
+		{name: "plain allowed", body: body, status: http.StatusOK},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			e := echo.New()
+			e.Use(Decrypt(priv))
+			e.POST("/update/", func(ctx echo.Context) error {
+				return ctx.NoContent(http.StatusOK)
+			}, RequireEncryption(test.required))
+
+			req := httptest.NewRequest(http.MethodPost, "/update/", bytes.NewReader(test.body))
+			if test.encrypted {
+				req.Header.Set(encryption.HeaderEncryption, encryption.Scheme)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.status, rec.Code)
+			if test.status != http.StatusOK {
+				assert.Equal(t, "application/problem+json", rec.Header().Get(echo.HeaderContentType))
+			}
+		})
+	}
+}
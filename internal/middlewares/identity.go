@@ -0,0 +1,47 @@
+package middlewares
+
+import (
+	"crypto/x509"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lionslon/go-yapmetrics/internal/problem"
+)
+
+// ContextIdentity ключ echo.Context с идентификатором агента из клиентского сертификата
+const ContextIdentity = "identity"
+
+// CertIdentities сопоставляет клиентский сертификат с идентификатором агента
+type CertIdentities interface {
+	Identity(cert *x509.Certificate) (string, bool)
+}
+
+// ClientCert определяет агента по проверенному при mTLS сертификату.
+// Сертификат, субъект которого не сопоставлен ни одному агенту, получает 403
+func ClientCert(ids CertIdentities) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			state := ctx.Request().TLS
+			if state == nil || len(state.PeerCertificates) == 0 {
+				return next(ctx)
+			}
+			cert := state.PeerCertificates[0]
+			id, ok := ids.Identity(cert)
+			if !ok {
+				return problem.Write(ctx, http.StatusForbidden, problem.CodeForbidden, fmt.Sprintf("certificate subject %q is not mapped to an agent", cert.Subject))
+			}
+			ctx.Set(ContextIdentity, id)
+			return next(ctx)
+		}
+	}
+}
+
+// Identity возвращает идентификатор клиента: агента из сертификата или, если его нет, токена
+func Identity(ctx echo.Context) string {
+	if id, _ := ctx.Get(ContextIdentity).(string); id != "" {
+		return id
+	}
+	id, _ := ctx.Get(ContextTokenID).(string)
+	return id
+}
@@ -0,0 +1,54 @@
+package middlewares
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+// cnIdentities сопоставляет агентам только перечисленные CN
+type cnIdentities map[string]string
+
+func (m cnIdentities) Identity(cert *x509.Certificate) (string, bool) {
+	id, ok := m[cert.Subject.CommonName]
+	return id, ok
+}
+
+func TestClientCert(t *testing.T) {
+	ids := cnIdentities{"agent-1": "collector"}
+	testCases := []struct {
+		name   string
+		cn     string
+		status int
+		want   string
+	}{
+		{name: "mapped", cn: "agent-1", status: http.StatusOK, want: "collector"},
+		{name: "not mapped", cn: "agent-2", status: http.StatusForbidden},
+		{name: "no certificate", status: http.StatusOK},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			e := echo.New()
+			e.Use(ClientCert(ids))
+			e.GET("/", func(ctx echo.Context) error {
+				assert.Equal(t, test.want, Identity(ctx))
+				return ctx.NoContent(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.cn != "" {
+				req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: test.cn}}}}
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.status, rec.Code)
+		})
+	}
+}
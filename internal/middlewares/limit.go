@@ -0,0 +1,31 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lionslon/go-yapmetrics/internal/problem"
+)
+
+// LimitBody ограничивает размер тела запроса. Запрос с заведомо большим Content-Length
+// отклоняется сразу, в остальных случаях чтение сверх лимита завершается ошибкой *http.MaxBytesError.
+// Middleware ограничивает то тело, которое видит в момент вызова, поэтому перед распаковкой
+// оно ограничивает сжатые данные, а после нее — распакованные
+func LimitBody(limit int64) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			if limit <= 0 {
+				return next(ctx)
+			}
+			req := ctx.Request()
+			if req.ContentLength > limit {
+				return problem.Write(ctx, http.StatusRequestEntityTooLarge, problem.CodeBodyTooLarge, fmt.Sprintf("request body is larger than %d bytes", limit))
+			}
+			if req.Body != nil {
+				req.Body = http.MaxBytesReader(ctx.Response(), req.Body, limit)
+			}
+			return next(ctx)
+		}
+	}
+}
@@ -25,6 +25,7 @@ func WithLogging() echo.MiddlewareFunc {
 				"duration:", duration,
 				"status:", res.Status,
 				"size:", res.Size,
+				"identity:", Identity(ctx),
 			)
 
 			return err
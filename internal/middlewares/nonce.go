@@ -0,0 +1,75 @@
+package middlewares
+
+import (
+	"container/list"
+	"errors"
+	"sync"
+	"time"
+)
+
+// nonceEntry запись о nonce и времени, до которого он считается использованным
+type nonceEntry struct {
+	nonce   string
+	expires time.Time
+}
+
+// ErrNonceCacheFull кеш заполнен еще действующими nonce. Вытеснить их нельзя: вытесненный nonce
+// можно было бы повторить, поэтому новые запросы отклоняются, пока старые записи не истекут
+var ErrNonceCacheFull = errors.New("nonce cache is full")
+
+// NonceCache помнит недавно использованные nonce. Размер кеша ограничен, размер стоит выбирать
+// не меньше числа запросов за время хранения nonce, иначе часть запросов будет отклонена
+type NonceCache struct {
+	mu    sync.Mutex
+	ttl   time.Duration
+	size  int
+	items map[string]*list.Element
+	order *list.List
+}
+
+// NewNonceCache создает кеш на size записей, каждая хранится ttl
+func NewNonceCache(size int, ttl time.Duration) *NonceCache {
+	if size <= 0 {
+		size = 1
+	}
+	return &NonceCache{
+		ttl:   ttl,
+		size:  size,
+		items: make(map[string]*list.Element),
+		order: list.New(),
+	}
+}
+
+// Seen возвращает true, если nonce уже встречался и еще не истек, иначе запоминает его.
+// Если места для нового nonce нет, возвращает ErrNonceCacheFull
+func (c *NonceCache) Seen(nonce string, now time.Time) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// записи добавляются с одинаковым ttl, поэтому в начале списка всегда самые старые
+	for e := c.order.Front(); e != nil; e = c.order.Front() {
+		entry := e.Value.(nonceEntry)
+		if entry.expires.After(now) {
+			break
+		}
+		c.order.Remove(e)
+		delete(c.items, entry.nonce)
+	}
+
+	if _, ok := c.items[nonce]; ok {
+		return true, nil
+	}
+	// истекшие записи уже удалены, в кеше остались только действующие
+	if c.order.Len() >= c.size {
+		return false, ErrNonceCacheFull
+	}
+	c.items[nonce] = c.order.PushBack(nonceEntry{nonce: nonce, expires: now.Add(c.ttl)})
+	return false, nil
+}
+
+// Len возвращает число запомненных nonce
+func (c *NonceCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
@@ -0,0 +1,36 @@
+package middlewares
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNonceCache(t *testing.T) {
+	now := time.Now()
+	c := NewNonceCache(3, time.Minute)
+
+	seen := func(nonce string, at time.Time) bool {
+		t.Helper()
+		ok, err := c.Seen(nonce, at)
+		assert.NoError(t, err)
+		return ok
+	}
+	assert.False(t, seen("a", now))
+	assert.True(t, seen("a", now.Add(time.Second)))
+	assert.False(t, seen("b", now))
+	assert.False(t, seen("a", now.Add(time.Minute)), "expired nonce is forgotten")
+
+	later := now.Add(time.Minute)
+	for i := 0; i < 2; i++ {
+		assert.False(t, seen(fmt.Sprint(i), later))
+	}
+	_, err := c.Seen("overflow", later)
+	assert.ErrorIs(t, err, ErrNonceCacheFull)
+	assert.Equal(t, 3, c.Len(), "cache is bounded")
+	assert.True(t, seen("a", later), "live nonce is not evicted when the cache is full")
+
+	assert.False(t, seen("overflow", later.Add(time.Minute)), "room is freed once entries expire")
+}
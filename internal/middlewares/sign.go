@@ -5,29 +5,186 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/hex"
+	"fmt"
 	"github.com/labstack/echo/v4"
-	"hash"
+	"github.com/lionslon/go-yapmetrics/internal/problem"
 	"io"
 	"net/http"
+	"strconv"
+	"time"
 )
 
-// CheckSignReq проверяет хеш из заголовков
-func CheckSignReq(password string) echo.MiddlewareFunc {
+// Режимы проверки подписи запросов
+const (
+	// SignStrict отклоняет неподписанные запросы с телом
+	SignStrict = "strict"
+	// SignPermissive пропускает запросы без подписи, режим для постепенного перевода агентов на ключ
+	SignPermissive = "permissive"
+)
+
+// Заголовки защиты от повтора запросов, они входят в подписываемые данные
+const (
+	HeaderSignTimestamp = "X-Sign-Timestamp"
+	HeaderSignNonce     = "X-Sign-Nonce"
+)
+
+// HeaderKeyID заголовок с идентификатором ключа подписи агента
+const HeaderKeyID = "Key-Id"
+
+// ContextKeyID ключ echo.Context, под которым сохраняется идентификатор ключа проверенного запроса
+const ContextKeyID = "signKeyID"
+
+// KeyResolver возвращает секрет подписи по идентификатору ключа,
+// пустой идентификатор означает ключ по умолчанию
+type KeyResolver interface {
+	Key(id string) ([]byte, bool)
+}
+
+// SignConfig настройки проверки подписи запросов
+type SignConfig struct {
+	// Key единственный ключ, используется, если не задан Keys
+	Key string
+	// Keys набор ключей по идентификаторам из заголовка Key-Id
+	Keys KeyResolver
+	Mode string
+	// ReplayWindow допустимое расхождение X-Sign-Timestamp с часами сервера, 0 отключает защиту от повтора
+	ReplayWindow time.Duration
+	// Nonces недавно использованные nonce, обязателен при ReplayWindow > 0
+	Nonces *NonceCache
+	// OnReject вызывается для каждого отклоненного запроса
+	OnReject func(reason string)
+}
+
+// key возвращает секрет подписи по идентификатору ключа
+func (c SignConfig) key(id string) ([]byte, bool) {
+	if c.Keys != nil {
+		return c.Keys.Key(id)
+	}
+	if id == "" && c.Key != "" {
+		return []byte(c.Key), true
+	}
+	return nil, false
+}
+
+// SignMaterial возвращает подписываемые данные: при наличии метки времени и nonce
+// им предшествуют метод и URI запроса, а тело идет последним, иначе подписывается только тело
+func SignMaterial(method string, requestURI string, body []byte, timestamp string, nonce string) []byte {
+	if timestamp == "" && nonce == "" {
+		return body
+	}
+	material := make([]byte, 0, len(method)+len(requestURI)+len(timestamp)+len(nonce)+len(body)+4)
+	for _, part := range []string{method, requestURI, timestamp, nonce} {
+		material = append(material, part...)
+		material = append(material, '\n')
+	}
+	return append(material, body...)
+}
+
+// requestURI URI запроса в том виде, в котором его отправил клиент
+func requestURI(req *http.Request) string {
+	if req.RequestURI != "" {
+		return req.RequestURI
+	}
+	return req.URL.RequestURI()
+}
+
+// validNonce проверяет формат nonce
+func validNonce(nonce string) bool {
+	if len(nonce) < 16 || len(nonce) > 128 {
+		return false
+	}
+	for _, r := range nonce {
+		if !(r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r == '-' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// safeMethod запросы только на чтение, их подпись в строгом режиме не требуется
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// CheckSignReq проверяет хеш из заголовков.
+// В строгом режиме запросы на изменение без подписи или с некорректной подписью получают 401,
+// в мягком режиме запросы без подписи пропускаются, а неверная подпись отклоняется с 400
+func CheckSignReq(cfg SignConfig) echo.MiddlewareFunc {
+	strict := cfg.Mode != SignPermissive
+	reject := func(ctx echo.Context, reason string) error {
+		if cfg.OnReject != nil {
+			cfg.OnReject(reason)
+		}
+		status := http.StatusBadRequest
+		if strict {
+			status = http.StatusUnauthorized
+		}
+		return problem.Write(ctx, status, problem.CodeInvalidSignature, reason)
+	}
+
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(ctx echo.Context) (err error) {
 			req := ctx.Request()
 			signR := req.Header.Get("HashSHA256")
 			if signR == "" {
-				return next(ctx)
+				if !strict || safeMethod(req.Method) {
+					return next(ctx)
+				}
+				return reject(ctx, "request is not signed")
+			}
+			if len(signR) != hex.EncodedLen(sha256.Size) {
+				return reject(ctx, "signature is malformed")
+			}
+			keyID := req.Header.Get(HeaderKeyID)
+			key, ok := cfg.key(keyID)
+			if !ok {
+				return reject(ctx, fmt.Sprintf("key %q is unknown or revoked", keyID))
+			}
+
+			var body []byte
+			if req.Body != nil {
+				body, err = io.ReadAll(req.Body)
+				if p := problem.FromBodyError(err); p != nil {
+					return problem.Send(ctx, p)
+				}
+				if err != nil {
+					return problem.Write(ctx, http.StatusBadRequest, problem.CodeInvalidEncoding, fmt.Sprintf("failed to read request body: %s", err))
+				}
 			}
-			body, err := io.ReadAll(req.Body)
-			if err == nil {
-				singPassword := []byte(password)
-				bodyHash := GetSign(body, singPassword)
-				if signR != bodyHash {
-					return ctx.JSON(http.StatusBadRequest, map[string]string{"error": "signature is not valid"})
+			timestamp := req.Header.Get(HeaderSignTimestamp)
+			nonce := req.Header.Get(HeaderSignNonce)
+			if !VerifySign(SignMaterial(req.Method, requestURI(req), body, timestamp, nonce), key, signR) {
+				return reject(ctx, "signature is not valid")
+			}
+
+			if cfg.ReplayWindow > 0 {
+				if timestamp == "" && nonce == "" {
+					if strict && !safeMethod(req.Method) {
+						return reject(ctx, "request has no timestamp and nonce")
+					}
+				} else {
+					now := time.Now()
+					ts, err := strconv.ParseInt(timestamp, 10, 64)
+					if err != nil || !validNonce(nonce) {
+						return reject(ctx, "timestamp or nonce is malformed")
+					}
+					if skew := now.Sub(time.Unix(ts, 0)); skew > cfg.ReplayWindow || skew < -cfg.ReplayWindow {
+						return reject(ctx, "request timestamp is outside the allowed window")
+					}
+					seen, err := cfg.Nonces.Seen(nonce, now)
+					if err != nil {
+						if cfg.OnReject != nil {
+							cfg.OnReject(err.Error())
+						}
+						return problem.Write(ctx, http.StatusTooManyRequests, problem.CodeTooManyRequests, err.Error())
+					}
+					if seen {
+						return reject(ctx, "nonce was already used")
+					}
 				}
 			}
+
+			ctx.Set(ContextKeyID, keyID)
 			req.Body = io.NopCloser(bytes.NewReader(body))
 			return next(ctx)
 		}
@@ -42,16 +199,74 @@ func GetSign(body []byte, pass []byte) string {
 	return hex.EncodeToString(sum)
 }
 
+// VerifySign проверяет подпись sign тела body за постоянное время
+func VerifySign(body []byte, pass []byte, sign string) bool {
+	got, err := hex.DecodeString(sign)
+	if err != nil {
+		return false
+	}
+	hashValue := hmac.New(sha256.New, pass)
+	hashValue.Write(body)
+	return hmac.Equal(got, hashValue.Sum(nil))
+}
+
 // signResponseWriter реализует интерфейс http.ResponseWriter и позволяет прозрачно для сервера
-// работать с хешом запросов
+// подписывать ответы: тело копится в буфере, а заголовок HashSHA256 выставляется перед отправкой
 type signResponseWriter struct {
 	http.ResponseWriter
-	hash hash.Hash
+	key    []byte
+	buf    bytes.Buffer
+	status int
 }
 
-// Write вставляет хеш в заголовок
-func (w signResponseWriter) Write(b []byte) (int, error) {
-	w.hash.Write(b)
-	w.Header().Set("HashSHA256", hex.EncodeToString(w.hash.Sum(nil)))
-	return w.ResponseWriter.Write(b)
+// WriteHeader откладывает отправку статуса до вычисления подписи
+func (w *signResponseWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+}
+
+// Write копит тело ответа
+func (w *signResponseWriter) Write(b []byte) (int, error) {
+	return w.buf.Write(b)
+}
+
+// Close вставляет хеш в заголовок и отправляет ответ
+func (w *signResponseWriter) Close() error {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.Header().Set("HashSHA256", GetSign(w.buf.Bytes(), w.key))
+	w.ResponseWriter.WriteHeader(w.status)
+	_, err := w.ResponseWriter.Write(w.buf.Bytes())
+	return err
+}
+
+// SignResponse подписывает тело ответа HMAC-SHA256 в заголовке HashSHA256 тем же ключом,
+// которым агент подписал запрос (заголовок Key-Id). Подписываются несжатые данные,
+// поэтому middleware должен стоять после Compress
+func SignResponse(cfg SignConfig) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			key, ok := cfg.key(ctx.Request().Header.Get(HeaderKeyID))
+			if !ok {
+				return next(ctx)
+			}
+			res := ctx.Response()
+			sw := &signResponseWriter{ResponseWriter: res.Writer, key: key}
+			res.Writer = sw
+			defer func() {
+				if err := sw.Close(); err != nil {
+					ctx.Logger().Error(err)
+				}
+				res.Writer = sw.ResponseWriter
+			}()
+
+			if err := next(ctx); err != nil {
+				// ошибку отрисовываем до отправки, чтобы она тоже была подписана
+				ctx.Error(err)
+			}
+			return nil
+		}
+	}
 }
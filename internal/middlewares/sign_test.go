@@ -0,0 +1,267 @@
+package middlewares
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSignResponse(t *testing.T) {
+	const key = "secret"
+	long := strings.Repeat(`{"id":"Alloc","type":"gauge","value":1}`, 20)
+
+	e := echo.New()
+	e.Use(Compress(CompressConfig{MinLength: 256}))
+	e.Use(SignResponse(SignConfig{Key: key}))
+	e.GET("/long", func(ctx echo.Context) error { return ctx.String(http.StatusOK, long) })
+	e.GET("/error", func(ctx echo.Context) error { return echo.NewHTTPError(http.StatusTeapot, "teapot") })
+
+	testCases := []struct {
+		name   string
+		target string
+		accept string
+		status int
+	}{
+		{name: "plain", target: "/long", status: http.StatusOK},
+		{name: "gzip", target: "/long", accept: "gzip", status: http.StatusOK},
+		{name: "error", target: "/error", status: http.StatusTeapot},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, test.target, nil)
+			if test.accept != "" {
+				req.Header.Set(echo.HeaderAcceptEncoding, test.accept)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			require.Equal(t, test.status, rec.Code)
+
+			var body io.Reader = rec.Body
+			if rec.Header().Get(echo.HeaderContentEncoding) == "gzip" {
+				zr, err := gzip.NewReader(body)
+				require.NoError(t, err)
+				body = zr
+			}
+			got, err := io.ReadAll(body)
+			require.NoError(t, err)
+			assert.NotEmpty(t, got)
+			assert.True(t, VerifySign(got, []byte(key), rec.Header().Get("HashSHA256")))
+		})
+	}
+}
+
+func TestCheckSignReq(t *testing.T) {
+	const key = "secret"
+	body := `{"id":"PollCount","type":"counter","delta":1}`
+	valid := GetSign([]byte(body), []byte(key))
+
+	testCases := []struct {
+		name     string
+		mode     string
+		method   string
+		sign     string
+		status   int
+		rejected int
+	}{
+		{name: "strict: valid", mode: SignStrict, method: http.MethodPost, sign: valid, status: http.StatusOK},
+		{name: "strict: unsigned", mode: SignStrict, method: http.MethodPost, status: http.StatusUnauthorized, rejected: 1},
+		{name: "strict: malformed", mode: SignStrict, method: http.MethodPost, sign: "abc", status: http.StatusUnauthorized, rejected: 1},
+		{name: "strict: not hex", mode: SignStrict, method: http.MethodPost, sign: strings.Repeat("z", 64), status: http.StatusUnauthorized, rejected: 1},
+		{name: "strict: wrong key", mode: SignStrict, method: http.MethodPost, sign: GetSign([]byte(body), []byte("other")), status: http.StatusUnauthorized, rejected: 1},
+		{name: "strict: unsigned read", mode: SignStrict, method: http.MethodGet, status: http.StatusOK},
+		{name: "unknown mode is strict", mode: "", method: http.MethodPost, status: http.StatusUnauthorized, rejected: 1},
+		{name: "permissive: valid", mode: SignPermissive, method: http.MethodPost, sign: valid, status: http.StatusOK},
+		{name: "permissive: unsigned", mode: SignPermissive, method: http.MethodPost, status: http.StatusOK},
+		{name: "permissive: wrong key", mode: SignPermissive, method: http.MethodPost, sign: GetSign([]byte(body), []byte("other")), status: http.StatusBadRequest, rejected: 1},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			rejected := 0
+			e := echo.New()
+			e.Use(CheckSignReq(SignConfig{Key: key, Mode: test.mode, OnReject: func(string) { rejected++ }}))
+			e.Any("/", func(ctx echo.Context) error {
+				got, err := io.ReadAll(ctx.Request().Body)
+				require.NoError(t, err)
+				if test.method == http.MethodPost {
+					assert.Equal(t, body, string(got))
+				}
+				return ctx.NoContent(http.StatusOK)
+			})
+
+			req := httptest.NewRequest(test.method, "/", strings.NewReader(body))
+			if test.sign != "" {
+				req.Header.Set("HashSHA256", test.sign)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.status, rec.Code)
+			assert.Equal(t, test.rejected, rejected)
+		})
+	}
+}
+
+func TestCheckSignReqReplay(t *testing.T) {
+	const key = "secret"
+	body := `{"id":"PollCount","type":"counter","delta":1}`
+	now := time.Now()
+	sign := func(ts time.Time, nonce string) (string, string, string) {
+		timestamp := strconv.FormatInt(ts.Unix(), 10)
+		return GetSign(SignMaterial(http.MethodPost, "/", []byte(body), timestamp, nonce), []byte(key)), timestamp, nonce
+	}
+
+	e := echo.New()
+	e.Use(CheckSignReq(SignConfig{Key: key, Mode: SignStrict, ReplayWindow: time.Minute, Nonces: NewNonceCache(100, 2*time.Minute)}))
+	e.POST("/", func(ctx echo.Context) error { return ctx.NoContent(http.StatusOK) })
+
+	testCases := []struct {
+		name   string
+		sign   func() (string, string, string)
+		status int
+	}{
+		{name: "fresh", sign: func() (string, string, string) { return sign(now, "0123456789abcdef") }, status: http.StatusOK},
+		{name: "replayed", sign: func() (string, string, string) { return sign(now, "0123456789abcdef") }, status: http.StatusUnauthorized},
+		{name: "another nonce", sign: func() (string, string, string) { return sign(now, "fedcba9876543210") }, status: http.StatusOK},
+		{name: "too old", sign: func() (string, string, string) { return sign(now.Add(-2*time.Minute), "aaaaaaaaaaaaaaaa") }, status: http.StatusUnauthorized},
+		{name: "from the future", sign: func() (string, string, string) { return sign(now.Add(2*time.Minute), "bbbbbbbbbbbbbbbb") }, status: http.StatusUnauthorized},
+		{name: "short nonce", sign: func() (string, string, string) { return sign(now, "abc") }, status: http.StatusUnauthorized},
+		{name: "timestamp not signed", sign: func() (string, string, string) {
+			s, _, n := sign(now, "cccccccccccccccc")
+			return s, strconv.FormatInt(now.Unix()+1, 10), n
+		}, status: http.StatusUnauthorized},
+		{name: "legacy body signature", sign: func() (string, string, string) { return GetSign([]byte(body), []byte(key)), "", "" }, status: http.StatusUnauthorized},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			s, ts, nonce := test.sign()
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+			req.Header.Set("HashSHA256", s)
+			if ts != "" {
+				req.Header.Set(HeaderSignTimestamp, ts)
+				req.Header.Set(HeaderSignNonce, nonce)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			assert.Equal(t, test.status, rec.Code)
+		})
+	}
+}
+
+func TestCheckSignReqReplayFullCache(t *testing.T) {
+	const key = "secret"
+	body := `{"id":"PollCount","type":"counter","delta":1}`
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+
+	rejected := 0
+	e := echo.New()
+	e.Use(CheckSignReq(SignConfig{Key: key, Mode: SignStrict, ReplayWindow: time.Minute, Nonces: NewNonceCache(2, 2*time.Minute),
+		OnReject: func(string) { rejected++ }}))
+	e.POST("/", func(ctx echo.Context) error { return ctx.NoContent(http.StatusOK) })
+
+	send := func(nonce string) int {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		req.Header.Set("HashSHA256", GetSign(SignMaterial(http.MethodPost, "/", []byte(body), timestamp, nonce), []byte(key)))
+		req.Header.Set(HeaderSignTimestamp, timestamp)
+		req.Header.Set(HeaderSignNonce, nonce)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, send("aaaaaaaaaaaaaaaa"))
+	assert.Equal(t, http.StatusOK, send("bbbbbbbbbbbbbbbb"))
+	assert.Equal(t, http.StatusTooManyRequests, send("cccccccccccccccc"), "full cache fails closed")
+	assert.Equal(t, http.StatusUnauthorized, send("aaaaaaaaaaaaaaaa"), "replay is still detected")
+	assert.Equal(t, 2, rejected)
+}
+
+func TestCheckSignReqPath(t *testing.T) {
+	const key = "secret"
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	sign := GetSign(SignMaterial(http.MethodPost, "/update/counter/PollCount/1", nil, timestamp, "0123456789abcdef"), []byte(key))
+
+	e := echo.New()
+	e.Use(CheckSignReq(SignConfig{Key: key, Mode: SignStrict}))
+	e.Any("/update/:type/:name/:value", func(ctx echo.Context) error { return ctx.NoContent(http.StatusOK) })
+
+	testCases := []struct {
+		name   string
+		method string
+		target string
+		status int
+	}{
+		{name: "signed path", method: http.MethodPost, target: "/update/counter/PollCount/1", status: http.StatusOK},
+		{name: "other path", method: http.MethodPost, target: "/update/counter/PollCount/1000", status: http.StatusUnauthorized},
+		{name: "other query", method: http.MethodPost, target: "/update/counter/PollCount/1?x=1", status: http.StatusUnauthorized},
+		{name: "other method", method: http.MethodPut, target: "/update/counter/PollCount/1", status: http.StatusUnauthorized},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(test.method, test.target, nil)
+			req.Header.Set("HashSHA256", sign)
+			req.Header.Set(HeaderSignTimestamp, timestamp)
+			req.Header.Set(HeaderSignNonce, "0123456789abcdef")
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+			assert.Equal(t, test.status, rec.Code)
+		})
+	}
+}
+
+// mapKeys набор ключей для тестов
+type mapKeys map[string]string
+
+func (m mapKeys) Key(id string) ([]byte, bool) {
+	k, ok := m[id]
+	return []byte(k), ok
+}
+
+func TestCheckSignReqKeyID(t *testing.T) {
+	keys := mapKeys{"": "default", "agent-1": "first", "agent-2": "second"}
+	body := `{"id":"PollCount","type":"counter","delta":1}`
+
+	testCases := []struct {
+		name   string
+		keyID  string
+		key    string
+		status int
+	}{
+		{name: "default key", key: "default", status: http.StatusOK},
+		{name: "known id", keyID: "agent-1", key: "first", status: http.StatusOK},
+		{name: "key of another id", keyID: "agent-2", key: "first", status: http.StatusUnauthorized},
+		{name: "revoked id", keyID: "agent-0", key: "old", status: http.StatusUnauthorized},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			e := echo.New()
+			e.Use(SignResponse(SignConfig{Keys: keys}))
+			e.Use(CheckSignReq(SignConfig{Keys: keys, Mode: SignStrict}))
+			e.POST("/", func(ctx echo.Context) error {
+				assert.Equal(t, test.keyID, ctx.Get(ContextKeyID))
+				return ctx.String(http.StatusOK, "ok")
+			})
+
+			req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+			req.Header.Set("HashSHA256", GetSign([]byte(body), []byte(test.key)))
+			if test.keyID != "" {
+				req.Header.Set(HeaderKeyID, test.keyID)
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.status, rec.Code)
+			if test.status == http.StatusOK {
+				assert.True(t, VerifySign(rec.Body.Bytes(), []byte(test.key), rec.Header().Get("HashSHA256")))
+			}
+		})
+	}
+}
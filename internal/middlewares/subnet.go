@@ -0,0 +1,104 @@
+package middlewares
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lionslon/go-yapmetrics/internal/problem"
+)
+
+// Источники IP-адреса клиента для TrustedSubnet
+const (
+	// RealIPHeader адрес из заголовка X-Real-IP, если соединение пришло от доверенного прокси
+	RealIPHeader = "header"
+	// RealIPPeer адрес соединения
+	RealIPPeer = "peer"
+)
+
+// SubnetConfig настройки проверки доверенной подсети
+type SubnetConfig struct {
+	Subnets []*net.IPNet
+	Source  string
+	// Proxies подсети прокси, которым разрешено передавать адрес клиента в X-Real-IP
+	Proxies []*net.IPNet
+}
+
+// ParseSubnets разбирает список подсетей CIDR через запятую
+func ParseSubnets(s string) ([]*net.IPNet, error) {
+	var subnets []*net.IPNet
+	for _, cidr := range strings.Split(s, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
+		_, subnet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, err
+		}
+		subnets = append(subnets, subnet)
+	}
+	return subnets, nil
+}
+
+// contains сообщает, что адрес входит в одну из подсетей
+func contains(subnets []*net.IPNet, ip net.IP) bool {
+	for _, subnet := range subnets {
+		if subnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP возвращает адрес клиента: адрес соединения, а в режиме RealIPHeader
+// заголовок X-Real-IP, если соединение пришло от доверенного прокси
+func clientIP(req *http.Request, cfg SubnetConfig) net.IP {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		host = req.RemoteAddr
+	}
+	peer := net.ParseIP(host)
+	if cfg.Source != RealIPHeader || peer == nil || !contains(cfg.Proxies, peer) {
+		return peer
+	}
+	return net.ParseIP(strings.TrimSpace(req.Header.Get(echo.HeaderXRealIP)))
+}
+
+// IPExtractor определяет адрес клиента для echo.Context.RealIP так же, как TrustedSubnet:
+// X-Real-IP учитывается только в режиме RealIPHeader и только от доверенного прокси.
+// Если адрес определить нельзя, возвращается адрес соединения
+func IPExtractor(cfg SubnetConfig) echo.IPExtractor {
+	return func(req *http.Request) string {
+		if ip := clientIP(req, cfg); ip != nil {
+			return ip.String()
+		}
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			return req.RemoteAddr
+		}
+		return host
+	}
+}
+
+// TrustedSubnet пропускает только запросы клиентов из доверенных подсетей, остальные получают 403.
+// Пустой список подсетей отключает проверку
+func TrustedSubnet(cfg SubnetConfig) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(ctx echo.Context) error {
+			if len(cfg.Subnets) == 0 {
+				return next(ctx)
+			}
+			ip := clientIP(ctx.Request(), cfg)
+			if ip == nil {
+				return problem.Write(ctx, http.StatusForbidden, problem.CodeForbidden, "client IP is unknown")
+			}
+			if contains(cfg.Subnets, ip) {
+				return next(ctx)
+			}
+			return problem.Write(ctx, http.StatusForbidden, problem.CodeForbidden, fmt.Sprintf("client IP %s is not in the trusted subnet", ip))
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package middlewares
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTrustedSubnet(t *testing.T) {
+	subnets, err := ParseSubnets("192.168.1.0/24, 10.0.0.0/8")
+	require.NoError(t, err)
+	_, err = ParseSubnets("192.168.1.0")
+	assert.Error(t, err)
+	proxies, err := ParseSubnets("172.16.0.1/32")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name    string
+		subnets string
+		source  string
+		realIP  string
+		peer    string
+		status  int
+	}{
+		{name: "header from proxy", source: RealIPHeader, realIP: "192.168.1.15", peer: "172.16.0.1:5000", status: http.StatusOK},
+		{name: "header in second subnet", source: RealIPHeader, realIP: "10.1.2.3", peer: "172.16.0.1:5000", status: http.StatusOK},
+		{name: "header outside", source: RealIPHeader, realIP: "192.168.2.15", peer: "172.16.0.1:5000", status: http.StatusForbidden},
+		{name: "header from untrusted peer", source: RealIPHeader, realIP: "192.168.1.15", peer: "1.2.3.4:5000", status: http.StatusForbidden},
+		{name: "header ignored for untrusted peer", source: RealIPHeader, realIP: "1.2.3.4", peer: "192.168.1.15:5000", status: http.StatusOK},
+		{name: "no header", source: RealIPHeader, peer: "172.16.0.1:5000", status: http.StatusForbidden},
+		{name: "malformed header", source: RealIPHeader, realIP: "localhost", peer: "172.16.0.1:5000", status: http.StatusForbidden},
+		{name: "peer in subnet", source: RealIPPeer, realIP: "1.2.3.4", peer: "192.168.1.15:5000", status: http.StatusOK},
+		{name: "peer outside", source: RealIPPeer, realIP: "192.168.1.15", peer: "1.2.3.4:5000", status: http.StatusForbidden},
+		{name: "header ignored by default", realIP: "192.168.1.15", peer: "172.16.0.1:5000", status: http.StatusForbidden},
+		{name: "disabled", source: RealIPHeader, subnets: "-", realIP: "1.2.3.4", status: http.StatusOK},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := SubnetConfig{Subnets: subnets, Source: test.source, Proxies: proxies}
+			if test.subnets == "-" {
+				cfg.Subnets = nil
+			}
+			e := echo.New()
+			e.POST("/update/", func(ctx echo.Context) error {
+				return ctx.NoContent(http.StatusOK)
+			}, TrustedSubnet(cfg))
+
+			req := httptest.NewRequest(http.MethodPost, "/update/", nil)
+			if test.realIP != "" {
+				req.Header.Set(echo.HeaderXRealIP, test.realIP)
+			}
+			if test.peer != "" {
+				req.RemoteAddr = test.peer
+			}
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, req)
+
+			assert.Equal(t, test.status, rec.Code)
+		})
+	}
+}
+
+func TestIPExtractor(t *testing.T) {
+	proxies, err := ParseSubnets("172.16.0.1/32")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name   string
+		source string
+		realIP string
+		peer   string
+		want   string
+	}{
+		{name: "header from proxy", source: RealIPHeader, realIP: "192.168.1.15", peer: "172.16.0.1:5000", want: "192.168.1.15"},
+		{name: "header from untrusted peer", source: RealIPHeader, realIP: "192.168.1.15", peer: "1.2.3.4:5000", want: "1.2.3.4"},
+		{name: "header ignored by default", realIP: "192.168.1.15", peer: "172.16.0.1:5000", want: "172.16.0.1"},
+		{name: "malformed header from proxy", source: RealIPHeader, realIP: "localhost", peer: "172.16.0.1:5000", want: "172.16.0.1"},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.RemoteAddr = test.peer
+			req.Header.Set(echo.HeaderXRealIP, test.realIP)
+			assert.Equal(t, test.want, IPExtractor(SubnetConfig{Source: test.source, Proxies: proxies})(req))
+		})
+	}
+}
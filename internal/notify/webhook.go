@@ -0,0 +1,345 @@
+// Package notify доставляет уведомления об алертах во внешние системы через вебхуки.
+// Тело запроса строится по шаблону и подписывается HMAC-SHA256 в заголовке HashSHA256
+// вместе с методом, URI, меткой времени и nonce, как запросы агента. Неудачные после всех повторов доставки пишутся в dead-letter файл
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+	"github.com/lionslon/go-yapmetrics/internal/alerting"
+	"github.com/lionslon/go-yapmetrics/internal/middlewares"
+	"go.uber.org/zap"
+)
+
+// defaultTemplate тело уведомления по умолчанию
+const defaultTemplate = `{{json .}}`
+
+// Webhook настройки одного получателя
+type Webhook struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Template шаблон text/template тела запроса, результат должен быть JSON.
+	// Функция json экранирует значение, например {"text": {{json .Summary}}}
+	Template string `json:"template,omitempty"`
+	// GroupTemplate шаблон тела для группы алертов (alerting.Group). Если не задан,
+	// алерты группы отправляются по одному с шаблоном Template
+	GroupTemplate string            `json:"group_template,omitempty"`
+	Secret        string            `json:"secret,omitempty"`
+	Headers       map[string]string `json:"headers,omitempty"`
+	// States состояния, о которых нужно уведомлять, по умолчанию firing и resolved
+	States []string `json:"states,omitempty"`
+
+	tmpl      *template.Template
+	groupTmpl *template.Template
+}
+
+// wants сообщает, что получатель подписан на состояние
+func (w *Webhook) wants(state string) bool {
+	if len(w.States) == 0 {
+		return true
+	}
+	for _, s := range w.States {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// render строит тело уведомления об алерте или группе алертов
+func (w *Webhook) render(data any) ([]byte, error) {
+	tmpl := w.tmpl
+	if _, ok := data.(alerting.Group); ok {
+		tmpl = w.groupTmpl
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, errors.New("template produced invalid JSON")
+	}
+	return buf.Bytes(), nil
+}
+
+var funcs = template.FuncMap{
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+}
+
+// compile проверяет настройки и готовит шаблон
+func (w *Webhook) compile() error {
+	if w.Name == "" || w.URL == "" {
+		return errors.New("webhook name and url are required")
+	}
+	text := w.Template
+	if text == "" {
+		text = defaultTemplate
+	}
+	tmpl, err := template.New(w.Name).Funcs(funcs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return fmt.Errorf("webhook %q: %w", w.Name, err)
+	}
+	w.tmpl = tmpl
+	if w.GroupTemplate != "" {
+		w.groupTmpl, err = template.New(w.Name + "-group").Funcs(funcs).Option("missingkey=error").Parse(w.GroupTemplate)
+		if err != nil {
+			return fmt.Errorf("webhook %q group template: %w", w.Name, err)
+		}
+	}
+	return nil
+}
+
+// LoadWebhooks читает получателей из JSON-файла
+func LoadWebhooks(path string) ([]*Webhook, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var hooks []*Webhook
+	if err := json.Unmarshal(data, &hooks); err != nil {
+		return nil, fmt.Errorf("failed to parse webhooks %s: %w", path, err)
+	}
+	for _, h := range hooks {
+		if err := h.compile(); err != nil {
+			return nil, err
+		}
+	}
+	return hooks, nil
+}
+
+// Config настройки доставки
+type Config struct {
+	// Retries число повторов с экспоненциальной задержкой
+	Retries  int
+	RetryMin time.Duration
+	RetryMax time.Duration
+	Timeout  time.Duration
+	// DeadLetter файл JSON Lines для уведомлений, которые не удалось доставить
+	DeadLetter string
+	// QueueSize размер очереди, при переполнении уведомление сразу уходит в dead-letter
+	QueueSize int
+}
+
+// deadLetter запись о недоставленном уведомлении
+type deadLetter struct {
+	Time    time.Time       `json:"time"`
+	Webhook string          `json:"webhook"`
+	URL     string          `json:"url"`
+	Error   string          `json:"error"`
+	Body    json.RawMessage `json:"body,omitempty"`
+	Alert   *alerting.Alert `json:"alert,omitempty"`
+	Group   *alerting.Group `json:"group,omitempty"`
+}
+
+// job уведомление одному получателю, data это alerting.Alert или alerting.Group
+type job struct {
+	hook *Webhook
+	data any
+}
+
+// Dispatcher асинхронно рассылает уведомления, реализует alerting.Notifier и alerting.GroupNotifier
+type Dispatcher struct {
+	hooks  []*Webhook
+	cfg    Config
+	client *retryablehttp.Client
+	queue  chan job
+	done   chan struct{}
+	mu     sync.Mutex // dead-letter файл
+}
+
+// NewDispatcher создает рассыльщик и запускает его обработчик очереди
+func NewDispatcher(hooks []*Webhook, cfg Config) *Dispatcher {
+	if cfg.QueueSize <= 0 {
+		cfg.QueueSize = 1000
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	client := retryablehttp.NewClient()
+	client.Logger = nil
+	client.RetryMax = cfg.Retries
+	if cfg.RetryMin > 0 {
+		client.RetryWaitMin = cfg.RetryMin
+	}
+	if cfg.RetryMax > 0 {
+		client.RetryWaitMax = cfg.RetryMax
+	}
+	client.HTTPClient.Timeout = cfg.Timeout
+	client.RequestLogHook = signAttempt
+	d := &Dispatcher{
+		hooks:  hooks,
+		cfg:    cfg,
+		client: client,
+		queue:  make(chan job, cfg.QueueSize),
+		done:   make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+// Notify ставит уведомление в очередь для всех подписанных получателей
+func (d *Dispatcher) Notify(a alerting.Alert) {
+	for _, h := range d.hooks {
+		if !h.wants(a.State) {
+			continue
+		}
+		d.enqueue(job{hook: h, data: a})
+	}
+}
+
+// NotifyGroup ставит в очередь уведомление о группе: одно на получателя с GroupTemplate,
+// иначе по одному на каждый алерт группы
+func (d *Dispatcher) NotifyGroup(g alerting.Group) {
+	for _, h := range d.hooks {
+		var alerts []alerting.Alert
+		for _, a := range g.Alerts {
+			if h.wants(a.State) {
+				alerts = append(alerts, a)
+			}
+		}
+		if len(alerts) == 0 {
+			continue
+		}
+		if h.groupTmpl == nil {
+			for _, a := range alerts {
+				d.enqueue(job{hook: h, data: a})
+			}
+			continue
+		}
+		d.enqueue(job{hook: h, data: alerting.Group{Key: g.Key, Labels: g.Labels, Alerts: alerts}})
+	}
+}
+
+func (d *Dispatcher) enqueue(j job) {
+	select {
+	case d.queue <- j:
+	default:
+		d.fail(j.hook, j.data, nil, errors.New("notification queue is full"))
+	}
+}
+
+// Close дожидается доставки уже поставленных в очередь уведомлений
+func (d *Dispatcher) Close() {
+	close(d.queue)
+	<-d.done
+}
+
+func (d *Dispatcher) run() {
+	defer close(d.done)
+	for j := range d.queue {
+		body, err := j.hook.render(j.data)
+		if err == nil {
+			err = d.deliver(j.hook, body)
+		}
+		if err != nil {
+			d.fail(j.hook, j.data, body, err)
+		}
+	}
+}
+
+// deliver отправляет уведомление с повторами, 4xx кроме 429 не повторяются
+func (d *Dispatcher) deliver(h *Webhook, body []byte) error {
+	ctx := context.Background()
+	if h.Secret != "" {
+		ctx = context.WithValue(ctx, signingKey{}, signing{secret: []byte(h.Secret), body: body})
+	}
+	req, err := retryablehttp.NewRequestWithContext(ctx, http.MethodPost, h.URL, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range h.Headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// signingKey ключ контекста запроса с секретом и телом для подписи
+type signingKey struct{}
+
+// signing секрет получателя и тело уведомления
+type signing struct {
+	secret []byte
+	body   []byte
+}
+
+// signAttempt подписывает каждую попытку доставки свежими меткой времени и nonce,
+// чтобы получатель мог отклонять повторы, а повторная доставка не выглядела повтором
+func signAttempt(_ retryablehttp.Logger, req *http.Request, _ int) {
+	sg, ok := req.Context().Value(signingKey{}).(signing)
+	if !ok {
+		return
+	}
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		zap.S().Errorf("failed to generate nonce: %v", err)
+		return
+	}
+	ts := strconv.FormatInt(time.Now().Unix(), 10)
+	n := hex.EncodeToString(nonce)
+	req.Header.Set(middlewares.HeaderSignTimestamp, ts)
+	req.Header.Set(middlewares.HeaderSignNonce, n)
+	req.Header.Set("HashSHA256", middlewares.GetSign(middlewares.SignMaterial(req.Method, req.URL.RequestURI(), sg.body, ts, n), sg.secret))
+}
+
+// fail записывает недоставленное уведомление в dead-letter файл
+func (d *Dispatcher) fail(h *Webhook, data any, body []byte, err error) {
+	rec := deadLetter{Time: time.Now(), Webhook: h.Name, URL: h.URL, Error: err.Error()}
+	switch v := data.(type) {
+	case alerting.Alert:
+		rec.Alert = &v
+		zap.S().Errorf("failed to deliver alert %s to webhook %s: %v", v.Rule, h.Name, err)
+	case alerting.Group:
+		rec.Group = &v
+		zap.S().Errorf("failed to deliver alert group %s to webhook %s: %v", v.Key, h.Name, err)
+	}
+	if d.cfg.DeadLetter == "" {
+		return
+	}
+	if json.Valid(body) {
+		rec.Body = body
+	}
+	line, mErr := json.Marshal(rec)
+	if mErr != nil {
+		zap.S().Error(mErr)
+		return
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	f, fErr := os.OpenFile(d.cfg.DeadLetter, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if fErr != nil {
+		zap.S().Error(fErr)
+		return
+	}
+	defer f.Close()
+	if _, fErr = f.Write(append(line, '\n')); fErr != nil {
+		zap.S().Error(fErr)
+	}
+}
@@ -0,0 +1,164 @@
+package notify
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lionslon/go-yapmetrics/internal/alerting"
+	"github.com/lionslon/go-yapmetrics/internal/middlewares"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDispatcher(t *testing.T) {
+	var mu sync.Mutex
+	var received []map[string]any
+	failures := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/chat":
+			ts, nonce := r.Header.Get(middlewares.HeaderSignTimestamp), r.Header.Get(middlewares.HeaderSignNonce)
+			assert.NotEmpty(t, ts)
+			assert.Len(t, nonce, 32)
+			assert.True(t, middlewares.VerifySign(middlewares.SignMaterial(r.Method, r.URL.RequestURI(), body, ts, nonce), []byte("secret"), r.Header.Get("HashSHA256")))
+			assert.Equal(t, "ops", r.Header.Get("X-Team"))
+			var msg map[string]any
+			require.NoError(t, json.Unmarshal(body, &msg))
+			received = append(received, msg)
+		case "/down":
+			failures++
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/rejects":
+			failures++
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer srv.Close()
+
+	dir := t.TempDir()
+	hooksPath := filepath.Join(dir, "webhooks.json")
+	hooks := []map[string]any{
+		{"name": "chat", "url": srv.URL + "/chat", "secret": "secret", "headers": map[string]string{"X-Team": "ops"},
+			"template": `{"text": {{json (printf "%s is %s" .Rule .State)}}, "value": {{.Value}}}`},
+		{"name": "oncall", "url": srv.URL + "/down", "states": []string{"firing"}},
+		{"name": "strict", "url": srv.URL + "/rejects", "states": []string{"resolved"}},
+	}
+	data, err := json.Marshal(hooks)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(hooksPath, data, 0o644))
+	loaded, err := LoadWebhooks(hooksPath)
+	require.NoError(t, err)
+
+	deadPath := filepath.Join(dir, "dead.jsonl")
+	d := NewDispatcher(loaded, Config{Retries: 2, RetryMin: time.Millisecond, RetryMax: time.Millisecond, DeadLetter: deadPath})
+	d.Notify(alerting.Alert{Rule: "HighHeap", State: alerting.StateFiring, Value: 150})
+	d.Notify(alerting.Alert{Rule: "HighHeap", State: alerting.StateResolved, Value: 50})
+	d.Close()
+
+	assert.Equal(t, []map[string]any{
+		{"text": "HighHeap is firing", "value": 150.0},
+		{"text": "HighHeap is resolved", "value": 50.0},
+	}, received)
+	assert.Equal(t, 3+1, failures, "5xx is retried, 4xx is not")
+
+	f, err := os.Open(deadPath)
+	require.NoError(t, err)
+	defer f.Close()
+	var dead []deadLetter
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var rec deadLetter
+		require.NoError(t, json.Unmarshal(scanner.Bytes(), &rec))
+		dead = append(dead, rec)
+	}
+	require.Len(t, dead, 2)
+	assert.Equal(t, "oncall", dead[0].Webhook)
+	assert.Equal(t, alerting.StateFiring, dead[0].Alert.State)
+	assert.Equal(t, "strict", dead[1].Webhook)
+	assert.JSONEq(t, `{"rule":"HighHeap","metric":"","type":"","state":"resolved","value":50,"active_at":"0001-01-01T00:00:00Z"}`, string(dead[1].Body))
+}
+
+func TestLoadWebhooksInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "webhooks.json")
+	for _, body := range []string{
+		`[{"name":"chat"}]`,
+		`[{"name":"chat","url":"http://localhost","template":"{{.Rule"}]`,
+		`{"name":"chat"}`,
+	} {
+		require.NoError(t, os.WriteFile(path, []byte(body), 0o644))
+		_, err := LoadWebhooks(path)
+		assert.Error(t, err, body)
+	}
+}
+
+func TestDispatcherGroup(t *testing.T) {
+	var mu sync.Mutex
+	received := map[string][]string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		mu.Lock()
+		defer mu.Unlock()
+		received[r.URL.Path] = append(received[r.URL.Path], string(body))
+	}))
+	defer srv.Close()
+
+	hooks := []*Webhook{
+		{Name: "digest", URL: srv.URL + "/digest", States: []string{"firing"},
+			GroupTemplate: `{"group": {{json .Key}}, "count": {{len .Alerts}}}`},
+		{Name: "plain", URL: srv.URL + "/plain", Template: `{"rule": {{json .Rule}}}`},
+	}
+	for _, h := range hooks {
+		require.NoError(t, h.compile())
+	}
+	d := NewDispatcher(hooks, Config{})
+	d.NotifyGroup(alerting.Group{Key: "severity=warning", Alerts: []alerting.Alert{
+		{Rule: "HighHeap", State: alerting.StateFiring},
+		{Rule: "HighGC", State: alerting.StateFiring},
+		{Rule: "LowDisk", State: alerting.StateResolved},
+	}})
+	d.Close()
+
+	require.Len(t, received["/digest"], 1)
+	assert.JSONEq(t, `{"group":"severity=warning","count":2}`, received["/digest"][0])
+	assert.Len(t, received["/plain"], 3)
+}
+
+func TestDispatcherSignsEachAttempt(t *testing.T) {
+	var mu sync.Mutex
+	var nonces []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		ts, nonce := r.Header.Get(middlewares.HeaderSignTimestamp), r.Header.Get(middlewares.HeaderSignNonce)
+		assert.True(t, middlewares.VerifySign(middlewares.SignMaterial(r.Method, r.URL.RequestURI(), body, ts, nonce), []byte("secret"), r.Header.Get("HashSHA256")))
+		mu.Lock()
+		defer mu.Unlock()
+		nonces = append(nonces, nonce)
+		if len(nonces) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer srv.Close()
+
+	hook := &Webhook{Name: "signed", URL: srv.URL + "/hook?team=ops", Secret: "secret"}
+	require.NoError(t, hook.compile())
+	d := NewDispatcher([]*Webhook{hook}, Config{Retries: 1, RetryMin: time.Millisecond, RetryMax: time.Millisecond})
+	d.Notify(alerting.Alert{Rule: "HighHeap", State: alerting.StateFiring})
+	d.Close()
+
+	require.Len(t, nonces, 2)
+	assert.NotEqual(t, nonces[0], nonces[1], "retry is signed with a fresh nonce")
+}
@@ -0,0 +1,143 @@
+// Package problem реализует единый формат ошибок API по RFC 7807 (application/problem+json)
+package problem
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ContentType тип содержимого ответа с ошибкой
+const ContentType = "application/problem+json"
+
+// typePrefix префикс URI типа ошибки, к нему добавляется код
+const typePrefix = "urn:yapmetrics:problem:"
+
+// Стабильные коды ошибок, на которые могут опираться клиенты
+const (
+	CodeInvalidJSON         = "invalid_json"
+	CodeInvalidMetricType   = "invalid_metric_type"
+	CodeInvalidMetricID     = "invalid_metric_id"
+	CodeInvalidValue        = "invalid_value"
+	CodeMissingDelta        = "missing_delta"
+	CodeMissingValue        = "missing_value"
+	CodeUnexpectedField     = "unexpected_field"
+	CodeMetricNotFound      = "metric_not_found"
+	CodeNotEnoughSamples    = "not_enough_samples"
+	CodeInvalidQuery        = "invalid_query"
+	CodeBatchTooLarge       = "batch_too_large"
+	CodeBodyTooLarge        = "body_too_large"
+	CodeInvalidEncoding     = "invalid_encoding"
+	CodeUnsupportedEncoding = "unsupported_encoding"
+	CodeInvalidSignature    = "invalid_signature"
+	CodeInvalidEncryption   = "invalid_encryption"
+	CodeUnauthorized        = "unauthorized"
+	CodeForbidden           = "forbidden"
+	CodeNotFound            = "not_found"
+	CodeConflict            = "conflict"
+	CodeMethodNotAllowed    = "method_not_allowed"
+	CodeTooManyRequests     = "too_many_requests"
+	CodeStorageUnavailable  = "storage_unavailable"
+	CodeInternal            = "internal_error"
+)
+
+// Problem описание ошибки
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     string `json:"code"`
+}
+
+// New создает описание ошибки с указанными статусом, кодом и подробностями
+func New(status int, code string, detail string) *Problem {
+	return &Problem{
+		Type:   typePrefix + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// Error реализует интерфейс error
+func (p *Problem) Error() string {
+	if p.Detail == "" {
+		return p.Code
+	}
+	return p.Code + ": " + p.Detail
+}
+
+// Write отправляет ошибку клиенту
+func Write(ctx echo.Context, status int, code string, detail string) error {
+	return Send(ctx, New(status, code, detail))
+}
+
+// Send отправляет готовое описание ошибки клиенту
+func Send(ctx echo.Context, p *Problem) error {
+	if p.Instance == "" {
+		p.Instance = ctx.Request().URL.Path
+	}
+	ctx.Response().Header().Set(echo.HeaderContentType, ContentType)
+	return ctx.JSON(p.Status, p)
+}
+
+// FromBodyError возвращает ошибку 413, если чтение тела запроса прервано из-за превышения лимита
+func FromBodyError(err error) *Problem {
+	var mbe *http.MaxBytesError
+	if errors.As(err, &mbe) {
+		return New(http.StatusRequestEntityTooLarge, CodeBodyTooLarge, fmt.Sprintf("request body is larger than %d bytes", mbe.Limit))
+	}
+	return nil
+}
+
+// HTTPErrorHandler заменяет стандартный обработчик ошибок echo,
+// чтобы ошибки маршрутизации тоже отдавались в формате problem+json
+func HTTPErrorHandler(err error, ctx echo.Context) {
+	if ctx.Response().Committed {
+		return
+	}
+
+	var p *Problem
+	var he *echo.HTTPError
+	switch {
+	case errors.As(err, &p):
+	case errors.As(err, &he):
+		p = New(he.Code, codeForStatus(he.Code), "")
+		if msg, ok := he.Message.(string); ok && msg != http.StatusText(he.Code) {
+			p.Detail = msg
+		}
+	default:
+		p = New(http.StatusInternalServerError, CodeInternal, "")
+	}
+
+	if ctx.Request().Method == http.MethodHead {
+		_ = ctx.NoContent(p.Status)
+		return
+	}
+	_ = Send(ctx, p)
+}
+
+// codeForStatus подбирает код ошибки для ошибок, которые формирует сам echo
+func codeForStatus(status int) string {
+	switch status {
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusBadRequest:
+		return CodeInvalidQuery
+	case http.StatusRequestEntityTooLarge:
+		return CodeBodyTooLarge
+	default:
+		return CodeInternal
+	}
+}
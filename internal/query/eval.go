@@ -0,0 +1,388 @@
+package query
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"math"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/lionslon/go-yapmetrics/internal/models"
+	"github.com/lionslon/go-yapmetrics/internal/storage"
+)
+
+// Типы результата
+const (
+	TypeScalar = "scalar"
+	TypeVector = "vector"
+)
+
+// Source текущие значения метрик и история counter-метрик
+type Source interface {
+	List() []models.Metrics
+	CounterRate(string, time.Duration, time.Time) (storage.Rate, bool)
+}
+
+// Series значение одной серии. Имя сохраняется у выбранных метрик и rate/increase,
+// арифметика и агрегация его отбрасывают
+type Series struct {
+	Name   string            `json:"name,omitempty"`
+	Type   string            `json:"type,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+	Value  float64           `json:"value"`
+}
+
+// signature ключ сопоставления серий по меткам
+func (s Series) signature() string {
+	keys := make([]string, 0, len(s.Labels))
+	for k := range s.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s=%q,", k, s.Labels[k])
+	}
+	return b.String()
+}
+
+// Result результат вычисления: число или набор серий
+type Result struct {
+	Type   string
+	Scalar float64
+	Series []Series
+}
+
+// MarshalJSON записывает {"type":"scalar","value":1} или {"type":"vector","series":[...]}
+func (r Result) MarshalJSON() ([]byte, error) {
+	if r.Type == TypeScalar {
+		return json.Marshal(struct {
+			Type  string  `json:"type"`
+			Value float64 `json:"value"`
+		}{r.Type, r.Scalar})
+	}
+	series := r.Series
+	if series == nil {
+		series = []Series{}
+	}
+	return json.Marshal(struct {
+		Type   string   `json:"type"`
+		Series []Series `json:"series"`
+	}{r.Type, series})
+}
+
+// Eval вычисляет выражение на момент now. Серии с бесконечным значением или NaN
+// (например, после деления на ноль) в результат не попадают
+func Eval(e Expr, src Source, now time.Time) (Result, error) {
+	ev := &evaluator{src: src, now: now}
+	v, err := ev.eval(e)
+	if err != nil {
+		return Result{}, err
+	}
+	if v.scalar {
+		if !finite(v.value) {
+			return Result{}, fmt.Errorf("result %v is not a finite number", v.value)
+		}
+		return Result{Type: TypeScalar, Scalar: v.value}, nil
+	}
+	res := Result{Type: TypeVector, Series: make([]Series, 0, len(v.series))}
+	for _, s := range v.series {
+		if finite(s.Value) {
+			res.Series = append(res.Series, s)
+		}
+	}
+	sort.Slice(res.Series, func(i, j int) bool {
+		if res.Series[i].Name != res.Series[j].Name {
+			return res.Series[i].Name < res.Series[j].Name
+		}
+		if res.Series[i].Type != res.Series[j].Type {
+			return res.Series[i].Type < res.Series[j].Type
+		}
+		return res.Series[i].signature() < res.Series[j].signature()
+	})
+	return res, nil
+}
+
+func finite(v float64) bool {
+	return !math.IsNaN(v) && !math.IsInf(v, 0)
+}
+
+// value промежуточное значение
+type value struct {
+	scalar bool
+	value  float64
+	series []Series
+}
+
+type evaluator struct {
+	src     Source
+	now     time.Time
+	metrics []models.Metrics
+}
+
+// list читает метрики из источника один раз за вычисление
+func (ev *evaluator) list() []models.Metrics {
+	if ev.metrics == nil {
+		ev.metrics = ev.src.List()
+	}
+	return ev.metrics
+}
+
+func (ev *evaluator) eval(e Expr) (value, error) {
+	switch e := e.(type) {
+	case *NumberLiteral:
+		return value{scalar: true, value: e.Value}, nil
+	case *Selector:
+		return value{series: ev.selectSeries(e)}, nil
+	case *Call:
+		return ev.call(e), nil
+	case *Aggregate:
+		return ev.aggregate(e)
+	case *Unary:
+		arg, err := ev.eval(e.Arg)
+		if err != nil {
+			return value{}, err
+		}
+		return arithmetic("-", value{scalar: true}, arg)
+	case *Binary:
+		lhs, err := ev.eval(e.LHS)
+		if err != nil {
+			return value{}, err
+		}
+		rhs, err := ev.eval(e.RHS)
+		if err != nil {
+			return value{}, err
+		}
+		if _, ok := comparisons[e.Op]; ok {
+			return compare(e.Op, lhs, rhs)
+		}
+		return arithmetic(e.Op, lhs, rhs)
+	}
+	return value{}, fmt.Errorf("unsupported expression %T", e)
+}
+
+// selectSeries выбирает метрики, подходящие под селектор
+func (ev *evaluator) selectSeries(s *Selector) []Series {
+	var res []Series
+	for _, m := range ev.list() {
+		if s.Name != "" && m.ID != s.Name {
+			continue
+		}
+		if !matchAll(s.Matchers, m) {
+			continue
+		}
+		// метки копируются: источник может отдавать свои map, а результат изменяется дальше
+		series := Series{Name: m.ID, Type: m.MType, Labels: maps.Clone(m.Labels)}
+		switch {
+		case m.Delta != nil:
+			series.Value = float64(*m.Delta)
+		case m.Value != nil:
+			series.Value = *m.Value
+		}
+		res = append(res, series)
+	}
+	return res
+}
+
+func matchAll(matchers []*Matcher, m models.Metrics) bool {
+	for _, matcher := range matchers {
+		var v string
+		switch matcher.Label {
+		case LabelName:
+			v = m.ID
+		case LabelType:
+			v = m.MType
+		default:
+			v = m.Labels[matcher.Label]
+		}
+		if !matcher.matches(v) {
+			return false
+		}
+	}
+	return true
+}
+
+// call вычисляет rate или increase по истории выбранных counter-метрик,
+// метрики без достаточной истории пропускаются
+func (ev *evaluator) call(c *Call) value {
+	var res []Series
+	for _, s := range ev.selectSeries(c.Arg) {
+		if s.Type != "counter" {
+			continue
+		}
+		rate, ok := ev.src.CounterRate(s.Name, c.Arg.Window, ev.now)
+		if !ok {
+			continue
+		}
+		s.Value = rate.Rate
+		if c.Func == "increase" {
+			s.Value = rate.Increase
+		}
+		res = append(res, s)
+	}
+	return value{series: res}
+}
+
+// aggregate группирует серии по меткам By
+func (ev *evaluator) aggregate(a *Aggregate) (value, error) {
+	arg, err := ev.eval(a.Arg)
+	if err != nil {
+		return value{}, err
+	}
+	if arg.scalar {
+		return value{}, fmt.Errorf("%s expects series, got a number", a.Op)
+	}
+	type group struct {
+		series Series
+		count  int
+	}
+	groups := map[string]*group{}
+	var order []string
+	for _, s := range arg.series {
+		labels := map[string]string{}
+		for _, l := range a.By {
+			if v, ok := s.Labels[l]; ok {
+				labels[l] = v
+			}
+		}
+		key := Series{Labels: labels}.signature()
+		g, ok := groups[key]
+		if !ok {
+			g = &group{series: Series{Labels: labels, Value: s.Value}}
+			groups[key] = g
+			order = append(order, key)
+		} else {
+			switch a.Op {
+			case "sum", "avg":
+				g.series.Value += s.Value
+			case "min":
+				g.series.Value = math.Min(g.series.Value, s.Value)
+			case "max":
+				g.series.Value = math.Max(g.series.Value, s.Value)
+			}
+		}
+		g.count++
+	}
+	res := make([]Series, 0, len(groups))
+	for _, key := range order {
+		g := groups[key]
+		switch a.Op {
+		case "avg":
+			g.series.Value /= float64(g.count)
+		case "count":
+			g.series.Value = float64(g.count)
+		}
+		if len(g.series.Labels) == 0 {
+			g.series.Labels = nil
+		}
+		res = append(res, g.series)
+	}
+	return value{series: res}, nil
+}
+
+var operations = map[string]func(a, b float64) float64{
+	"+": func(a, b float64) float64 { return a + b },
+	"-": func(a, b float64) float64 { return a - b },
+	"*": func(a, b float64) float64 { return a * b },
+	"/": func(a, b float64) float64 { return a / b },
+}
+
+var comparisons = map[string]func(a, b float64) bool{
+	"==": func(a, b float64) bool { return a == b },
+	"!=": func(a, b float64) bool { return a != b },
+	">":  func(a, b float64) bool { return a > b },
+	">=": func(a, b float64) bool { return a >= b },
+	"<":  func(a, b float64) bool { return a < b },
+	"<=": func(a, b float64) bool { return a <= b },
+}
+
+// match сопоставляет серии двух наборов по меткам один к одному
+func match(lhs, rhs []Series) (map[string]Series, error) {
+	index := make(map[string]Series, len(rhs))
+	for _, s := range rhs {
+		sig := s.signature()
+		if _, dup := index[sig]; dup {
+			return nil, fmt.Errorf("several series on the right side have labels {%s}", strings.TrimSuffix(sig, ","))
+		}
+		index[sig] = s
+	}
+	seen := make(map[string]bool, len(lhs))
+	for _, s := range lhs {
+		sig := s.signature()
+		if seen[sig] {
+			return nil, fmt.Errorf("several series on the left side have labels {%s}", strings.TrimSuffix(sig, ","))
+		}
+		seen[sig] = true
+	}
+	return index, nil
+}
+
+// arithmetic применяет операцию к числам, к каждой серии или к сопоставленным парам серий
+func arithmetic(op string, lhs, rhs value) (value, error) {
+	fn := operations[op]
+	switch {
+	case lhs.scalar && rhs.scalar:
+		return value{scalar: true, value: fn(lhs.value, rhs.value)}, nil
+	case lhs.scalar:
+		res := make([]Series, 0, len(rhs.series))
+		for _, s := range rhs.series {
+			res = append(res, Series{Labels: s.Labels, Value: fn(lhs.value, s.Value)})
+		}
+		return value{series: res}, nil
+	case rhs.scalar:
+		res := make([]Series, 0, len(lhs.series))
+		for _, s := range lhs.series {
+			res = append(res, Series{Labels: s.Labels, Value: fn(s.Value, rhs.value)})
+		}
+		return value{series: res}, nil
+	}
+	index, err := match(lhs.series, rhs.series)
+	if err != nil {
+		return value{}, err
+	}
+	var res []Series
+	for _, s := range lhs.series {
+		if r, ok := index[s.signature()]; ok {
+			res = append(res, Series{Labels: s.Labels, Value: fn(s.Value, r.Value)})
+		}
+	}
+	return value{series: res}, nil
+}
+
+// compare оставляет серии, для которых выполняется сравнение, значения не меняются
+func compare(op string, lhs, rhs value) (value, error) {
+	fn := comparisons[op]
+	switch {
+	case lhs.scalar && rhs.scalar:
+		return value{}, fmt.Errorf("comparison %s needs series on at least one side", op)
+	case lhs.scalar:
+		var res []Series
+		for _, s := range rhs.series {
+			if fn(lhs.value, s.Value) {
+				res = append(res, s)
+			}
+		}
+		return value{series: res}, nil
+	case rhs.scalar:
+		var res []Series
+		for _, s := range lhs.series {
+			if fn(s.Value, rhs.value) {
+				res = append(res, s)
+			}
+		}
+		return value{series: res}, nil
+	}
+	index, err := match(lhs.series, rhs.series)
+	if err != nil {
+		return value{}, err
+	}
+	var res []Series
+	for _, s := range lhs.series {
+		if r, ok := index[s.signature()]; ok && fn(s.Value, r.Value) {
+			res = append(res, s)
+		}
+	}
+	return value{series: res}, nil
+}
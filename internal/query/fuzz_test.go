@@ -0,0 +1,64 @@
+package query
+
+import (
+	"testing"
+	"time"
+
+	"github.com/lionslon/go-yapmetrics/internal/models"
+	"github.com/lionslon/go-yapmetrics/internal/storage"
+)
+
+var fuzzSeeds = []string{
+	"HeapAlloc",
+	`{__name__=~"Heap.*", host!="b"}`,
+	"sum by (host) (HeapAlloc / HeapSys) * 100",
+	"avg(HeapAlloc) by (dc) > 10",
+	"rate(PollCount[5m]) < 0.1",
+	"increase({__type__=\"counter\"}[1h30m])",
+	"--(1 - 2) / .5",
+	"count(`a\\b`)",
+	"((",
+}
+
+// FuzzParse проверяет, что разбор не паникует, а разобранное выражение
+// записывается строкой, которая разбирается в то же выражение
+func FuzzParse(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s)
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		e, err := Parse(input)
+		if err != nil {
+			return
+		}
+		again, err := Parse(e.String())
+		if err != nil {
+			t.Fatalf("%q printed as %q does not parse: %v", input, e.String(), err)
+		}
+		if again.String() != e.String() {
+			t.Fatalf("%q printed as %q, then as %q", input, e.String(), again.String())
+		}
+	})
+}
+
+// FuzzEval проверяет, что вычисление любого разобранного выражения не паникует
+func FuzzEval(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add(s)
+	}
+	src := testSource{
+		metrics: []models.Metrics{
+			gauge("HeapAlloc", 100, map[string]string{"host": "a"}),
+			gauge("HeapSys", 0, map[string]string{"host": "a"}),
+			counter("PollCount", 42, nil),
+		},
+		rates: map[string]storage.Rate{"PollCount": {Increase: 6, Rate: 0.1}},
+	}
+	f.Fuzz(func(t *testing.T, input string) {
+		e, err := Parse(input)
+		if err != nil {
+			return
+		}
+		_, _ = Eval(e, src, time.Now())
+	})
+}
@@ -0,0 +1,184 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tokenKind вид лексемы
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokIdent
+	tokNumber
+	tokDuration
+	tokString
+	tokOp
+)
+
+// token лексема выражения, pos смещение в байтах от начала выражения
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+	num  float64
+	dur  time.Duration
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "end of expression"
+	}
+	return strconv.Quote(t.text)
+}
+
+// operators операторы и знаки препинания, двухсимвольные проверяются первыми
+var operators = []string{"==", "!=", "=~", "!~", ">=", "<=", "(", ")", "{", "}", "[", "]", ",", "+", "-", "*", "/", "=", ">", "<"}
+
+// Error ошибка разбора выражения с позицией
+type Error struct {
+	Pos int
+	Msg string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("at position %d: %s", e.Pos, e.Msg)
+}
+
+func errorf(pos int, format string, args ...any) *Error {
+	return &Error{Pos: pos, Msg: fmt.Sprintf(format, args...)}
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+// isIdentChar символы имени, как в имени метрики, кроме '-', который означает вычитание
+func isIdentChar(c byte) bool {
+	return isIdentStart(c) || (c >= '0' && c <= '9') || c == '.' || c == ':'
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// lex разбивает выражение на лексемы
+func lex(input string) ([]token, error) {
+	var tokens []token
+	i := 0
+	for i < len(input) {
+		c := input[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case isIdentStart(c):
+			start := i
+			for i < len(input) && isIdentChar(input[i]) {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokIdent, text: input[start:i], pos: start})
+		case isDigit(c) || (c == '.' && i+1 < len(input) && isDigit(input[i+1])):
+			t, next, err := lexNumber(input, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, t)
+			i = next
+		case c == '"' || c == '`':
+			t, next, err := lexString(input, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, t)
+			i = next
+		default:
+			op := ""
+			for _, o := range operators {
+				if strings.HasPrefix(input[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, errorf(i, "unexpected character %q", rune(c))
+			}
+			tokens = append(tokens, token{kind: tokOp, text: op, pos: i})
+			i += len(op)
+		}
+	}
+	return append(tokens, token{kind: tokEOF, pos: len(input)}), nil
+}
+
+// lexNumber читает число или длительность вида 5m, 1h30m
+func lexNumber(input string, start int) (token, int, error) {
+	i := start
+	for i < len(input) && (isDigit(input[i]) || input[i] == '.') {
+		i++
+	}
+	// экспонента 1e5, 2.5E-3: единиц длительности на e нет, поэтому путаницы с длительностью нет
+	if exp := exponentLen(input[i:]); exp > 0 {
+		i += exp
+		if i < len(input) && isIdentChar(input[i]) {
+			return token{}, 0, errorf(start, "invalid number %q", input[start:i+1])
+		}
+	}
+	if i < len(input) && isIdentStart(input[i]) {
+		for i < len(input) && (isIdentChar(input[i])) {
+			i++
+		}
+		text := input[start:i]
+		d, err := time.ParseDuration(text)
+		if err != nil || d <= 0 {
+			return token{}, 0, errorf(start, "invalid duration %q", text)
+		}
+		return token{kind: tokDuration, text: text, pos: start, dur: d}, i, nil
+	}
+	text := input[start:i]
+	v, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return token{}, 0, errorf(start, "invalid number %q", text)
+	}
+	return token{kind: tokNumber, text: text, pos: start, num: v}, i, nil
+}
+
+// exponentLen длина экспоненты числа в начале s, 0 если ее нет
+func exponentLen(s string) int {
+	if len(s) < 2 || (s[0] != 'e' && s[0] != 'E') {
+		return 0
+	}
+	i := 1
+	if s[i] == '+' || s[i] == '-' {
+		i++
+	}
+	if i >= len(s) || !isDigit(s[i]) {
+		return 0
+	}
+	for i < len(s) && isDigit(s[i]) {
+		i++
+	}
+	return i
+}
+
+// lexString читает строку в двойных кавычках с экранированием Go или в обратных кавычках без него
+func lexString(input string, start int) (token, int, error) {
+	quote := input[start]
+	i := start + 1
+	for i < len(input) && input[i] != quote {
+		if quote == '"' && input[i] == '\\' {
+			i++
+		}
+		i++
+	}
+	if i >= len(input) {
+		return token{}, 0, errorf(start, "unterminated string")
+	}
+	raw := input[start : i+1]
+	s, err := strconv.Unquote(raw)
+	if err != nil {
+		return token{}, 0, errorf(start, "invalid string %s", raw)
+	}
+	return token{kind: tokString, text: s, pos: start}, i + 1, nil
+}
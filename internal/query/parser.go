@@ -0,0 +1,419 @@
+package query
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxDepth ограничение вложенности выражения
+const maxDepth = 64
+
+// Метки выбора по имени и типу метрики
+const (
+	LabelName = "__name__"
+	LabelType = "__type__"
+)
+
+// Expr разобранное выражение
+type Expr interface {
+	String() string
+}
+
+// NumberLiteral числовая константа
+type NumberLiteral struct {
+	Value float64
+}
+
+func (n *NumberLiteral) String() string {
+	return strconv.FormatFloat(n.Value, 'f', -1, 64)
+}
+
+// Matcher условие на метку: =, !=, =~ или !~, регулярное выражение совпадает со всем значением
+type Matcher struct {
+	Label string
+	Op    string
+	Value string
+	re    *regexp.Regexp
+}
+
+func (m *Matcher) String() string {
+	return m.Label + m.Op + strconv.Quote(m.Value)
+}
+
+func (m *Matcher) matches(v string) bool {
+	switch m.Op {
+	case "=":
+		return v == m.Value
+	case "!=":
+		return v != m.Value
+	case "=~":
+		return m.re.MatchString(v)
+	default:
+		return !m.re.MatchString(v)
+	}
+}
+
+// Selector выбор метрик по имени и меткам, Window задается только внутри rate и increase
+type Selector struct {
+	Name     string
+	Matchers []*Matcher
+	Window   time.Duration
+}
+
+func (s *Selector) String() string {
+	var b strings.Builder
+	b.WriteString(s.Name)
+	if len(s.Matchers) > 0 || s.Name == "" {
+		parts := make([]string, len(s.Matchers))
+		for i, m := range s.Matchers {
+			parts[i] = m.String()
+		}
+		b.WriteString("{" + strings.Join(parts, ", ") + "}")
+	}
+	if s.Window > 0 {
+		b.WriteString("[" + s.Window.String() + "]")
+	}
+	return b.String()
+}
+
+// Call функция над counter-метриками: rate или increase
+type Call struct {
+	Func string
+	Arg  *Selector
+}
+
+func (c *Call) String() string {
+	return c.Func + "(" + c.Arg.String() + ")"
+}
+
+// Aggregate агрегация sum, avg, min, max или count с группировкой по меткам By
+type Aggregate struct {
+	Op  string
+	By  []string
+	Arg Expr
+}
+
+func (a *Aggregate) String() string {
+	if len(a.By) == 0 {
+		return a.Op + "(" + a.Arg.String() + ")"
+	}
+	return a.Op + " by (" + strings.Join(a.By, ", ") + ") (" + a.Arg.String() + ")"
+}
+
+// Unary смена знака
+type Unary struct {
+	Arg Expr
+}
+
+func (u *Unary) String() string {
+	if _, ok := u.Arg.(*Binary); ok {
+		return "-(" + u.Arg.String() + ")"
+	}
+	return "-" + u.Arg.String()
+}
+
+// Binary арифметика или сравнение. Сравнение оставляет серии, для которых оно выполняется
+type Binary struct {
+	Op  string
+	LHS Expr
+	RHS Expr
+}
+
+func (b *Binary) String() string {
+	lhs, rhs := b.LHS.String(), b.RHS.String()
+	if l, ok := b.LHS.(*Binary); ok && precedence[l.Op] < precedence[b.Op] {
+		lhs = "(" + lhs + ")"
+	}
+	if r, ok := b.RHS.(*Binary); ok && precedence[r.Op] <= precedence[b.Op] {
+		rhs = "(" + rhs + ")"
+	}
+	return lhs + " " + b.Op + " " + rhs
+}
+
+// precedence приоритет бинарных операторов, все левоассоциативны
+var precedence = map[string]int{
+	"==": 1, "!=": 1, ">": 1, ">=": 1, "<": 1, "<=": 1,
+	"+": 2, "-": 2,
+	"*": 3, "/": 3,
+}
+
+var aggregations = map[string]bool{"sum": true, "avg": true, "min": true, "max": true, "count": true}
+
+var functions = map[string]bool{"rate": true, "increase": true}
+
+// parser рекурсивный спуск по лексемам
+type parser struct {
+	tokens []token
+	pos    int
+	depth  int
+}
+
+// Parse разбирает выражение
+func Parse(input string) (Expr, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	e, err := p.parseBinary(1)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, errorf(t.pos, "unexpected %s", t)
+	}
+	return e, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// isOp проверяет, что следующая лексема оператор op
+func (p *parser) isOp(op string) bool {
+	t := p.peek()
+	return t.kind == tokOp && t.text == op
+}
+
+func (p *parser) expect(op string) error {
+	if t := p.next(); t.kind != tokOp || t.text != op {
+		return errorf(t.pos, "expected %q, got %s", op, t)
+	}
+	return nil
+}
+
+// enter учитывает вложенность, чтобы глубокие выражения не переполняли стек
+func (p *parser) enter() error {
+	p.depth++
+	if p.depth > maxDepth {
+		return errorf(p.peek().pos, "expression is nested deeper than %d", maxDepth)
+	}
+	return nil
+}
+
+// parseBinary разбирает бинарные операторы с приоритетом не ниже minPrec
+func (p *parser) parseBinary(minPrec int) (Expr, error) {
+	lhs, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		prec, ok := precedence[t.text]
+		if t.kind != tokOp || !ok || prec < minPrec {
+			return lhs, nil
+		}
+		p.next()
+		rhs, err := p.parseBinary(prec + 1)
+		if err != nil {
+			return nil, err
+		}
+		lhs = &Binary{Op: t.text, LHS: lhs, RHS: rhs}
+	}
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	if !p.isOp("-") {
+		return p.parsePrimary()
+	}
+	p.next()
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+	arg, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	return &Unary{Arg: arg}, nil
+}
+
+func (p *parser) parsePrimary() (Expr, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer func() { p.depth-- }()
+
+	t := p.peek()
+	switch {
+	case t.kind == tokNumber:
+		p.next()
+		return &NumberLiteral{Value: t.num}, nil
+	case t.kind == tokOp && t.text == "(":
+		p.next()
+		e, err := p.parseBinary(1)
+		if err != nil {
+			return nil, err
+		}
+		return e, p.expect(")")
+	case t.kind == tokOp && t.text == "{":
+		return p.parseInstant("")
+	case t.kind == tokIdent:
+		p.next()
+		following := p.peek()
+		if aggregations[t.text] && following.kind == tokOp && following.text == "(" ||
+			aggregations[t.text] && following.kind == tokIdent && following.text == "by" {
+			return p.parseAggregate(t.text)
+		}
+		if functions[t.text] && following.kind == tokOp && following.text == "(" {
+			return p.parseCall(t.text)
+		}
+		return p.parseInstant(t.text)
+	}
+	return nil, errorf(t.pos, "unexpected %s", t)
+}
+
+// parseInstant разбирает селектор текущих значений, окно допустимо только в rate и increase
+func (p *parser) parseInstant(name string) (Expr, error) {
+	pos := p.peek().pos
+	s, err := p.parseSelector(name)
+	if err != nil {
+		return nil, err
+	}
+	if s.Window > 0 {
+		return nil, errorf(pos, "window is only allowed inside rate and increase")
+	}
+	return s, nil
+}
+
+// parseSelector разбирает метки и окно после имени метрики
+func (p *parser) parseSelector(name string) (*Selector, error) {
+	s := &Selector{Name: name}
+	if p.isOp("{") {
+		p.next()
+		for !p.isOp("}") {
+			m, err := p.parseMatcher()
+			if err != nil {
+				return nil, err
+			}
+			s.Matchers = append(s.Matchers, m)
+			if !p.isOp(",") {
+				break
+			}
+			p.next()
+		}
+		if err := p.expect("}"); err != nil {
+			return nil, err
+		}
+		if name == "" && len(s.Matchers) == 0 {
+			return nil, errorf(p.peek().pos, "selector needs a metric name or at least one matcher")
+		}
+	}
+	if p.isOp("[") {
+		p.next()
+		t := p.next()
+		if t.kind != tokDuration {
+			return nil, errorf(t.pos, "expected a duration like 5m, got %s", t)
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		s.Window = t.dur
+	}
+	return s, nil
+}
+
+func (p *parser) parseMatcher() (*Matcher, error) {
+	label := p.next()
+	if label.kind != tokIdent {
+		return nil, errorf(label.pos, "expected a label name, got %s", label)
+	}
+	op := p.next()
+	if op.kind != tokOp || (op.text != "=" && op.text != "!=" && op.text != "=~" && op.text != "!~") {
+		return nil, errorf(op.pos, "expected =, !=, =~ or !~, got %s", op)
+	}
+	value := p.next()
+	if value.kind != tokString {
+		return nil, errorf(value.pos, "expected a quoted string, got %s", value)
+	}
+	m := &Matcher{Label: label.text, Op: op.text, Value: value.text}
+	if op.text == "=~" || op.text == "!~" {
+		re, err := regexp.Compile("^(?:" + value.text + ")$")
+		if err != nil {
+			return nil, errorf(value.pos, "invalid regular expression: %v", err)
+		}
+		m.re = re
+	}
+	return m, nil
+}
+
+// parseBy разбирает список меток группировки после by
+func (p *parser) parseBy() ([]string, error) {
+	p.next()
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var labels []string
+	for !p.isOp(")") {
+		t := p.next()
+		if t.kind != tokIdent {
+			return nil, errorf(t.pos, "expected a label name, got %s", t)
+		}
+		labels = append(labels, t.text)
+		if !p.isOp(",") {
+			break
+		}
+		p.next()
+	}
+	return labels, p.expect(")")
+}
+
+// parseAggregate разбирает sum(expr) by (labels) и sum by (labels) (expr)
+func (p *parser) parseAggregate(op string) (Expr, error) {
+	a := &Aggregate{Op: op}
+	var err error
+	if t := p.peek(); t.kind == tokIdent && t.text == "by" {
+		if a.By, err = p.parseBy(); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	if a.Arg, err = p.parseBinary(1); err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	if t := p.peek(); a.By == nil && t.kind == tokIdent && t.text == "by" {
+		if a.By, err = p.parseBy(); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+// parseCall разбирает rate(selector[window]) и increase(selector[window])
+func (p *parser) parseCall(fn string) (Expr, error) {
+	p.next()
+	t := p.peek()
+	var s *Selector
+	var err error
+	switch {
+	case t.kind == tokIdent:
+		p.next()
+		s, err = p.parseSelector(t.text)
+	case t.kind == tokOp && t.text == "{":
+		s, err = p.parseSelector("")
+	default:
+		return nil, errorf(t.pos, "%s expects a selector, got %s", fn, t)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.Window == 0 {
+		return nil, errorf(t.pos, "%s needs a window, for example %s(%s[5m])", fn, fn, s)
+	}
+	return &Call{Func: fn, Arg: s}, p.expect(")")
+}
@@ -0,0 +1,187 @@
+package query
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/lionslon/go-yapmetrics/internal/models"
+	"github.com/lionslon/go-yapmetrics/internal/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSource метрики и заданный прирост counter-метрик
+type testSource struct {
+	metrics []models.Metrics
+	rates   map[string]storage.Rate
+}
+
+func (s testSource) List() []models.Metrics {
+	return s.metrics
+}
+
+func (s testSource) CounterRate(n string, _ time.Duration, _ time.Time) (storage.Rate, bool) {
+	r, ok := s.rates[n]
+	return r, ok
+}
+
+func gauge(id string, v float64, labels map[string]string) models.Metrics {
+	return models.Metrics{ID: id, MType: "gauge", Value: &v, Labels: labels}
+}
+
+func counter(id string, v int64, labels map[string]string) models.Metrics {
+	return models.Metrics{ID: id, MType: "counter", Delta: &v, Labels: labels}
+}
+
+func TestParse(t *testing.T) {
+	testCases := []struct {
+		expr string
+		want string
+		err  string
+	}{
+		{expr: "HeapAlloc", want: "HeapAlloc"},
+		{expr: `{__name__=~"Heap.*",host!="b"}`, want: `{__name__=~"Heap.*", host!="b"}`},
+		{expr: "1 + 2 * 3", want: "1 + 2 * 3"},
+		{expr: "(1 + 2) * 3", want: "(1 + 2) * 3"},
+		{expr: "1 - (2 - 3)", want: "1 - (2 - 3)"},
+		{expr: "--Alloc", want: "--Alloc"},
+		{expr: "-(Alloc + 1)", want: "-(Alloc + 1)"},
+		{expr: "sum(Alloc) by (host)", want: "sum by (host) (Alloc)"},
+		{expr: "avg by (host, dc) (Alloc)", want: "avg by (host, dc) (Alloc)"},
+		{expr: "rate(PollCount[1m30s]) < 0.1", want: "rate(PollCount[1m30s]) < 0.1"},
+		{expr: "sum", want: "sum"},
+		{expr: "", err: "at position 0: unexpected end of expression"},
+		{expr: "Alloc +", err: "at position 7: unexpected end of expression"},
+		{expr: "Alloc[5m]", err: "at position 5: window is only allowed inside rate and increase"},
+		{expr: "rate(PollCount)", err: "needs a window"},
+		{expr: `{host="a"`, err: `expected "}"`},
+		{expr: `{"a"="b"}`, err: "expected a label name"},
+		{expr: `{host=~"("}`, err: "invalid regular expression"},
+		{expr: `{host="a}`, err: "unterminated string"},
+		{expr: "rate(PollCount[5])", err: "expected a duration"},
+		{expr: "Alloc # 1", err: "at position 6: unexpected character '#'"},
+		{expr: "1.2.3", err: "invalid number"},
+		{expr: "Alloc > 1e5", want: "Alloc > 100000"},
+		{expr: "Alloc * 2.5E-3", want: "Alloc * 0.0025"},
+		{expr: "1e5s", err: `invalid number "1e5s"`},
+		{expr: "1e", err: "invalid duration"},
+		{expr: "(1", err: `expected ")"`},
+		{expr: "{}", err: "selector needs a metric name"},
+	}
+	for _, test := range testCases {
+		t.Run(test.expr, func(t *testing.T) {
+			e, err := Parse(test.expr)
+			if test.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, e.String())
+		})
+	}
+
+	deep := ""
+	for i := 0; i < maxDepth+1; i++ {
+		deep += "("
+	}
+	_, err := Parse(deep + "1")
+	assert.ErrorContains(t, err, "nested deeper")
+}
+
+func TestEval(t *testing.T) {
+	src := testSource{
+		metrics: []models.Metrics{
+			gauge("HeapAlloc", 100, map[string]string{"host": "a", "dc": "x"}),
+			gauge("HeapAlloc", 300, map[string]string{"host": "b", "dc": "x"}),
+			gauge("HeapSys", 400, map[string]string{"host": "a", "dc": "x"}),
+			gauge("HeapSys", 600, map[string]string{"host": "b", "dc": "x"}),
+			gauge("Zero", 0, nil),
+			counter("PollCount", 42, nil),
+			counter("Requests", 10, map[string]string{"host": "a"}),
+		},
+		rates: map[string]storage.Rate{
+			"PollCount": {Increase: 6, Rate: 0.1},
+		},
+	}
+	hosts := func(values map[string]float64) []Series {
+		var res []Series
+		for _, h := range []string{"a", "b"} {
+			if v, ok := values[h]; ok {
+				res = append(res, Series{Labels: map[string]string{"host": h, "dc": "x"}, Value: v})
+			}
+		}
+		return res
+	}
+	testCases := []struct {
+		expr string
+		want Result
+		err  string
+	}{
+		{expr: "2 * (3 + 4)", want: Result{Type: TypeScalar, Scalar: 14}},
+		{expr: `HeapAlloc{host="a"}`, want: Result{Type: TypeVector, Series: []Series{
+			{Name: "HeapAlloc", Type: "gauge", Labels: map[string]string{"host": "a", "dc": "x"}, Value: 100}}}},
+		{expr: `{__name__=~"Heap.*", host="b"}`, want: Result{Type: TypeVector, Series: []Series{
+			{Name: "HeapAlloc", Type: "gauge", Labels: map[string]string{"host": "b", "dc": "x"}, Value: 300},
+			{Name: "HeapSys", Type: "gauge", Labels: map[string]string{"host": "b", "dc": "x"}, Value: 600}}}},
+		{expr: `{__type__="counter", host!="a"}`, want: Result{Type: TypeVector, Series: []Series{
+			{Name: "PollCount", Type: "counter", Value: 42}}}},
+		{expr: "HeapAlloc / HeapSys * 100", want: Result{Type: TypeVector, Series: hosts(map[string]float64{"a": 25, "b": 50})}},
+		{expr: "HeapAlloc / HeapSys > 0.3", want: Result{Type: TypeVector, Series: []Series{
+			{Labels: map[string]string{"host": "b", "dc": "x"}, Value: 0.5}}}},
+		{expr: "-HeapAlloc", want: Result{Type: TypeVector, Series: hosts(map[string]float64{"a": -100, "b": -300})}},
+		{expr: "sum(HeapAlloc)", want: Result{Type: TypeVector, Series: []Series{{Value: 400}}}},
+		{expr: "avg by (dc) (HeapAlloc)", want: Result{Type: TypeVector, Series: []Series{{Labels: map[string]string{"dc": "x"}, Value: 200}}}},
+		{expr: "max(HeapSys)", want: Result{Type: TypeVector, Series: []Series{{Value: 600}}}},
+		{expr: "min(HeapSys) by (host)", want: Result{Type: TypeVector, Series: []Series{
+			{Labels: map[string]string{"host": "a"}, Value: 400},
+			{Labels: map[string]string{"host": "b"}, Value: 600}}}},
+		{expr: `count({__name__=~"Heap.*"})`, want: Result{Type: TypeVector, Series: []Series{{Value: 4}}}},
+		{expr: "rate(PollCount[5m])", want: Result{Type: TypeVector, Series: []Series{{Name: "PollCount", Type: "counter", Value: 0.1}}}},
+		{expr: "increase({__type__=\"counter\"}[5m])", want: Result{Type: TypeVector, Series: []Series{{Name: "PollCount", Type: "counter", Value: 6}}}},
+		{expr: "HeapAlloc / Zero", want: Result{Type: TypeVector, Series: []Series{}}},
+		{expr: "Missing + 1", want: Result{Type: TypeVector, Series: []Series{}}},
+		{expr: "1 / 0", err: "not a finite number"},
+		{expr: "1 > 0", err: "needs series"},
+		{expr: "sum(1)", err: "expects series"},
+		{expr: `{__name__=~"Heap.*"} + 1 + HeapSys`, err: "several series on the left side"},
+	}
+	for _, test := range testCases {
+		t.Run(test.expr, func(t *testing.T) {
+			e, err := Parse(test.expr)
+			require.NoError(t, err)
+			got, err := Eval(e, src, time.Now())
+			if test.err != "" {
+				assert.ErrorContains(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestEvalCopiesLabels(t *testing.T) {
+	alloc := 10.0
+	labels := map[string]string{"host": "a"}
+	src := testSource{metrics: []models.Metrics{{ID: "Alloc", MType: "gauge", Value: &alloc, Labels: labels}}}
+
+	e, err := Parse("Alloc")
+	require.NoError(t, err)
+	got, err := Eval(e, src, time.Now())
+	require.NoError(t, err)
+	require.Len(t, got.Series, 1)
+	got.Series[0].Labels["host"] = "b"
+	assert.Equal(t, map[string]string{"host": "a"}, labels)
+}
+
+func TestResultJSON(t *testing.T) {
+	data, err := json.Marshal(Result{Type: TypeScalar, Scalar: 1.5})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"type":"scalar","value":1.5}`, string(data))
+
+	data, err = json.Marshal(Result{Type: TypeVector})
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"type":"vector","series":[]}`, string(data))
+}
@@ -0,0 +1,256 @@
+// Package recording вычисляет правила записи: производные gauge-метрики по выражениям языка запросов.
+// Результат записывается в хранилище как обычная метрика, поэтому доступен дашбордам и алертам.
+// Имена правил обязаны начинаться с NamePrefix: это пространство имен зарезервировано за правилами
+// записи, и сервер не принимает метрики с таким префиксом от агентов, поэтому правило не может
+// перезаписать присланную метрику, а агент — результат правила.
+// Файл правил можно менять на ходу, при ошибке в нем продолжают действовать прежние правила
+package recording
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lionslon/go-yapmetrics/internal/query"
+	"github.com/lionslon/go-yapmetrics/internal/validation"
+	"go.uber.org/zap"
+)
+
+// NamePrefix префикс имен метрик, которые записывают правила
+const NamePrefix = "recorded:"
+
+// Target хранилище, из которого читаются метрики и в которое записываются результаты
+type Target interface {
+	query.Source
+	UpdateGauge(string, float64)
+	UpdateLabels(string, string, map[string]string)
+}
+
+// Rule правило записи: значение Expr записывается в gauge-метрику Name.
+// Выражение должно вернуть число или ровно одну серию
+type Rule struct {
+	Name   string            `json:"name"`
+	Expr   string            `json:"expr"`
+	Labels map[string]string `json:"labels,omitempty"`
+
+	expr query.Expr
+}
+
+// RuleStatus результат последнего вычисления правила
+type RuleStatus struct {
+	Name     string            `json:"name"`
+	Expr     string            `json:"expr"`
+	Labels   map[string]string `json:"labels,omitempty"`
+	LastEval *time.Time        `json:"last_eval,omitempty"`
+	Value    *float64          `json:"value,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
+// Status состояние правил записи для GET /recording-rules
+type Status struct {
+	File      string       `json:"file"`
+	LoadedAt  time.Time    `json:"loaded_at"`
+	LoadError string       `json:"load_error,omitempty"`
+	Rules     []RuleStatus `json:"rules"`
+}
+
+// Recorder вычисляет правила записи с интервалом
+type Recorder struct {
+	mu        sync.RWMutex
+	path      string
+	target    Target
+	validator *validation.Validator
+	rules     []*Rule
+	status    []RuleStatus
+	modTime   time.Time
+	loadedAt  time.Time
+	loadErr   error
+}
+
+// New создает вычислитель и читает файл правил, ошибка в файле при запуске фатальна
+func New(path string, target Target, v *validation.Validator) (*Recorder, error) {
+	r := &Recorder{path: path, target: target, validator: v}
+	if err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// load читает и проверяет правила
+func (r *Recorder) load() ([]*Rule, error) {
+	data, err := os.ReadFile(r.path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []*Rule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse recording rules %s: %w", r.path, err)
+	}
+	seen := map[string]bool{}
+	for i, rule := range rules {
+		if p := r.validator.Name(rule.Name); p != nil {
+			return nil, fmt.Errorf("recording rule #%d: %s", i, p.Detail)
+		}
+		if !strings.HasPrefix(rule.Name, NamePrefix) {
+			return nil, fmt.Errorf("recording rule %q: name must start with %q", rule.Name, NamePrefix)
+		}
+		if seen[rule.Name] {
+			return nil, fmt.Errorf("duplicate recording rule %q", rule.Name)
+		}
+		seen[rule.Name] = true
+		if rule.expr, err = query.Parse(rule.Expr); err != nil {
+			return nil, fmt.Errorf("recording rule %q: invalid expr: %w", rule.Name, err)
+		}
+	}
+	return rules, nil
+}
+
+// Reload перечитывает файл правил. При ошибке действующие правила не меняются,
+// а ошибка видна в Status
+func (r *Recorder) Reload() error {
+	info, err := os.Stat(r.path)
+	var rules []*Rule
+	if err == nil {
+		rules, err = r.load()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if info != nil {
+		// неудачная версия файла тоже запоминается, чтобы не перечитывать ее до следующего изменения
+		r.modTime = info.ModTime()
+	}
+	if err != nil {
+		r.loadErr = err
+		return err
+	}
+	r.rules = rules
+	r.status = make([]RuleStatus, len(rules))
+	for i, rule := range rules {
+		r.status[i] = RuleStatus{Name: rule.Name, Expr: rule.Expr, Labels: rule.Labels}
+	}
+	r.loadedAt = time.Now()
+	r.loadErr = nil
+	return nil
+}
+
+// value значение результата правила
+func value(res query.Result) (float64, error) {
+	if res.Type == query.TypeScalar {
+		return res.Scalar, nil
+	}
+	if len(res.Series) != 1 {
+		return 0, fmt.Errorf("expression returned %d series, expected exactly one", len(res.Series))
+	}
+	return res.Series[0].Value, nil
+}
+
+// Eval вычисляет правила по порядку, так что правило может использовать результат предыдущего.
+// Если правило не вычислилось, метрика сохраняет прежнее значение
+func (r *Recorder) Eval(now time.Time) {
+	r.mu.RLock()
+	rules := r.rules
+	r.mu.RUnlock()
+
+	for i, rule := range rules {
+		res, err := query.Eval(rule.expr, r.target, now)
+		var v float64
+		if err == nil {
+			v, err = value(res)
+		}
+		if err == nil {
+			r.target.UpdateGauge(rule.Name, v)
+			if rule.Labels != nil {
+				r.target.UpdateLabels("gauge", rule.Name, rule.Labels)
+			}
+		}
+		r.record(rules, i, now, v, err)
+	}
+}
+
+// record сохраняет результат правила, если набор правил не сменился во время вычисления.
+// Ошибка пишется в лог только при изменении, чтобы не повторять ее каждый интервал
+func (r *Recorder) record(rules []*Rule, i int, now time.Time, v float64, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.rules) != len(rules) || r.rules[i] != rules[i] {
+		return
+	}
+	st := &r.status[i]
+	st.LastEval = &now
+	if err != nil {
+		if st.Error != err.Error() {
+			zap.S().Warnf("recording rule %s: %v", st.Name, err)
+		}
+		st.Error = err.Error()
+		return
+	}
+	if st.Error != "" {
+		zap.S().Infof("recording rule %s recovered", st.Name)
+	}
+	st.Error = ""
+	st.Value = &v
+}
+
+// Run вычисляет правила с интервалом interval
+func (r *Recorder) Run(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for now := range ticker.C {
+		r.Eval(now)
+	}
+}
+
+// changed сообщает, изменился ли файл с момента последнего чтения
+func (r *Recorder) changed() bool {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return !info.ModTime().Equal(r.modTime)
+}
+
+// Watch перечитывает файл при его изменении (проверка раз в interval) и по сигналу SIGHUP
+func (r *Recorder) Watch(interval time.Duration) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	var tick <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		select {
+		case <-hup:
+		case <-tick:
+			if !r.changed() {
+				continue
+			}
+		}
+		if err := r.Reload(); err != nil {
+			zap.S().Errorf("recording rules are not reloaded, previous rules stay active: %v", err)
+			continue
+		}
+		zap.S().Infof("recording rules %s reloaded", r.path)
+	}
+}
+
+// Status возвращает состояние правил
+func (r *Recorder) Status() Status {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	st := Status{File: r.path, LoadedAt: r.loadedAt, Rules: make([]RuleStatus, len(r.status))}
+	copy(st.Rules, r.status)
+	if r.loadErr != nil {
+		st.LoadError = r.loadErr.Error()
+	}
+	return st
+}
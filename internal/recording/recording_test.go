@@ -0,0 +1,92 @@
+package recording
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/lionslon/go-yapmetrics/internal/storage"
+	"github.com/lionslon/go-yapmetrics/internal/validation"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecorder(t *testing.T) {
+	st := storage.NewMemoryStorage()
+	st.UpdateGauge("TotalMemory", 400)
+	st.UpdateGauge("FreeMemory", 100)
+	st.UpdateGauge("CPUutilization1", 10)
+	st.UpdateGauge("CPUutilization2", 30)
+
+	path := filepath.Join(t.TempDir(), "recording.json")
+	write := func(body string, mod time.Time) {
+		require.NoError(t, os.WriteFile(path, []byte(body), 0o644))
+		require.NoError(t, os.Chtimes(path, mod, mod))
+	}
+	write(`[
+		{"name":"recorded:MemUsedPct","expr":"(TotalMemory - FreeMemory) / TotalMemory * 100","labels":{"source":"recording"}},
+		{"name":"recorded:CPUAvg","expr":"avg({__name__=~\"CPUutilization.*\"})"},
+		{"name":"recorded:MemUsedHalf","expr":"recorded:MemUsedPct / 2"},
+		{"name":"recorded:CPUAll","expr":"{__name__=~\"CPUutilization.*\"}"}
+	]`, time.Now().Add(-time.Hour))
+
+	r, err := New(path, st, validation.Default())
+	require.NoError(t, err)
+	r.Eval(time.Now())
+
+	v, ok := st.LookupGauge("recorded:MemUsedPct")
+	assert.True(t, ok)
+	assert.Equal(t, 75.0, v)
+	v, _ = st.LookupGauge("recorded:CPUAvg")
+	assert.Equal(t, 20.0, v)
+	v, _ = st.LookupGauge("recorded:MemUsedHalf")
+	assert.Equal(t, 37.5, v, "rules see results of earlier rules")
+	_, ok = st.LookupGauge("recorded:CPUAll")
+	assert.False(t, ok, "several series are not recorded")
+	for _, m := range st.List() {
+		if m.ID == "recorded:MemUsedPct" {
+			assert.Equal(t, map[string]string{"source": "recording"}, m.Labels)
+		}
+	}
+
+	status := r.Status()
+	require.Len(t, status.Rules, 4)
+	assert.Empty(t, status.LoadError)
+	assert.Equal(t, 75.0, *status.Rules[0].Value)
+	assert.Contains(t, status.Rules[3].Error, "2 series")
+	assert.Nil(t, status.Rules[3].Value)
+
+	// ошибка в новой версии файла не отменяет действующие правила
+	write(`[{"name":"recorded:Broken","expr":"sum("}]`, time.Now().Add(-time.Minute))
+	assert.True(t, r.changed())
+	assert.Error(t, r.Reload())
+	assert.False(t, r.changed(), "failed version is not re-read until it changes")
+	status = r.Status()
+	assert.Contains(t, status.LoadError, "invalid expr")
+	assert.Len(t, status.Rules, 4)
+
+	write(`[{"name":"recorded:MemFree","expr":"FreeMemory"}]`, time.Now())
+	require.NoError(t, r.Reload())
+	status = r.Status()
+	assert.Empty(t, status.LoadError)
+	require.Len(t, status.Rules, 1)
+	assert.Nil(t, status.Rules[0].LastEval)
+}
+
+func TestLoadInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "recording.json")
+	for _, body := range []string{
+		`{"name":"recorded:A","expr":"1"}`,
+		`[{"name":"1bad","expr":"1"}]`,
+		`[{"name":"recorded:A","expr":"1"},{"name":"recorded:A","expr":"2"}]`,
+		`[{"name":"recorded:A","expr":""}]`,
+		`[{"name":"HeapAlloc","expr":"1"}]`,
+	} {
+		require.NoError(t, os.WriteFile(path, []byte(body), 0o644))
+		_, err := New(path, storage.NewMemoryStorage(), validation.Default())
+		assert.Error(t, err, body)
+	}
+	_, err := New(filepath.Join(t.TempDir(), "missing.json"), storage.NewMemoryStorage(), validation.Default())
+	assert.Error(t, err)
+}
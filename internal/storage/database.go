@@ -2,6 +2,9 @@ package storage
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
 	_ "github.com/jackc/pgx/v5/stdlib"
 	"github.com/jmoiron/sqlx"
 	_ "github.com/lib/pq"
@@ -48,11 +51,23 @@ func NewDBProvider(dsn string, storeInterval int, m *MemStorage) (StorageWorker,
 	}
 
 	if dbc.DB != nil {
-		_, err := dbc.DB.Exec("CREATE TABLE IF NOT EXISTS counter_metrics (name char(30) UNIQUE, value integer);")
+		_, err := dbc.DB.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS counter_metrics (name char(%d) UNIQUE, value integer);", MaxNameLength))
 		if err != nil {
 			return nil, err
 		}
-		_, err = dbc.DB.Exec("CREATE TABLE IF NOT EXISTS gauge_metrics (name char(30) UNIQUE, value double precision);")
+		_, err = dbc.DB.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS gauge_metrics (name char(%d) UNIQUE, value double precision);", MaxNameLength))
+		if err != nil {
+			return nil, err
+		}
+		_, err = dbc.DB.Exec("CREATE TABLE IF NOT EXISTS metric_labels (key text UNIQUE, labels text);")
+		if err != nil {
+			return nil, err
+		}
+		_, err = dbc.DB.Exec("CREATE TABLE IF NOT EXISTS metric_seen (key text UNIQUE, updated_at timestamptz, agent text);")
+		if err != nil {
+			return nil, err
+		}
+		_, err = dbc.DB.Exec("CREATE TABLE IF NOT EXISTS server_state (name text UNIQUE, data text);")
 		if err != nil {
 			return nil, err
 		}
@@ -98,6 +113,51 @@ func (d *dbProvider) Restore() error {
 		}
 		d.st.UpdateGauge(gm.name, gm.value)
 	}
+
+	rowsLabels, err := d.DB.QueryContext(ctx, "SELECT key, labels FROM metric_labels;")
+	if err != nil {
+		return err
+	}
+	if err = rowsLabels.Err(); err != nil {
+		return err
+	}
+	defer rowsLabels.Close()
+
+	labelData := make(map[string]labels)
+	for rowsLabels.Next() {
+		var key, raw string
+		err = rowsLabels.Scan(&key, &raw)
+		if err != nil {
+			return err
+		}
+		var l labels
+		if err = json.Unmarshal([]byte(raw), &l); err != nil {
+			return err
+		}
+		labelData[key] = l
+	}
+	d.st.UpdateLabelData(labelData)
+
+	rowsSeen, err := d.DB.QueryContext(ctx, "SELECT key, updated_at, agent FROM metric_seen;")
+	if err != nil {
+		return err
+	}
+	if err = rowsSeen.Err(); err != nil {
+		return err
+	}
+	defer rowsSeen.Close()
+
+	seenData := make(map[string]Seen)
+	for rowsSeen.Next() {
+		var key string
+		var seen Seen
+		err = rowsSeen.Scan(&key, &seen.UpdatedAt, &seen.Agent)
+		if err != nil {
+			return err
+		}
+		seenData[key] = seen
+	}
+	d.st.UpdateSeenData(seenData)
 	return nil
 }
 
@@ -124,28 +184,66 @@ func (d *dbProvider) Check() error {
 
 // Dump подчищает и записывает в БД
 func (d *dbProvider) Dump() error {
+	snap := d.st.Snapshot()
 	tx, err := d.DB.Begin()
 	if err != nil {
 		return err
 	}
 
-	_, err = tx.Exec("TRUNCATE counter_metrics, gauge_metrics; ")
+	_, err = tx.Exec("TRUNCATE counter_metrics, gauge_metrics, metric_labels, metric_seen; ")
 	if err != nil {
 		return err
 	}
-	for k, v := range d.st.GetCounterData() {
+	for k, v := range snap.CounterData {
 		_, err = tx.Exec("INSERT INTO counter_metrics (name, value) VALUES ($1, $2); ", k, v)
 		if err != nil {
 			return err
 		}
 	}
 
-	for k, v := range d.st.GetGaugeData() {
+	for k, v := range snap.GaugeData {
 		_, err = tx.Exec("INSERT INTO gauge_metrics (name, value) VALUES ($1, $2); ", k, v)
 		if err != nil {
 			return err
 		}
 	}
 
+	for k, v := range snap.LabelData {
+		raw, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec("INSERT INTO metric_labels (key, labels) VALUES ($1, $2); ", k, string(raw))
+		if err != nil {
+			return err
+		}
+	}
+
+	for k, v := range snap.SeenData {
+		_, err = tx.Exec("INSERT INTO metric_seen (key, updated_at, agent) VALUES ($1, $2, $3); ", k, v.UpdatedAt, v.Agent)
+		if err != nil {
+			return err
+		}
+	}
+
 	return tx.Commit()
 }
+
+// SaveState сохраняет состояние в таблицу server_state
+func (d *dbProvider) SaveState(name string, data []byte) error {
+	_, err := d.DB.Exec("INSERT INTO server_state (name, data) VALUES ($1, $2) ON CONFLICT (name) DO UPDATE SET data = EXCLUDED.data;", name, string(data))
+	return err
+}
+
+// LoadState читает состояние из таблицы server_state
+func (d *dbProvider) LoadState(name string) ([]byte, error) {
+	var data string
+	err := d.DB.QueryRow("SELECT data FROM server_state WHERE name = $1;", name).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(data), nil
+}
@@ -40,7 +40,7 @@ func (f *fileProvider) Dump() error {
 		}
 	}
 
-	data, err := json.MarshalIndent(f.st, "", "   ")
+	data, err := json.MarshalIndent(f.st.Snapshot(), "", "   ")
 	if err != nil {
 		return err
 	}
@@ -69,3 +69,27 @@ func (f *fileProvider) Restore() error {
 
 	return json.Unmarshal(file, f.st)
 }
+
+// statePath файл состояния рядом с файлом метрик
+func (f *fileProvider) statePath(name string) string {
+	return f.filePath + "." + name + ".json"
+}
+
+// SaveState записывает состояние во временный файл и заменяет им прежний
+func (f *fileProvider) SaveState(name string, data []byte) error {
+	p := f.statePath(name)
+	tmp := p + ".tmp"
+	if err := os.WriteFile(tmp, data, 0666); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p)
+}
+
+// LoadState читает состояние из файла
+func (f *fileProvider) LoadState(name string) ([]byte, error) {
+	data, err := os.ReadFile(f.statePath(name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return data, err
+}
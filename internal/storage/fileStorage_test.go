@@ -0,0 +1,67 @@
+package storage
+
+import (
+	"fmt"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileProviderState(t *testing.T) {
+	fp := NewFileProvider(filepath.Join(t.TempDir(), "metrics.json"), 0, NewMemoryStorage())
+	ss, ok := fp.(StateStore)
+	require.True(t, ok)
+
+	data, err := ss.LoadState("alerts")
+	require.NoError(t, err)
+	assert.Nil(t, data)
+
+	require.NoError(t, ss.SaveState("alerts", []byte(`{"HighHeap":{}}`)))
+	require.NoError(t, ss.SaveState("alerts", []byte(`{}`)))
+	data, err = ss.LoadState("alerts")
+	require.NoError(t, err)
+	assert.Equal(t, `{}`, string(data))
+}
+
+func TestFileProviderDumpConcurrent(t *testing.T) {
+	st := NewMemoryStorage()
+	fp := NewFileProvider(filepath.Join(t.TempDir(), "metrics.json"), 0, st)
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 200; i++ {
+			n := fmt.Sprintf("m%d", i%10)
+			st.UpdateCounter(n, 1)
+			st.UpdateGauge(n, float64(i))
+			st.UpdateLabels("gauge", n, map[string]string{"host": "a"})
+			st.UpdateAgent("gauge", n, "agent-1")
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			assert.NoError(t, fp.Dump())
+			st.GetLabelData()
+			st.GetSeenData()
+		}
+	}()
+	wg.Wait()
+
+	require.NoError(t, fp.Dump())
+	restored := NewMemoryStorage()
+	require.NoError(t, NewFileProvider(fp.(*fileProvider).filePath, 0, restored).Restore())
+	assert.Equal(t, int64(200), sumCounters(restored.GetCounterData()))
+}
+
+func sumCounters(m map[string]counter) int64 {
+	var sum int64
+	for _, v := range m {
+		sum += int64(v)
+	}
+	return sum
+}
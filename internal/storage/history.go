@@ -0,0 +1,104 @@
+package storage
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultHistoryRetention сколько хранятся значения counter-метрик для rate и increase
+	DefaultHistoryRetention = 15 * time.Minute
+	// maxSamples ограничение числа значений одной метрики, старые значения вытесняются
+	maxSamples = 4096
+)
+
+// Sample значение counter-метрики в момент времени
+type Sample struct {
+	At    time.Time
+	Value int64
+}
+
+// Rate прирост counter-метрики за окно. Уменьшение значения считается сбросом счетчика
+// (сервер перезапущен без восстановления или счетчик обнулен), после сброса прирост
+// отсчитывается от нуля
+type Rate struct {
+	ID string `json:"id"`
+	// Increase прирост за окно
+	Increase float64 `json:"increase"`
+	// Rate прирост в секунду между первым и последним значением окна
+	Rate    float64 `json:"rate"`
+	Resets  int     `json:"resets"`
+	Samples int     `json:"samples"`
+}
+
+// history значения counter-метрик за последние retention
+type history struct {
+	mu        sync.Mutex
+	retention time.Duration
+	samples   map[string][]Sample
+}
+
+func newHistory(retention time.Duration) *history {
+	return &history{retention: retention, samples: make(map[string][]Sample)}
+}
+
+// add записывает значение и отбрасывает устаревшие
+func (h *history) add(n string, at time.Time, v int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.retention <= 0 {
+		return
+	}
+	samples := append(h.samples[n], Sample{At: at, Value: v})
+	drop := 0
+	for drop < len(samples)-1 && at.Sub(samples[drop].At) > h.retention {
+		drop++
+	}
+	if len(samples)-drop > maxSamples {
+		drop = len(samples) - maxSamples
+	}
+	if drop > 0 {
+		samples = append(samples[:0:0], samples[drop:]...)
+	}
+	h.samples[n] = samples
+}
+
+func (h *history) remove(n string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	delete(h.samples, n)
+}
+
+// window значения метрики в окне (now-window, now]
+func (h *history) window(n string, window time.Duration, now time.Time) []Sample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var res []Sample
+	for _, s := range h.samples[n] {
+		if now.Sub(s.At) < window && !s.At.After(now) {
+			res = append(res, s)
+		}
+	}
+	return res
+}
+
+// computeRate считает прирост по значениям, нужно хотя бы два значения
+func computeRate(samples []Sample) (Rate, bool) {
+	r := Rate{Samples: len(samples)}
+	if len(samples) < 2 {
+		return r, false
+	}
+	for i := 1; i < len(samples); i++ {
+		prev, cur := samples[i-1].Value, samples[i].Value
+		if cur < prev {
+			r.Resets++
+			r.Increase += float64(cur)
+			continue
+		}
+		r.Increase += float64(cur - prev)
+	}
+	if span := samples[len(samples)-1].At.Sub(samples[0].At).Seconds(); span > 0 {
+		r.Rate = r.Increase / span
+	}
+	return r, true
+}
@@ -1,5 +1,8 @@
 package storage
 
+// MaxNameLength максимальная длина имени метрики, совпадает с размером колонки name в БД
+const MaxNameLength = 30
+
 type StorageWorker interface {
 	Restore() error
 	Dump() error
@@ -7,6 +10,13 @@ type StorageWorker interface {
 	Check() error
 }
 
+// StateStore хранит служебное состояние подсистем сервера (например, алертов) рядом с метриками
+type StateStore interface {
+	SaveState(name string, data []byte) error
+	// LoadState возвращает nil без ошибки, если состояние еще не сохранялось
+	LoadState(name string) ([]byte, error)
+}
+
 type StorageProvider int
 
 const (
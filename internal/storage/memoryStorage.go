@@ -4,15 +4,63 @@ import (
 	"fmt"
 	"github.com/lionslon/go-yapmetrics/internal/models"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
 type gauge float64
 type counter int64
+type labels map[string]string
+
+// metricKey ключ метрики в LabelData
+func metricKey(t string, n string) string {
+	return t + ":" + n
+}
+
+// Seen время последнего обновления метрики и агент, который ее прислал
+type Seen struct {
+	UpdatedAt time.Time `json:"updated_at"`
+	Agent     string    `json:"agent,omitempty"`
+}
+
+// Freshness метрика со временем последнего обновления
+type Freshness struct {
+	ID     string
+	MType  string
+	Labels map[string]string
+	Seen
+}
+
+// Agent агент и время последней присланной им метрики
+type Agent struct {
+	ID       string    `json:"id"`
+	LastSeen time.Time `json:"last_seen"`
+	Metrics  int       `json:"metrics"`
+}
+
+// Snapshot копия данных хранилища для записи в файл или БД
+type Snapshot struct {
+	GaugeData   map[string]gauge   `json:"gauge"`
+	CounterData map[string]counter `json:"counter"`
+	LabelData   map[string]labels  `json:"labels,omitempty"`
+	SeenData    map[string]Seen    `json:"seen,omitempty"`
+}
 
 // MemStorage структура для работы с данными
 type MemStorage struct {
 	GaugeData   map[string]gauge   `json:"gauge"`
 	CounterData map[string]counter `json:"counter"`
+	LabelData   map[string]labels  `json:"labels,omitempty"`
+	SeenData    map[string]Seen    `json:"seen,omitempty"`
+	mu          sync.RWMutex
+	lastUpdate  time.Time
+	history     *history
+	// sorted индекс ключей для постраничной выдачи, nil после добавления или удаления метрик.
+	// Перестраивается под блокировкой на чтение, поэтому защищен отдельным idxMu
+	sorted []metricRef
+	idxMu  sync.Mutex
 }
 
 // NewMemoryStorage конструктор для структуры
@@ -20,20 +68,122 @@ func NewMemoryStorage() *MemStorage {
 	storage := MemStorage{
 		GaugeData:   make(map[string]gauge),
 		CounterData: make(map[string]counter),
+		LabelData:   make(map[string]labels),
+		SeenData:    make(map[string]Seen),
+		history:     newHistory(DefaultHistoryRetention),
 	}
 
 	return &storage
 }
 
 func (s *MemStorage) UpdateCounter(n string, v int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.CounterData[n]; !ok {
+		s.sorted = nil
+	}
 	s.CounterData[n] += counter(v)
+	s.touchLocked("counter", n)
+	s.history.add(n, s.lastUpdate, int64(s.CounterData[n]))
+}
+
+// SetHistoryRetention задает, сколько хранятся значения counter-метрик, 0 отключает историю
+func (s *MemStorage) SetHistoryRetention(d time.Duration) {
+	s.history = newHistory(d)
+}
+
+// CounterRate считает прирост counter-метрики за окно window до now.
+// Возвращает false, если в окне меньше двух значений
+func (s *MemStorage) CounterRate(n string, window time.Duration, now time.Time) (Rate, bool) {
+	r, ok := computeRate(s.history.window(n, window, now))
+	r.ID = n
+	return r, ok
 }
 
 func (s *MemStorage) UpdateGauge(n string, v float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.GaugeData[n]; !ok {
+		s.sorted = nil
+	}
 	s.GaugeData[n] = gauge(v)
+	s.touchLocked("gauge", n)
+}
+
+// touchLocked отмечает обновление метрики, агент сохраняется от предыдущего обновления
+func (s *MemStorage) touchLocked(t string, n string) {
+	s.lastUpdate = time.Now()
+	if s.SeenData == nil {
+		s.SeenData = make(map[string]Seen)
+	}
+	seen := s.SeenData[metricKey(t, n)]
+	seen.UpdatedAt = s.lastUpdate
+	s.SeenData[metricKey(t, n)] = seen
+}
+
+// UpdateAgent запоминает агента, который прислал метрику
+func (s *MemStorage) UpdateAgent(t string, n string, agent string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen, ok := s.SeenData[metricKey(t, n)]
+	if !ok {
+		return
+	}
+	seen.Agent = agent
+	s.SeenData[metricKey(t, n)] = seen
+}
+
+// Freshness возвращает время последнего обновления всех метрик, у которых оно известно
+func (s *MemStorage) Freshness() []Freshness {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make([]Freshness, 0, len(s.SeenData))
+	for n := range s.GaugeData {
+		if seen, ok := s.SeenData[metricKey("gauge", n)]; ok {
+			res = append(res, Freshness{ID: n, MType: "gauge", Labels: s.labelsLocked("gauge", n), Seen: seen})
+		}
+	}
+	for n := range s.CounterData {
+		if seen, ok := s.SeenData[metricKey("counter", n)]; ok {
+			res = append(res, Freshness{ID: n, MType: "counter", Labels: s.labelsLocked("counter", n), Seen: seen})
+		}
+	}
+	return res
+}
+
+// Agents возвращает агентов, приславших метрики, по убыванию давности последней метрики
+func (s *MemStorage) Agents() []Agent {
+	byID := map[string]*Agent{}
+	for _, f := range s.Freshness() {
+		if f.Agent == "" {
+			continue
+		}
+		a, ok := byID[f.Agent]
+		if !ok {
+			a = &Agent{ID: f.Agent}
+			byID[f.Agent] = a
+		}
+		a.Metrics++
+		if f.UpdatedAt.After(a.LastSeen) {
+			a.LastSeen = f.UpdatedAt
+		}
+	}
+	res := make([]Agent, 0, len(byID))
+	for _, a := range byID {
+		res = append(res, *a)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if !res[i].LastSeen.Equal(res[j].LastSeen) {
+			return res[i].LastSeen.Before(res[j].LastSeen)
+		}
+		return res[i].ID < res[j].ID
+	})
+	return res
 }
 
 func (s *MemStorage) GetValue(t string, n string) (string, int) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	var v string
 	statusCode := http.StatusOK
 	if val, ok := s.GaugeData[n]; ok && t == "gauge" {
@@ -47,6 +197,8 @@ func (s *MemStorage) GetValue(t string, n string) (string, int) {
 }
 
 func (s *MemStorage) AllMetrics() string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	var result string
 	result += "Gauge metrics:\n"
 	for n, v := range s.GaugeData {
@@ -61,28 +213,264 @@ func (s *MemStorage) AllMetrics() string {
 	return result
 }
 
+// UpdateLabels заменяет метки метрики, пустой набор меток их удаляет
+func (s *MemStorage) UpdateLabels(t string, n string, l map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(l) == 0 {
+		delete(s.LabelData, metricKey(t, n))
+		return
+	}
+	if s.LabelData == nil {
+		s.LabelData = make(map[string]labels)
+	}
+	cp := make(labels, len(l))
+	for k, v := range l {
+		cp[k] = v
+	}
+	s.LabelData[metricKey(t, n)] = cp
+}
+
+// List возвращает все метрики, отсортированные по имени и типу
+func (s *MemStorage) List() []models.Metrics {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make([]models.Metrics, 0, len(s.GaugeData)+len(s.CounterData))
+	for n, v := range s.GaugeData {
+		value := float64(v)
+		res = append(res, models.Metrics{ID: n, MType: "gauge", Value: &value, Labels: s.labelsLocked("gauge", n)})
+	}
+	for n, v := range s.CounterData {
+		delta := int64(v)
+		res = append(res, models.Metrics{ID: n, MType: "counter", Delta: &delta, Labels: s.labelsLocked("counter", n)})
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].ID != res[j].ID {
+			return res[i].ID < res[j].ID
+		}
+		return res[i].MType < res[j].MType
+	})
+	return res
+}
+
+// Labels возвращает копию меток метрики, nil без меток
+func (s *MemStorage) Labels(t string, n string) map[string]string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.labelsLocked(t, n)
+}
+
+// labelsLocked возвращает копию меток метрики, вызывается под блокировкой
+func (s *MemStorage) labelsLocked(t string, n string) map[string]string {
+	l, ok := s.LabelData[metricKey(t, n)]
+	if !ok {
+		return nil
+	}
+	cp := make(map[string]string, len(l))
+	for k, v := range l {
+		cp[k] = v
+	}
+	return cp
+}
+
+// DeleteMetric удаляет метрику, возвращает false, если метрики не было
+func (s *MemStorage) DeleteMetric(t string, n string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ok bool
+	switch t {
+	case "gauge":
+		_, ok = s.GaugeData[n]
+		delete(s.GaugeData, n)
+	case "counter":
+		_, ok = s.CounterData[n]
+		delete(s.CounterData, n)
+		s.history.remove(n)
+	}
+	if ok {
+		delete(s.LabelData, metricKey(t, n))
+		delete(s.SeenData, metricKey(t, n))
+		s.lastUpdate = time.Now()
+		s.sorted = nil
+	}
+	return ok
+}
+
+// DeleteByPrefix удаляет все метрики с указанным префиксом имени,
+// пустой тип означает метрики обоих типов. Возвращает удаленные метрики
+func (s *MemStorage) DeleteByPrefix(t string, prefix string) []models.Metrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var deleted []models.Metrics
+	if t == "" || t == "gauge" {
+		for n := range s.GaugeData {
+			if strings.HasPrefix(n, prefix) {
+				delete(s.GaugeData, n)
+				delete(s.LabelData, metricKey("gauge", n))
+				delete(s.SeenData, metricKey("gauge", n))
+				deleted = append(deleted, models.Metrics{ID: n, MType: "gauge"})
+			}
+		}
+	}
+	if t == "" || t == "counter" {
+		for n := range s.CounterData {
+			if strings.HasPrefix(n, prefix) {
+				delete(s.CounterData, n)
+				s.history.remove(n)
+				delete(s.LabelData, metricKey("counter", n))
+				delete(s.SeenData, metricKey("counter", n))
+				deleted = append(deleted, models.Metrics{ID: n, MType: "counter"})
+			}
+		}
+	}
+	if len(deleted) > 0 {
+		s.lastUpdate = time.Now()
+		s.sorted = nil
+	}
+	return deleted
+}
+
+// ResetCounter обнуляет counter-метрику, возвращает false, если метрики не было
+func (s *MemStorage) ResetCounter(n string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.CounterData[n]; !ok {
+		return false
+	}
+	s.CounterData[n] = 0
+	s.lastUpdate = time.Now()
+	s.history.add(n, s.lastUpdate, 0)
+	return true
+}
+
+// Gauges возвращает копию всех gauge-метрик
+func (s *MemStorage) Gauges() map[string]float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make(map[string]float64, len(s.GaugeData))
+	for n, v := range s.GaugeData {
+		res[n] = float64(v)
+	}
+	return res
+}
+
+// Counters возвращает копию всех counter-метрик
+func (s *MemStorage) Counters() map[string]int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	res := make(map[string]int64, len(s.CounterData))
+	for n, v := range s.CounterData {
+		res[n] = int64(v)
+	}
+	return res
+}
+
+// LastUpdate возвращает время последнего изменения метрик
+func (s *MemStorage) LastUpdate() time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.lastUpdate
+}
+
 func (s *MemStorage) GetCounterValue(id string) int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return int64(s.CounterData[id])
 }
 
 func (s *MemStorage) GetGaugeValue(id string) float64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	return float64(s.GaugeData[id])
 }
 
+// LookupCounter возвращает значение counter-метрики и признак ее наличия
+func (s *MemStorage) LookupCounter(id string) (int64, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.CounterData[id]
+	return int64(v), ok
+}
+
+// LookupGauge возвращает значение gauge-метрики и признак ее наличия
+func (s *MemStorage) LookupGauge(id string) (float64, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	v, ok := s.GaugeData[id]
+	return float64(v), ok
+}
+
+// Snapshot возвращает копию данных, снятую под блокировкой
+func (s *MemStorage) Snapshot() Snapshot {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	ld := make(map[string]labels, len(s.LabelData))
+	for k, v := range s.LabelData {
+		ld[k] = copyMap(v)
+	}
+	return Snapshot{
+		GaugeData:   copyMap(s.GaugeData),
+		CounterData: copyMap(s.CounterData),
+		LabelData:   ld,
+		SeenData:    copyMap(s.SeenData),
+	}
+}
+
+// copyMap неглубокая копия map
+func copyMap[K comparable, V any](m map[K]V) map[K]V {
+	c := make(map[K]V, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
 func (s *MemStorage) GetCounterData() map[string]counter {
-	return s.CounterData
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return copyMap(s.CounterData)
 }
 
 func (s *MemStorage) GetGaugeData() map[string]gauge {
-	return s.GaugeData
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return copyMap(s.GaugeData)
+}
+
+func (s *MemStorage) GetLabelData() map[string]labels {
+	return s.Snapshot().LabelData
+}
+
+func (s *MemStorage) GetSeenData() map[string]Seen {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return copyMap(s.SeenData)
 }
 
 func (s *MemStorage) UpdateGaugeData(gaugeData map[string]gauge) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.GaugeData = gaugeData
+	s.sorted = nil
 }
 
 func (s *MemStorage) UpdateCounterData(counterData map[string]counter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.CounterData = counterData
+	s.sorted = nil
+}
+
+func (s *MemStorage) UpdateLabelData(labelData map[string]labels) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.LabelData = labelData
+}
+
+func (s *MemStorage) UpdateSeenData(seenData map[string]Seen) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.SeenData = seenData
 }
 
 func (s *MemStorage) StoreBatch(metrics []models.Metrics) {
@@ -92,7 +480,11 @@ func (s *MemStorage) StoreBatch(metrics []models.Metrics) {
 			s.UpdateCounter(m.ID, *m.Delta)
 		case "gauge":
 			s.UpdateGauge(m.ID, *m.Value)
+		default:
+			continue
+		}
+		if m.Labels != nil {
+			s.UpdateLabels(m.MType, m.ID, m.Labels)
 		}
-
 	}
 }
@@ -2,7 +2,9 @@ package storage
 
 import (
 	"testing"
+	"time"
 
+	"github.com/lionslon/go-yapmetrics/internal/models"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -48,3 +50,130 @@ func TestUpdateGauge(t *testing.T) {
 		})
 	}
 }
+
+func TestDeleteAndReset(t *testing.T) {
+	s := NewMemoryStorage()
+	s.UpdateGauge("CPUutilization1", 1)
+	s.UpdateGauge("CPUutilization2", 2)
+	s.UpdateGauge("Alloc", 3)
+	s.UpdateCounter("PollCount", 4)
+	s.UpdateLabels("gauge", "Alloc", map[string]string{"host": "a"})
+
+	assert.True(t, s.DeleteMetric("gauge", "Alloc"))
+	assert.False(t, s.DeleteMetric("gauge", "Alloc"))
+	assert.False(t, s.DeleteMetric("counter", "CPUutilization1"))
+	assert.NotContains(t, s.LabelData, metricKey("gauge", "Alloc"))
+
+	assert.Len(t, s.DeleteByPrefix("counter", "CPU"), 0)
+	assert.Len(t, s.DeleteByPrefix("", "CPU"), 2)
+	assert.Empty(t, s.GaugeData)
+
+	assert.True(t, s.ResetCounter("PollCount"))
+	assert.False(t, s.ResetCounter("Missing"))
+	assert.Equal(t, counter(0), s.CounterData["PollCount"])
+}
+
+func TestAgents(t *testing.T) {
+	s := NewMemoryStorage()
+	s.UpdateCounter("PollCount", 1)
+	s.UpdateAgent("counter", "PollCount", "agent-1")
+	s.UpdateGauge("Alloc", 1)
+	s.UpdateAgent("gauge", "Alloc", "agent-2")
+	s.UpdateGauge("Frees", 1)
+	s.UpdateAgent("gauge", "Frees", "agent-2")
+	s.UpdateAgent("gauge", "Missing", "agent-3")
+
+	s.UpdateCounter("PollCount", 1)
+	seen := s.SeenData[metricKey("counter", "PollCount")]
+	seen.UpdatedAt = seen.UpdatedAt.Add(time.Second)
+	s.SeenData[metricKey("counter", "PollCount")] = seen
+	fresh := map[string]Freshness{}
+	for _, f := range s.Freshness() {
+		fresh[f.ID] = f
+	}
+	assert.Len(t, fresh, 3)
+	assert.Equal(t, "agent-1", fresh["PollCount"].Agent, "agent is kept between updates")
+
+	agents := s.Agents()
+	assert.Len(t, agents, 2)
+	assert.Equal(t, "agent-2", agents[0].ID, "most silent agent goes first")
+	assert.Equal(t, 2, agents[0].Metrics)
+
+	s.DeleteMetric("counter", "PollCount")
+	assert.Len(t, s.Agents(), 1)
+}
+
+func TestCounterRate(t *testing.T) {
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	at := func(sec int) time.Time { return start.Add(time.Duration(sec) * time.Second) }
+	testCases := []struct {
+		name   string
+		values []int64
+		window time.Duration
+		ok     bool
+		want   Rate
+	}{
+		{name: "steady", values: []int64{10, 20, 30, 40}, window: time.Minute, ok: true,
+			want: Rate{ID: "PollCount", Increase: 30, Rate: 1, Samples: 4}},
+		{name: "reset", values: []int64{10, 20, 5, 15}, window: time.Minute, ok: true,
+			want: Rate{ID: "PollCount", Increase: 25, Rate: 25.0 / 30, Resets: 1, Samples: 4}},
+		{name: "window cuts old samples", values: []int64{0, 100, 110, 120}, window: 25 * time.Second, ok: true,
+			want: Rate{ID: "PollCount", Increase: 20, Rate: 1, Samples: 3}},
+		{name: "single sample", values: []int64{10}, window: time.Minute,
+			want: Rate{ID: "PollCount", Samples: 1}},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewMemoryStorage()
+			for i, v := range test.values {
+				s.history.add("PollCount", at(i*10), v)
+			}
+			got, ok := s.CounterRate("PollCount", test.window, at((len(test.values)-1)*10))
+			assert.Equal(t, test.ok, ok)
+			assert.InDelta(t, test.want.Rate, got.Rate, 1e-9)
+			got.Rate = test.want.Rate
+			assert.Equal(t, test.want, got)
+		})
+	}
+
+	h := newHistory(time.Minute)
+	for i := 0; i < 10; i++ {
+		h.add("PollCount", at(i*20), int64(i))
+	}
+	assert.Len(t, h.samples["PollCount"], 4, "samples older than retention are dropped")
+}
+
+func TestPage(t *testing.T) {
+	s := NewMemoryStorage()
+	for _, n := range []string{"b", "d", "a"} {
+		s.UpdateGauge(n, 1)
+	}
+	s.UpdateCounter("b", 1)
+	all := func(models.Metrics) bool { return true }
+	ids := func(page []models.Metrics) []string {
+		res := make([]string, 0, len(page))
+		for _, m := range page {
+			res = append(res, m.MType+":"+m.ID)
+		}
+		return res
+	}
+
+	page, more := s.Page(nil, false, 2, all)
+	assert.Equal(t, []string{"gauge:a", "counter:b"}, ids(page))
+	assert.True(t, more)
+
+	// новая метрика и удаленная метрика курсора учитываются в следующей странице
+	s.UpdateGauge("c", 1)
+	s.DeleteMetric("counter", "b")
+	page, more = s.Page(&Cursor{ID: "b", MType: "counter"}, false, 2, all)
+	assert.Equal(t, []string{"gauge:b", "gauge:c"}, ids(page))
+	assert.True(t, more)
+
+	page, more = s.Page(&Cursor{ID: "c", MType: "gauge"}, true, 10, all)
+	assert.Equal(t, []string{"gauge:b", "gauge:a"}, ids(page))
+	assert.False(t, more)
+
+	page, more = s.Page(nil, true, 1, func(m models.Metrics) bool { return m.ID != "d" })
+	assert.Equal(t, []string{"gauge:c"}, ids(page))
+	assert.True(t, more)
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"sort"
+
+	"github.com/lionslon/go-yapmetrics/internal/models"
+)
+
+// metricRef ключ метрики в отсортированном индексе
+type metricRef struct {
+	id    string
+	mtype string
+}
+
+func (r metricRef) less(id string, mtype string) bool {
+	return r.id < id || (r.id == id && r.mtype < mtype)
+}
+
+// Cursor метрика, после которой продолжается постраничная выдача
+type Cursor struct {
+	ID    string
+	MType string
+}
+
+// sortedLocked возвращает ключи метрик, отсортированные по имени и типу. Индекс строится
+// заново только после добавления или удаления метрик, вызывается под блокировкой на чтение
+func (s *MemStorage) sortedLocked() []metricRef {
+	s.idxMu.Lock()
+	defer s.idxMu.Unlock()
+	if s.sorted != nil {
+		return s.sorted
+	}
+	keys := make([]metricRef, 0, len(s.GaugeData)+len(s.CounterData))
+	for n := range s.GaugeData {
+		keys = append(keys, metricRef{id: n, mtype: "gauge"})
+	}
+	for n := range s.CounterData {
+		keys = append(keys, metricRef{id: n, mtype: "counter"})
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].less(keys[j].id, keys[j].mtype) })
+	s.sorted = keys
+	return keys
+}
+
+// metricLocked собирает метрику по ключу индекса
+func (s *MemStorage) metricLocked(r metricRef) models.Metrics {
+	m := models.Metrics{ID: r.id, MType: r.mtype, Labels: s.labelsLocked(r.mtype, r.id)}
+	if r.mtype == "gauge" {
+		value := float64(s.GaugeData[r.id])
+		m.Value = &value
+	} else {
+		delta := int64(s.CounterData[r.id])
+		m.Delta = &delta
+	}
+	return m
+}
+
+// Page возвращает до limit подходящих под match метрик после курсора after (nil — с начала)
+// в порядке имени и типа, desc — в обратном. more сообщает, что подходящие метрики есть и дальше
+func (s *MemStorage) Page(after *Cursor, desc bool, limit int, match func(models.Metrics) bool) (page []models.Metrics, more bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	keys := s.sortedLocked()
+
+	i, step := 0, 1
+	if desc {
+		i, step = len(keys)-1, -1
+	}
+	if after != nil {
+		// первый ключ больше курсора, в обратном порядке — последний ключ меньше курсора
+		pos := sort.Search(len(keys), func(i int) bool { return !keys[i].less(after.ID, after.MType) })
+		if desc {
+			i = pos - 1
+		} else {
+			i = pos
+			if i < len(keys) && keys[i].id == after.ID && keys[i].mtype == after.MType {
+				i++
+			}
+		}
+	}
+
+	page = make([]models.Metrics, 0, limit)
+	for ; i >= 0 && i < len(keys); i += step {
+		m := s.metricLocked(keys[i])
+		if !match(m) {
+			continue
+		}
+		if len(page) == limit {
+			return page, true
+		}
+		page = append(page, m)
+	}
+	return page, false
+}
@@ -0,0 +1,199 @@
+// Package tlsutil собирает настройки TLS сервера и агента и сопоставляет
+// сертификаты клиентов с идентификаторами агентов при взаимной аутентификации
+package tlsutil
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Политики выбора наборов шифров для TLS 1.2, в TLS 1.3 наборы не настраиваются
+const (
+	// CiphersModern только AEAD с ECDHE
+	CiphersModern = "modern"
+	// CiphersCompatible все наборы, которые Go считает безопасными
+	CiphersCompatible = "compatible"
+)
+
+// Режимы проверки клиентских сертификатов
+const (
+	ClientAuthRequest = "request"
+	ClientAuthRequire = "require"
+)
+
+// ServerOptions настройки TLS сервера
+type ServerOptions struct {
+	CertFile   string
+	KeyFile    string
+	MinVersion string
+	// Ciphers политика modern, compatible или список названий наборов через запятую
+	Ciphers string
+	// ClientCAFile сертификаты УЦ клиентов, включает mTLS
+	ClientCAFile string
+	// ClientAuth request принимает соединения без сертификата, require — нет
+	ClientAuth string
+}
+
+// ClientOptions настройки TLS агента
+type ClientOptions struct {
+	CAFile     string
+	CertFile   string
+	KeyFile    string
+	ServerName string
+	MinVersion string
+}
+
+// ParseVersion разбирает минимальную версию протокола: 1.2 или 1.3
+func ParseVersion(v string) (uint16, error) {
+	switch strings.TrimPrefix(strings.ToLower(v), "tls") {
+	case "", "1.2":
+		return tls.VersionTLS12, nil
+	case "1.3":
+		return tls.VersionTLS13, nil
+	}
+	return 0, fmt.Errorf("unsupported TLS version %q, want 1.2 or 1.3", v)
+}
+
+// ParseCiphers возвращает наборы шифров по политике или списку названий
+func ParseCiphers(policy string) ([]uint16, error) {
+	var ids []uint16
+	switch policy {
+	case "", CiphersModern:
+		for _, s := range tls.CipherSuites() {
+			if strings.HasPrefix(s.Name, "TLS_ECDHE_") && (strings.Contains(s.Name, "_GCM_") || strings.Contains(s.Name, "CHACHA20")) {
+				ids = append(ids, s.ID)
+			}
+		}
+		return ids, nil
+	case CiphersCompatible:
+		for _, s := range tls.CipherSuites() {
+			ids = append(ids, s.ID)
+		}
+		return ids, nil
+	}
+	known := map[string]uint16{}
+	for _, s := range tls.CipherSuites() {
+		known[s.Name] = s.ID
+	}
+	for _, name := range strings.Split(policy, ",") {
+		name = strings.TrimSpace(name)
+		id, ok := known[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// loadPool читает PEM-файл с сертификатами УЦ
+func loadPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// NewServerConfig собирает tls.Config сервера
+func NewServerConfig(o ServerOptions) (*tls.Config, error) {
+	if o.CertFile == "" || o.KeyFile == "" {
+		return nil, errors.New("both TLS certificate and key are required")
+	}
+	cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	version, err := ParseVersion(o.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	ciphers, err := ParseCiphers(o.Ciphers)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   version,
+		CipherSuites: ciphers,
+	}
+	if o.ClientCAFile == "" {
+		return cfg, nil
+	}
+	if cfg.ClientCAs, err = loadPool(o.ClientCAFile); err != nil {
+		return nil, err
+	}
+	switch o.ClientAuth {
+	case "", ClientAuthRequire:
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	case ClientAuthRequest:
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	default:
+		return nil, fmt.Errorf("unsupported client auth mode %q", o.ClientAuth)
+	}
+	return cfg, nil
+}
+
+// NewClientConfig собирает tls.Config агента, без CAFile используются системные сертификаты
+func NewClientConfig(o ClientOptions) (*tls.Config, error) {
+	version, err := ParseVersion(o.MinVersion)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{MinVersion: version, ServerName: o.ServerName}
+	if o.CAFile != "" {
+		if cfg.RootCAs, err = loadPool(o.CAFile); err != nil {
+			return nil, err
+		}
+	}
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+// Identities сопоставляет субъекты клиентских сертификатов с идентификаторами агентов.
+// Ключ — полный субъект в виде RFC 2253 (CN=agent-1,O=Acme) или только CN.
+// Пустое сопоставление использует CN как идентификатор
+type Identities map[string]string
+
+// LoadIdentities читает сопоставление из JSON-файла
+func LoadIdentities(path string) (Identities, error) {
+	if path == "" {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ids := Identities{}
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return nil, fmt.Errorf("failed to parse identities %s: %w", path, err)
+	}
+	return ids, nil
+}
+
+// Identity возвращает идентификатор агента по сертификату
+func (m Identities) Identity(cert *x509.Certificate) (string, bool) {
+	if len(m) == 0 {
+		return cert.Subject.CommonName, cert.Subject.CommonName != ""
+	}
+	if id, ok := m[cert.Subject.String()]; ok {
+		return id, true
+	}
+	id, ok := m[cert.Subject.CommonName]
+	return id, ok
+}
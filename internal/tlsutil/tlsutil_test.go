@@ -0,0 +1,137 @@
+package tlsutil
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// issue выпускает сертификат, подписанный parent, и сохраняет его с ключом в dir
+func issue(t *testing.T, dir, name string, tmpl *x509.Certificate, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	if parent == nil {
+		parent, parentKey = tmpl, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, parentKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalPKCS8PrivateKey(key)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".crt"), pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name+".key"), pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600))
+	return cert, key
+}
+
+func newTemplate(serial int64, subject pkix.Name) *x509.Certificate {
+	return &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	caTmpl := newTemplate(1, pkix.Name{CommonName: "test ca"})
+	caTmpl.IsCA = true
+	caTmpl.BasicConstraintsValid = true
+	caTmpl.KeyUsage = x509.KeyUsageCertSign
+	ca, caKey := issue(t, dir, "ca", caTmpl, nil, nil)
+
+	srvTmpl := newTemplate(2, pkix.Name{CommonName: "server"})
+	srvTmpl.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1)}
+	srvTmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}
+	issue(t, dir, "server", srvTmpl, ca, caKey)
+
+	agentTmpl := newTemplate(3, pkix.Name{CommonName: "agent-1", Organization: []string{"Acme"}})
+	agentTmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+	agent, _ := issue(t, dir, "agent", agentTmpl, ca, caKey)
+
+	path := func(name string) string { return filepath.Join(dir, name) }
+	srvCfg, err := NewServerConfig(ServerOptions{
+		CertFile:     path("server.crt"),
+		KeyFile:      path("server.key"),
+		MinVersion:   "1.2",
+		ClientCAFile: path("ca.crt"),
+	})
+	require.NoError(t, err)
+
+	var peer *x509.Certificate
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		peer = r.TLS.PeerCertificates[0]
+	}))
+	srv.TLS = srvCfg
+	srv.StartTLS()
+	defer srv.Close()
+
+	withCert, err := NewClientConfig(ClientOptions{CAFile: path("ca.crt"), CertFile: path("agent.crt"), KeyFile: path("agent.key")})
+	require.NoError(t, err)
+	resp, err := (&http.Client{Transport: &http.Transport{TLSClientConfig: withCert}}).Get(srv.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.NotNil(t, peer)
+	assert.Equal(t, agent.Subject.String(), peer.Subject.String())
+
+	withoutCert, err := NewClientConfig(ClientOptions{CAFile: path("ca.crt")})
+	require.NoError(t, err)
+	_, err = (&http.Client{Transport: &http.Transport{TLSClientConfig: withoutCert}}).Get(srv.URL)
+	assert.Error(t, err, "client certificate is required")
+
+	untrusted, err := NewClientConfig(ClientOptions{})
+	require.NoError(t, err)
+	_, err = (&http.Client{Transport: &http.Transport{TLSClientConfig: untrusted}}).Get(srv.URL)
+	assert.Error(t, err, "server certificate is not trusted by system roots")
+
+	testCases := []struct {
+		name string
+		ids  Identities
+		want string
+		ok   bool
+	}{
+		{name: "common name by default", want: "agent-1", ok: true},
+		{name: "full subject", ids: Identities{"CN=agent-1,O=Acme": "collector"}, want: "collector", ok: true},
+		{name: "common name", ids: Identities{"agent-1": "collector"}, want: "collector", ok: true},
+		{name: "not mapped", ids: Identities{"agent-2": "collector"}},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			got, ok := test.ids.Identity(peer)
+			assert.Equal(t, test.ok, ok)
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestParseCiphers(t *testing.T) {
+	modern, err := ParseCiphers(CiphersModern)
+	require.NoError(t, err)
+	assert.Contains(t, modern, tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256)
+	assert.NotContains(t, modern, tls.TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA)
+
+	list, err := ParseCiphers("TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256")
+	require.NoError(t, err)
+	assert.Len(t, list, 2)
+
+	_, err = ParseCiphers("TLS_RSA_WITH_RC4_128_SHA")
+	assert.Error(t, err)
+	_, err = ParseVersion("1.0")
+	assert.Error(t, err)
+}
@@ -0,0 +1,137 @@
+// Package validation проверяет входящие метрики перед записью в хранилище
+package validation
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"regexp"
+
+	"github.com/lionslon/go-yapmetrics/internal/models"
+	"github.com/lionslon/go-yapmetrics/internal/problem"
+	"github.com/lionslon/go-yapmetrics/internal/storage"
+)
+
+// DefaultNamePattern допустимый синтаксис имени метрики по умолчанию
+const DefaultNamePattern = `^[A-Za-z_][A-Za-z0-9_.:-]*$`
+
+// DefaultMaxBatchItems максимальное число метрик в одном пакете по умолчанию
+const DefaultMaxBatchItems = 10000
+
+// Rules настройки проверки
+type Rules struct {
+	NamePattern   string // регулярное выражение для имени метрики
+	MaxNameLength int    // максимальная длина имени, не больше storage.MaxNameLength
+	MaxBatchItems int    // максимальное число метрик в пакете
+}
+
+// Validator проверяет метрики по заданным правилам
+type Validator struct {
+	name          *regexp.Regexp
+	maxNameLength int
+	maxBatchItems int
+}
+
+// New создает Validator, нулевые значения правил заменяются значениями по умолчанию
+func New(r Rules) (*Validator, error) {
+	if r.NamePattern == "" {
+		r.NamePattern = DefaultNamePattern
+	}
+	if r.MaxNameLength <= 0 || r.MaxNameLength > storage.MaxNameLength {
+		r.MaxNameLength = storage.MaxNameLength
+	}
+	if r.MaxBatchItems <= 0 {
+		r.MaxBatchItems = DefaultMaxBatchItems
+	}
+	re, err := regexp.Compile(r.NamePattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metric name pattern: %w", err)
+	}
+	return &Validator{
+		name:          re,
+		maxNameLength: r.MaxNameLength,
+		maxBatchItems: r.MaxBatchItems,
+	}, nil
+}
+
+// Default создает Validator с правилами по умолчанию
+func Default() *Validator {
+	v, err := New(Rules{})
+	if err != nil {
+		panic(err)
+	}
+	return v
+}
+
+// Name проверяет имя метрики
+func (v *Validator) Name(n string) *problem.Problem {
+	if n == "" {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidMetricID, "metric id is required")
+	}
+	if len(n) > v.maxNameLength {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidMetricID, fmt.Sprintf("metric id %.32q... is longer than %d characters", n, v.maxNameLength))
+	}
+	if !v.name.MatchString(n) {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidMetricID, fmt.Sprintf("metric id %q does not match %s", n, v.name))
+	}
+	return nil
+}
+
+// Type проверяет тип метрики
+func (v *Validator) Type(t string) *problem.Problem {
+	if t != "gauge" && t != "counter" {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidMetricType, fmt.Sprintf("invalid metric type %q, can only be 'gauge' or 'counter'", t))
+	}
+	return nil
+}
+
+// Gauge проверяет, что значение gauge конечно
+func (v *Validator) Gauge(n string, val float64) *problem.Problem {
+	if math.IsNaN(val) || math.IsInf(val, 0) {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidValue, fmt.Sprintf("gauge %q value must be finite", n))
+	}
+	return nil
+}
+
+// Metric проверяет метрику для записи: имя, тип и обязательные для типа поля
+func (v *Validator) Metric(m models.Metrics) *problem.Problem {
+	if p := v.Name(m.ID); p != nil {
+		return p
+	}
+	switch m.MType {
+	case "counter":
+		if m.Delta == nil {
+			return problem.New(http.StatusBadRequest, problem.CodeMissingDelta, fmt.Sprintf("counter %q requires delta", m.ID))
+		}
+		if m.Value != nil {
+			return problem.New(http.StatusBadRequest, problem.CodeUnexpectedField, fmt.Sprintf("counter %q must not have value", m.ID))
+		}
+	case "gauge":
+		if m.Value == nil {
+			return problem.New(http.StatusBadRequest, problem.CodeMissingValue, fmt.Sprintf("gauge %q requires value", m.ID))
+		}
+		if m.Delta != nil {
+			return problem.New(http.StatusBadRequest, problem.CodeUnexpectedField, fmt.Sprintf("gauge %q must not have delta", m.ID))
+		}
+		return v.Gauge(m.ID, *m.Value)
+	default:
+		return v.Type(m.MType)
+	}
+	return nil
+}
+
+// Query проверяет запрос значения метрики: нужны только имя и тип
+func (v *Validator) Query(m models.Metrics) *problem.Problem {
+	if m.ID == "" {
+		return problem.New(http.StatusBadRequest, problem.CodeInvalidMetricID, "metric id is required")
+	}
+	return v.Type(m.MType)
+}
+
+// Batch проверяет размер пакета метрик
+func (v *Validator) Batch(n int) *problem.Problem {
+	if n > v.maxBatchItems {
+		return problem.New(http.StatusRequestEntityTooLarge, problem.CodeBatchTooLarge, fmt.Sprintf("batch has %d items, max %d", n, v.maxBatchItems))
+	}
+	return nil
+}
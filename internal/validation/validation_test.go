@@ -0,0 +1,61 @@
+package validation
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/lionslon/go-yapmetrics/internal/models"
+	"github.com/lionslon/go-yapmetrics/internal/problem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMetric(t *testing.T) {
+	v, err := New(Rules{MaxNameLength: 10})
+	require.NoError(t, err)
+	delta := int64(1)
+	value := 1.5
+	nan := math.NaN()
+	inf := math.Inf(1)
+
+	testCases := []struct {
+		name   string
+		metric models.Metrics
+		code   string
+	}{
+		{name: "valid counter", metric: models.Metrics{ID: "PollCount", MType: "counter", Delta: &delta}},
+		{name: "valid gauge", metric: models.Metrics{ID: "cpu.user_1", MType: "gauge", Value: &value}},
+		{name: "empty name", metric: models.Metrics{MType: "gauge", Value: &value}, code: problem.CodeInvalidMetricID},
+		{name: "long name", metric: models.Metrics{ID: strings.Repeat("a", 11), MType: "gauge", Value: &value}, code: problem.CodeInvalidMetricID},
+		{name: "bad syntax", metric: models.Metrics{ID: "a b", MType: "gauge", Value: &value}, code: problem.CodeInvalidMetricID},
+		{name: "leading digit", metric: models.Metrics{ID: "1a", MType: "gauge", Value: &value}, code: problem.CodeInvalidMetricID},
+		{name: "NaN", metric: models.Metrics{ID: "a", MType: "gauge", Value: &nan}, code: problem.CodeInvalidValue},
+		{name: "Inf", metric: models.Metrics{ID: "a", MType: "gauge", Value: &inf}, code: problem.CodeInvalidValue},
+		{name: "unknown type", metric: models.Metrics{ID: "a", MType: "summary", Value: &value}, code: problem.CodeInvalidMetricType},
+		{name: "counter without delta", metric: models.Metrics{ID: "a", MType: "counter"}, code: problem.CodeMissingDelta},
+		{name: "gauge without value", metric: models.Metrics{ID: "a", MType: "gauge"}, code: problem.CodeMissingValue},
+	}
+	for _, test := range testCases {
+		t.Run(test.name, func(t *testing.T) {
+			p := v.Metric(test.metric)
+			if test.code == "" {
+				assert.Nil(t, p)
+				return
+			}
+			require.NotNil(t, p)
+			assert.Equal(t, test.code, p.Code)
+		})
+	}
+}
+
+func TestNew(t *testing.T) {
+	_, err := New(Rules{NamePattern: "("})
+	assert.Error(t, err)
+
+	v, err := New(Rules{MaxNameLength: 1000, MaxBatchItems: 2})
+	require.NoError(t, err)
+	assert.Nil(t, v.Batch(2))
+	assert.NotNil(t, v.Batch(3))
+	assert.NotNil(t, v.Name(strings.Repeat("a", 31)), "max name length is capped by the database column")
+}